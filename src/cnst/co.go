@@ -10,6 +10,11 @@ var (
 	H_XAUTH        = "X-Auth"
 	P_PREVIEW_TYPE = "previewType"
 	P_ROOTHASH     = "rootHash"
+	//signed url, signature, user, expiration unix time and allowed method
+	P_SIG        = "sig"
+	P_SIG_USER   = "sigUser"
+	P_SIG_EXP    = "sigExp"
+	P_SIG_METHOD = "sigMethod"
 )
 var (
 	// Version is the current File Browser version.
@@ -48,6 +53,7 @@ const (
 	R_DOWNLOAD = 5
 	R_SHARES   = 7
 	R_PLAYLIST = 8
+	R_GROUPS   = 9
 )
 
 var MIME_EXT = [][]string{{
@@ -90,6 +96,8 @@ func ErrorToHTTP(err error, gone bool) int {
 	switch {
 	case err == nil:
 		return http.StatusOK
+	case err == ErrQuotaExceeded:
+		return http.StatusInsufficientStorage
 	case os.IsPermission(err):
 		return http.StatusForbidden
 	case os.IsNotExist(err):
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/browsefile/backend/src/cnst"
+	"path"
+	"strings"
+	"time"
+)
+
+//routers, personal api token can be scoped to
+var APIScopes = []string{"resource", "download", "search", "playlist", "shares"}
+
+//scope suffix, limits router to reading
+const ScopeRead = ":read"
+
+//last used time written not more often, to keep state writes low
+const tokenTouch = time.Minute
+
+//long-lived token of scripts and sync tools, accepted in X-Auth header
+type APIToken struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+	//allowed routers, like download or resource:read
+	Scopes []string `json:"scopes"`
+	//path prefix inside user home, empty allows whole home
+	Path     string     `json:"path,omitempty"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"lastUsed,omitempty"`
+}
+
+func (t *APIToken) copy() *APIToken {
+	res := *t
+	res.Scopes = append([]string(nil), t.Scopes...)
+	res.LastUsed = copyTime(t.LastUsed)
+	return &res
+}
+
+func copyAPITokens(list []*APIToken) (res []*APIToken) {
+	for _, t := range list {
+		res = append(res, t.copy())
+	}
+	return
+}
+
+//check name and scopes, path cleaned
+func (t *APIToken) Validate() error {
+	if len(t.Name) == 0 {
+		return errors.New("token name is empty")
+	}
+	if len(t.Scopes) == 0 {
+		return errors.New("token needs at least one scope")
+	}
+	for _, s := range t.Scopes {
+		if !contains(APIScopes, strings.TrimSuffix(s, ScopeRead)) {
+			return fmt.Errorf("unknown scope %q, allowed %s, with optional %s", s, strings.Join(APIScopes, ", "), ScopeRead)
+		}
+	}
+	if len(t.Path) > 0 {
+		t.Path = path.Clean("/" + t.Path)
+	}
+	return nil
+}
+
+//router allowed by scopes, write means request changes something. Every path must be inside token path
+func (t *APIToken) Allows(router string, write bool, paths ...string) bool {
+	ok := false
+	for _, s := range t.Scopes {
+		if s == router || !write && s == router+ScopeRead {
+			ok = true
+			break
+		}
+	}
+	if !ok || len(t.Path) == 0 || t.Path == "/" {
+		return ok
+	}
+	//paths of other users shares are out of token path
+	if router == "shares" {
+		return false
+	}
+	for _, p := range paths {
+		p = path.Clean("/" + p)
+		if p != t.Path && !strings.HasPrefix(p, t.Path+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+func (cfg *GlobalConfig) AddAPIToken(username string, t *APIToken) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	i := cfg.getUserIndex(username)
+	if i < 0 {
+		return cnst.ErrNotExist
+	}
+	for _, a := range cfg.Users[i].APITokens {
+		if strings.EqualFold(a.Name, t.Name) {
+			return cnst.ErrExist
+		}
+	}
+	cfg.Users[i].APITokens = append(cfg.Users[i].APITokens, t.copy())
+	cfg.markDirty()
+	return nil
+}
+
+func (cfg *GlobalConfig) DeleteAPIToken(username, name string) error {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	i := cfg.getUserIndex(username)
+	if i < 0 {
+		return cnst.ErrNotExist
+	}
+	list := cfg.Users[i].APITokens
+	for k, a := range list {
+		if strings.EqualFold(a.Name, name) {
+			cfg.Users[i].APITokens = append(list[:k], list[k+1:]...)
+			cfg.markDirty()
+			stateLock.Lock()
+			delete(cfg.userState(cfg.Users[i].Username).TokensUsed, strings.ToLower(name))
+			stateLock.Unlock()
+			return nil
+		}
+	}
+	return cnst.ErrNotExist
+}
+
+//owner and token by token hash, copies returned
+func (cfg *GlobalConfig) GetUserByAPIToken(hash string) (*UserConfig, *APIToken, bool) {
+	updateLock.RLock()
+	var owner string
+	var res *APIToken
+	for _, u := range cfg.Users {
+		for _, t := range u.APITokens {
+			if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) == 1 {
+				owner, res = u.Username, t.copy()
+			}
+		}
+	}
+	updateLock.RUnlock()
+	if res == nil {
+		return nil, nil, false
+	}
+	u, ok := cfg.GetUserByUsername(owner)
+	return u, res, ok
+}
+
+//remember token use
+func (cfg *GlobalConfig) TouchAPIToken(username, name string) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	s := cfg.userState(username)
+	k := strings.ToLower(name)
+	now := time.Now()
+	if last, ok := s.TokensUsed[k]; ok && now.Sub(last) < tokenTouch {
+		return
+	}
+	if s.TokensUsed == nil {
+		s.TokensUsed = make(map[string]time.Time)
+	}
+	s.TokensUsed[k] = now
+	cfg.markStateDirty()
+}
@@ -0,0 +1,31 @@
+package config
+
+import "testing"
+
+func TestAPITokenScopes(t *testing.T) {
+	tk := &APIToken{Name: "sync", Scopes: []string{"download", "resource:read"}, Path: "photos/"}
+	if err := tk.Validate(); err != nil || tk.Path != "/photos" {
+		t.Fatal("token must be valid with clean path", err, tk.Path)
+	}
+	for _, c := range []struct {
+		router string
+		write  bool
+		path   string
+		ok     bool
+	}{
+		{"download", false, "/photos/a.jpg", true},
+		{"download", true, "/photos/a.jpg", true},
+		{"resource", false, "/photos", true},
+		{"resource", true, "/photos/a.jpg", false},
+		{"resource", false, "/photos2", false},
+		{"resource", false, "/photos/../docs", false},
+		{"search", false, "/photos", false},
+	} {
+		if tk.Allows(c.router, c.write, c.path) != c.ok {
+			t.Error("wrong result for", c.router, c.write, c.path)
+		}
+	}
+	if (&APIToken{Name: "x", Scopes: []string{"users"}}).Validate() == nil {
+		t.Error("account management scope must be refused")
+	}
+}
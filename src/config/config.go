@@ -1,22 +1,37 @@
 package config
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/browsefile/backend/src/cnst"
 	"gopkg.in/natefinch/lumberjack.v2"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 var (
 	updateLock = new(sync.RWMutex)
+	//guards dirty state, separate from update lock, since marked under it
+	dirtyLock = new(sync.Mutex)
+	//window to coalesce config changes into single write
+	writeDelay = 2 * time.Second
+)
+
+const (
+	defaultShutdownTimeout = 30
+	defaultConfigWatch     = 5
+	defaultHistorySize     = 10
 )
 var config *GlobalConfig
 var usersRam map[string]*UserConfig
@@ -28,11 +43,15 @@ update automatically
 */
 type GlobalConfig struct {
 	Users   []*UserConfig `json:"users"`
-	Http    *ListenConf   `json:"http"`
-	Tls     *ListenConf   `json:"https"`
-	Log     string        `json:"log"`
-	TLSKey  string        `json:"tlsKey"`
-	TLSCert string        `json:"tlsCert"`
+	//user groups, shares can be allowed to
+	Groups  []*GroupConfig `json:"groups,omitempty"`
+	Http    *ListenConf    `json:"http"`
+	Tls     *ListenConf    `json:"https"`
+	Log     string         `json:"log"`
+	TLSKey  string         `json:"tlsKey"`
+	TLSCert string         `json:"tlsCert"`
+	//generate self-signed certificate on first run, in case tls files missed
+	TLSSelfSigned bool `json:"tlsSelfSigned"`
 	// Scope is the Path the user has access to.
 	FilesPath      string `json:"filesPath"`
 	*CaptchaConfig `json:"captchaConfig"`
@@ -40,9 +59,36 @@ type GlobalConfig struct {
 	*PreviewConf   `json:"preview"`
 	//http://host:port that used behind DMZ
 	ExternalShareHost string `json:"externalShareHost"`
+	//public path or url app served at, like /files or https://example.com/files
+	BaseURL string `json:"baseURL"`
+	//comma separated ips or cidrs of reverse proxies, allowed to set X-Forwarded-* headers. Unix socket peers always allowed
+	TrustedProxies string `json:"trustedProxies"`
+	//seconds to wait for in-flight requests and preview jobs on shutdown
+	ShutdownTimeout int `json:"shutdownTimeout"`
+	//seconds between checks of config file for external edits, 0 disables watching
+	ConfigWatch int `json:"configWatch"`
+	//amount of previous config file versions to keep
+	HistorySize int `json:"historySize"`
+	//max bytes in user home for users without own quota, 0 is unlimited
+	DefaultQuota int64 `json:"defaultQuota"`
+	//password policy and hashing
+	Password *PasswordConf `json:"password,omitempty"`
+	//admins have to enroll totp
+	AdminTwoFactor bool `json:"adminTwoFactor"`
+	//failed login limits
+	Lockout *LockoutConf `json:"lockout,omitempty"`
 
 	//Path to config file
 	Path string `json:"-"`
+	//checksum of the config file content, last read or written by this process
+	fileHash [sha256.Size]byte
+	//settings from environment and flags, setting key to value
+	overrides map[string]*overrideValue
+	//true in case users, shares or settings changed since last write
+	dirty      bool
+	writeTimer *time.Timer
+	//sessions, last logins and other runtime state of users, stored apart from config
+	state runtimeState
 }
 
 type ListenConf struct {
@@ -55,11 +101,21 @@ type ListenConf struct {
 	// - 'none', which allows anyone to access the filebrowser instance.
 	// If 'Method' is set to 'proxy' the header configured below is used to identify the user.
 	AuthMethod string `json:"authMethod"`
+	//more addresses, unix sockets and systemd sockets
+	Listen []*ListenSpec `json:"listen,omitempty"`
 }
 
 func (l *ListenConf) copy() *ListenConf {
-	return &ListenConf{l.Port, l.IP, l.AuthMethod}
-
+	if l == nil {
+		return nil
+	}
+	res := &ListenConf{Port: l.Port, IP: l.IP, AuthMethod: l.AuthMethod}
+	for _, s := range l.Listen {
+		if s != nil {
+			res.Listen = append(res.Listen, &ListenSpec{Addr: s.Addr, AuthMethod: s.AuthMethod})
+		}
+	}
+	return res
 }
 
 // Auth settings.
@@ -104,6 +160,9 @@ func (cfg *GlobalConfig) GetExternal(hash string) (res *ShareItem, usr *UserConf
 }
 
 func (auth *Auth) copyAuth() *Auth {
+	if auth == nil {
+		return nil
+	}
 	return &Auth{
 		Key:    auth.Key,
 		Header: auth.Header,
@@ -111,6 +170,9 @@ func (auth *Auth) copyAuth() *Auth {
 
 }
 func (c *CaptchaConfig) copyCaptchaConfig() *CaptchaConfig {
+	if c == nil {
+		return nil
+	}
 	return &CaptchaConfig{
 		Key:    c.Key,
 		Secret: c.Secret,
@@ -125,6 +187,7 @@ type CaptchaConfig struct {
 	Secret string `json:"secret"`
 }
 
+//normalize share paths and recompute their hashes
 func (cfg *GlobalConfig) Verify() {
 	updateLock.RLock()
 	defer updateLock.RUnlock()
@@ -134,8 +197,6 @@ func (cfg *GlobalConfig) Verify() {
 			shr.Hash = GenShareHash(u.Username, shr.Path)
 		}
 	}
-
-	//todo
 }
 
 // ~/<<cfg_PATH>>/<<username>>/files
@@ -153,13 +214,61 @@ func (cfg *GlobalConfig) GetUserPreviewPath(userName string) string {
 	return filepath.Join(cfg.FilesPath, userName, "preview")
 }
 
-//read and initiate global config, if file missed, one will be created with default settings.
-func (cfg *GlobalConfig) ReadConfigFile() {
-	var paths []string
-	argumentPath := len(cfg.Path) > 0
-	if len(cfg.Path) > 0 {
+/*
+read and initiate global config, if file missed, one will be created with default settings.
+existing file must pass validation, otherwise error returned and config stays unapplied.
+*/
+func (cfg *GlobalConfig) ReadConfigFile() error {
+	paths, argumentPath := cfg.candidatePaths()
+	found := false
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			fmt.Fprintf(os.Stderr, "can't open %s ", p)
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		warns, err := cfg.parseConf(p)
+		if err != nil {
+			return err
+		}
+		for _, w := range warns {
+			fmt.Fprintln(os.Stderr, w)
+		}
+		cfg.Path = p
+		found = true
+		break
+	}
+	//if no paths fit, then try to use current process folder or path that set from cmd argument(preferred)
+	if !found {
+		if argumentPath {
+			cfg.Path = paths[0]
+		} else {
+			cfg.Path = paths[len(paths)-1]
+		}
+		cfg.setDefaults()
+		cfg.applyOverrides()
+	}
+	fmt.Fprintln(os.Stderr, "using config at path : "+cfg.Path)
+	cfg.readState()
+	if moved := cfg.moveState(cfg.Users); (cfg.migrateUsers() || moved) && found {
+		//persist permissions of configs written before them
+		cfg.markDirty()
+	}
+
+	config = cfg
+	cfg.RefreshUserRam()
+	cfg.setupLog()
+	cfg.Verify()
+	cfg.setUpPaths()
+
+	return nil
+}
+
+//config file locations in order of priority, path from cmd argument preferred
+func (cfg *GlobalConfig) candidatePaths() (paths []string, argumentPath bool) {
+	argumentPath = len(cfg.Path) > 0
+	if argumentPath {
 		paths = append(paths, cfg.Path)
-		argumentPath = true
 	}
 	paths = append(paths, cnst.FilePath1, cnst.FilePath2)
 	if !argumentPath {
@@ -168,48 +277,53 @@ func (cfg *GlobalConfig) ReadConfigFile() {
 			paths = append(paths, filepath.Join(curPath, cnst.FilePath1))
 		}
 	}
-	for i, p := range paths {
-		err := cfg.parseConf(p)
-		if err != nil && i < len(paths)-1 {
-			continue
-		}
-		cfg.Path = p
-		//if no paths fit, then try to use current process folder or path that set from cmd argument(preferred)
-		if i == len(paths)-1 && err != nil {
-			if argumentPath {
-				cfg.Path = paths[0]
-			}
-			cfg.FilesPath = filepath.Join(filepath.Dir(cfg.Path), "bf-data")
-
-			// DefaultUser is used on New, when no 'config' exists
-			cfg.Users = append(cfg.Users, &UserConfig{
-				Username:  "admin",
-				AllowNew:  true,
-				Admin:     true,
-				AllowEdit: true,
-				FirstRun:  true,
-				Password:  "admin",
-				ViewMode:  "mosaic",
-				Locale:    "en",
-			})
-			cfg.Http = &ListenConf{AuthMethod: "default", IP: "127.0.0.1", Port: 8999}
-			cfg.Tls = &ListenConf{AuthMethod: "", IP: "", Port: 0}
-			cfg.ExternalShareHost = "http://127.0.0.1:8999"
-			cfg.PreviewConf = &PreviewConf{Threads: 2, ScriptPath: filepath.Join(filepath.Dir(cfg.Path), "bfconvert.sh")}
-			cfg.CaptchaConfig = &CaptchaConfig{}
-			cfg.Auth = &Auth{Header: "X-Forwarded-User"}
-			cfg.Log = "stdout"
+	return
+}
+
+//set path to the existing config file, same way as ReadConfigFile does, false in case none found
+func (cfg *GlobalConfig) ResolvePath() bool {
+	paths, _ := cfg.candidatePaths()
+	for _, p := range paths {
+		if fileExists(p) {
+			cfg.Path = p
+			return true
 		}
-		break
 	}
-	fmt.Println("using config at path : " + cfg.Path)
+	return false
+}
 
-	config = cfg
-	cfg.RefreshUserRam()
-	cfg.setupLog()
-	cfg.Verify()
-	cfg.setUpPaths()
+//unix socket of running server, for admin commands. Lives in own folder, only owner can enter
+func (cfg *GlobalConfig) GetAdminSocketPath() string {
+	return filepath.Join(cfg.Path+".admin", "sock")
+}
 
+//default settings with single admin user, used on New, when no 'config' exists
+func (cfg *GlobalConfig) setDefaults() {
+	cfg.FilesPath = filepath.Join(filepath.Dir(cfg.Path), "bf-data")
+	cfg.Users = append(cfg.Users, &UserConfig{
+		Username:  "admin",
+		AllowNew:  true,
+		Admin:     true,
+		AllowEdit: true,
+		FirstRun:  true,
+		Password:  "admin",
+		ViewMode:  "mosaic",
+		Locale:    "en",
+	})
+	cfg.Http = &ListenConf{AuthMethod: "default", IP: "127.0.0.1", Port: 8999}
+	cfg.Tls = &ListenConf{AuthMethod: "", IP: "", Port: 0}
+	cfg.ExternalShareHost = "http://127.0.0.1:8999"
+	cfg.PreviewConf = &PreviewConf{Threads: 2}
+	//script is optional, point to it only if it shipped next to the config
+	if scr := filepath.Join(filepath.Dir(cfg.Path), "bfconvert.sh"); fileExists(scr) {
+		cfg.ScriptPath = scr
+	}
+	cfg.CaptchaConfig = &CaptchaConfig{}
+	cfg.Auth = &Auth{Header: "X-Forwarded-User"}
+	cfg.Log = "stdout"
+	cfg.ShutdownTimeout = defaultShutdownTimeout
+	cfg.ConfigWatch = defaultConfigWatch
+	cfg.HistorySize = defaultHistorySize
 }
 
 //setup paths for all users, validate shares, and symlinks
@@ -228,7 +342,7 @@ func (cfg *GlobalConfig) setUpPaths() {
 		//create user preview folder
 		createPath(cfg.GetUserPreviewPath(u.Username))
 
-		_ = cfg.checkDavFolder(u);
+		_ = cfg.checkDavFolder(u)
 		//fix bad symlinks, or build missed for share for specific user
 		for _, owner := range cfg.Users {
 			//skip same user
@@ -284,20 +398,6 @@ func (cfg *GlobalConfig) checkDavFolder(u *UserConfig) (err error) {
 	return
 }
 
-func (cfg *GlobalConfig) parseConf(p string) (r error) {
-	if jsonFile, err := os.Open(p); err == nil {
-		byteValue, _ := ioutil.ReadAll(jsonFile)
-		err = json.Unmarshal(byteValue, &cfg)
-		r = jsonFile.Close()
-	} else {
-		fmt.Printf("can't open %s", p)
-		fmt.Println(err)
-		r = err
-	}
-
-	return r
-}
-
 func (cfg *GlobalConfig) GetAdmin() *UserConfig {
 	for _, usr := range cfg.Users {
 		if usr.Admin {
@@ -307,23 +407,54 @@ func (cfg *GlobalConfig) GetAdmin() *UserConfig {
 	return nil
 }
 
-func (cfg *GlobalConfig) WriteConfig() {
+//remember that config changed, and schedule write, so changes in short window goes to single write
+func (cfg *GlobalConfig) markDirty() {
+	dirtyLock.Lock()
+	defer dirtyLock.Unlock()
+	cfg.dirty = true
+	if cfg.writeTimer == nil {
+		cfg.writeTimer = time.AfterFunc(writeDelay, cfg.flushTimer)
+	}
+}
+
+func (cfg *GlobalConfig) flushTimer() {
+	_ = cfg.Flush()
+}
+
+//write config in case it was changed since last write, failed write stays pending
+func (cfg *GlobalConfig) Flush() (err error) {
+	dirtyLock.Lock()
+	dirty := cfg.dirty
+	dirtyLock.Unlock()
+	if dirty {
+		err = cfg.WriteConfig()
+	}
+	cfg.FlushState()
+	return err
+}
+
+//write config through temp file, previous file content goes to the history. On error write retried later
+func (cfg *GlobalConfig) WriteConfig() error {
 	updateLock.Lock()
 	defer updateLock.Unlock()
-	//todo check hash if config changed
-	jsonData, err := json.MarshalIndent(cfg, "", "    ")
+	//overridden settings never goes to the file
+	jsonData, err := json.MarshalIndent(cfg.persisted(), "", "    ")
+	if err == nil {
+		err = cfg.writeFile(jsonData)
+	}
+	dirtyLock.Lock()
+	defer dirtyLock.Unlock()
+	if cfg.writeTimer != nil {
+		cfg.writeTimer.Stop()
+		cfg.writeTimer = nil
+	}
+	//changes are made under update lock, so all of them written
+	cfg.dirty = err != nil
 	if err != nil {
-		log.Println(err)
-	} else {
-		err = os.MkdirAll(filepath.Dir(cfg.Path), cnst.PERM_DEFAULT)
-		if err != nil {
-			log.Println(err)
-		}
-		err = ioutil.WriteFile(cfg.Path, jsonData, cnst.PERM_DEFAULT)
-		if err != nil {
-			log.Println("config : cant write config file", err)
-		}
+		log.Println("config : cant write config file, retry later", err)
+		cfg.writeTimer = time.AfterFunc(writeDelay, cfg.flushTimer)
 	}
+	return err
 }
 
 //should not be called directly
@@ -380,7 +511,8 @@ func (cfg *GlobalConfig) CopyConfig() *GlobalConfig {
 	defer updateLock.RUnlock()
 	res := &GlobalConfig{
 		Users:             cfg.GetUsers(),
-		Http:              &ListenConf{cfg.Http.Port, cfg.Http.IP, cfg.Http.AuthMethod},
+		Groups:            cfg.copyGroups(),
+		Http:              cfg.Http.copy(),
 		Log:               cfg.Log,
 		CaptchaConfig:     cfg.copyCaptchaConfig(),
 		Auth:              cfg.copyAuth(),
@@ -388,22 +520,43 @@ func (cfg *GlobalConfig) CopyConfig() *GlobalConfig {
 		FilesPath:         cfg.FilesPath,
 		TLSKey:            cfg.TLSKey,
 		TLSCert:           cfg.TLSCert,
+		TLSSelfSigned:     cfg.TLSSelfSigned,
 		ExternalShareHost: cfg.ExternalShareHost,
+		BaseURL:           cfg.BaseURL,
+		TrustedProxies:    cfg.TrustedProxies,
+		ShutdownTimeout:   cfg.ShutdownTimeout,
+		ConfigWatch:       cfg.ConfigWatch,
+		HistorySize:       cfg.HistorySize,
+		DefaultQuota:      cfg.DefaultQuota,
+		Password:          cfg.Password.copy(),
+		AdminTwoFactor:    cfg.AdminTwoFactor,
+		Lockout:           cfg.Lockout.copy(),
 		Path:              cfg.Path,
 	}
 	if cfg.Tls != nil {
-		res.Tls = &ListenConf{cfg.Tls.Port, cfg.Tls.IP, cfg.Tls.AuthMethod}
+		res.Tls = cfg.Tls.copy()
 	} else {
-		res.Tls = &ListenConf{0, "", ""}
+		res.Tls = &ListenConf{}
 	}
 
 	return res
 }
 
-//deep update config
-func (cfg *GlobalConfig) UpdateConfig(u *GlobalConfig) {
+/*
+deep update config, returns keys of changed settings, like http.port.
+log target and user paths applied here, rest is up to the owners of running parts
+*/
+func (cfg *GlobalConfig) UpdateConfig(u *GlobalConfig) []string {
+	changed := cfg.updateSettings(u)
+	cfg.applyChanged(changed)
+
+	return changed
+}
+
+func (cfg *GlobalConfig) updateSettings(u *GlobalConfig) []string {
 	updateLock.Lock()
 	defer updateLock.Unlock()
+	before := cfg.settingValues()
 	cfg.Http = u.Http.copy()
 	cfg.Tls = u.Tls.copy()
 	cfg.Log = u.Log
@@ -412,8 +565,165 @@ func (cfg *GlobalConfig) UpdateConfig(u *GlobalConfig) {
 	cfg.FilesPath = u.FilesPath
 	cfg.TLSCert = u.TLSCert
 	cfg.TLSKey = u.TLSKey
+	cfg.TLSSelfSigned = u.TLSSelfSigned
 	cfg.PreviewConf = u.PreviewConf
 	cfg.ExternalShareHost = u.ExternalShareHost
+	cfg.BaseURL = u.BaseURL
+	cfg.TrustedProxies = u.TrustedProxies
+	cfg.ShutdownTimeout = u.ShutdownTimeout
+	cfg.ConfigWatch = u.ConfigWatch
+	cfg.HistorySize = u.HistorySize
+	cfg.DefaultQuota = u.DefaultQuota
+	cfg.Password = u.Password.copy()
+	cfg.AdminTwoFactor = u.AdminTwoFactor
+	cfg.Lockout = u.Lockout.copy()
+	//read-only settings keep values from environment and flags
+	cfg.applyOverrides()
+	changed := cfg.changedSettings(before)
+	cfg.markDirty()
+	return changed
+}
+
+//apply changed log target and files path
+func (cfg *GlobalConfig) applyChanged(changed []string) {
+	for _, k := range changed {
+		switch k {
+		case "log":
+			cfg.setupLog()
+		case "filesPath":
+			cfg.setUpPaths()
+		}
+	}
+}
+
+/*
+public origin and path prefix of the app from baseURL, origin empty in case baseURL is just a path.
+path has leading and no trailing slash, empty for root
+*/
+func (cfg *GlobalConfig) GetBaseURL() (origin, path string) {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	origin, path, _ = parseBaseURL(cfg.BaseURL)
+	return
+}
+
+func parseBaseURL(s string) (origin, path string, err error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return "", "", err
+	}
+	if len(u.RawQuery) > 0 || len(u.Fragment) > 0 {
+		return "", "", errors.New("query and fragment not allowed")
+	}
+	if len(u.Scheme) > 0 || len(u.Host) > 0 {
+		if u.Scheme != "http" && u.Scheme != "https" || len(u.Host) == 0 {
+			return "", "", errors.New("must be a path or http(s)://host/path")
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+	path = strings.TrimSuffix(u.Path, "/")
+	if len(path) > 0 && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return
+}
+
+//check that request with remote address came from trusted reverse proxy
+func (cfg *GlobalConfig) IsTrustedProxy(remoteAddr string) bool {
+	updateLock.RLock()
+	proxies := cfg.TrustedProxies
+	updateLock.RUnlock()
+	if len(proxies) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	nets, _ := parseProxies(proxies)
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//comma separated ips and cidrs to networks
+func parseProxies(s string) (res []*net.IPNet, err error) {
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if len(p) == 0 {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return res, fmt.Errorf("%q is not an ip address or cidr", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, pErr := net.ParseCIDR(p)
+		if pErr != nil {
+			return res, fmt.Errorf("%q is not an ip address or cidr", p)
+		}
+		res = append(res, n)
+	}
+	return
+}
+
+//max bytes in user home, 0 is unlimited
+func (cfg *GlobalConfig) GetUserQuota(u *UserConfig) int64 {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	switch {
+	case u.Quota < 0:
+		return 0
+	case u.Quota > 0:
+		return u.Quota
+	}
+	return cfg.DefaultQuota
+}
+
+//paths to tls certificate and key
+func (cfg *GlobalConfig) GetTLSFiles() (cert, key string) {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	return cfg.TLSCert, cfg.TLSKey
+}
+
+//set paths of generated tls files, empty path keeps actual one
+func (cfg *GlobalConfig) SetTLSFiles(cert, key string) {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	if len(cert) > 0 && cfg.TLSCert != cert {
+		cfg.TLSCert = cert
+		cfg.markDirty()
+	}
+	if len(key) > 0 && cfg.TLSKey != key {
+		cfg.TLSKey = key
+		cfg.markDirty()
+	}
+}
+
+//how long shutdown may wait for in-flight requests, falls back to default if not set
+func (cfg *GlobalConfig) GetShutdownTimeout() time.Duration {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	t := cfg.ShutdownTimeout
+	if t <= 0 {
+		t = defaultShutdownTimeout
+	}
+	return time.Duration(t) * time.Second
 }
 
 //update salt key
@@ -421,4 +731,5 @@ func (cfg *GlobalConfig) SetKey(k []byte) {
 	updateLock.Lock()
 	defer updateLock.Unlock()
 	cfg.Auth.Key = base64.StdEncoding.EncodeToString(k)
+	cfg.markDirty()
 }
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"os"
 	"testing"
 )
@@ -60,3 +62,120 @@ func TestFirstRunPreviewGen(t *testing.T) {
 	cfg.WriteConfig()
 	cfg.ReadConfigFile()
 }
+
+func TestReloadConfig(t *testing.T) {
+	cfg := TContext{}
+	cfg.Init()
+	defer cfg.Clean(t)
+	cfg.WriteConfig()
+	if cfg.isFileChanged() {
+		t.Fatal("own write detected as external edit")
+	}
+	//external edit
+	ext := GlobalConfig{Path: cfg.Path, FilesPath: cfg.FilesPath}
+	_, _ = ext.parseConf(cfg.Path)
+	ext.Users = append(ext.Users, cfg.MakeUser("user3"))
+	ext.Http.AuthMethod = "proxy"
+	data, _ := json.Marshal(ext)
+	if err := ioutil.WriteFile(cfg.Path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.isFileChanged() {
+		t.Fatal("external edit not detected")
+	}
+	prepared := false
+	changed, err := cfg.ReloadConfigFile(func(n *GlobalConfig) error {
+		prepared = len(n.Users) == 2
+		return nil
+	})
+	if err != nil || !prepared {
+		t.Fatal("reload fail", err)
+	}
+	if len(changed) != 1 || changed[0] != "http.authMethod" {
+		t.Fatal("wrong changed settings", changed)
+	}
+	if _, ok := cfg.GetUserByUsername("user3"); !ok || cfg.Http.AuthMethod != "proxy" {
+		t.Fatal("reloaded config not applied")
+	}
+	if _, err = os.Stat(cfg.GetUserHomePath("user3")); err != nil {
+		t.Fatal("user paths not created", err)
+	}
+
+	//bad edits must keep running config
+	for _, bad := range []string{"{\"users\": [", "{\"users\": [], \"http\": {}, \"auth\": {}, \"preview\": {}}"} {
+		if err = ioutil.WriteFile(cfg.Path, []byte(bad), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cfg.ReloadConfigFile(nil); err == nil {
+			t.Fatal("bad config must fail to reload")
+		}
+		if _, ok := cfg.GetUserByUsername("user3"); !ok || len(cfg.Users) != 2 {
+			t.Fatal("running config modified by bad reload")
+		}
+	}
+}
+
+func TestDebouncedWrite(t *testing.T) {
+	cfg := TContext{}
+	cfg.Init()
+	defer cfg.Clean(t)
+	cfg.WriteConfig()
+	before := len(cfg.GetHistory())
+	for _, l := range []string{"fr", "de", "it"} {
+		admin, _ := cfg.GetUserByUsername("admin")
+		admin.Locale = l
+		_ = cfg.Update(admin)
+	}
+	if !cfg.dirty || cfg.writeTimer == nil {
+		t.Fatal("change must schedule write")
+	}
+	cfg.Flush()
+	if cfg.dirty || cfg.writeTimer != nil {
+		t.Fatal("flush must write pending changes")
+	}
+	if len(cfg.GetHistory()) != before+1 {
+		t.Fatal("changes must be written at once")
+	}
+	cfg2 := GlobalConfig{Path: cfg.Path, FilesPath: cfg.FilesPath}
+	cfg2.ReadConfigFile()
+	if u, _ := cfg2.GetUserByUsername("admin"); u.Locale != "it" {
+		t.Fatal("last change must be written")
+	}
+	//nothing to write
+	cfg.Flush()
+	if len(cfg.GetHistory()) != before+1 {
+		t.Fatal("not changed config must not be written")
+	}
+}
+
+func TestWriteKeepsExternalEdit(t *testing.T) {
+	cfg := TContext{}
+	cfg.Init()
+	defer cfg.Clean(t)
+	cfg.WriteConfig()
+	//edited outside inside debounce window
+	data, _ := ioutil.ReadFile(cfg.Path)
+	edit := append(data, '\n')
+	if err := ioutil.WriteFile(cfg.Path, edit, 0600); err != nil {
+		t.Fatal(err)
+	}
+	admin, _ := cfg.GetUserByUsername("admin")
+	admin.Locale = "fr"
+	_ = cfg.Update(admin)
+	if err := cfg.Flush(); err == nil {
+		t.Fatal("external edit must not be overwritten")
+	}
+	if cur, _ := ioutil.ReadFile(cfg.Path); string(cur) != string(edit) {
+		t.Fatal("external edit lost")
+	}
+	if !cfg.dirty || cfg.writeTimer == nil {
+		t.Fatal("failed write must stay pending")
+	}
+	//reload accepts the edit, pending write goes next
+	if _, err := cfg.ReloadConfigFile(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Flush(); err != nil || cfg.dirty {
+		t.Fatal("pending write must succeed after reload", err)
+	}
+}
@@ -49,6 +49,8 @@ func (tc *TContext) Init() {
 	tc.GlobalConfig = &cfg
 }
 func (tc *TContext) Clean(t *testing.T) {
+	//pending write should not recreate removed dir
+	tc.Flush()
 	err := os.RemoveAll(tc.ConfigPath)
 	if err != nil {
 		t.Fatal(err)
@@ -66,7 +68,10 @@ func (tc *TContext) InitWithUsers(t *testing.T) {
 	if err != nil {
 		t.Log(err)
 	}
-	previewSH = strings.TrimSuffix(previewSH, "/src/lib/web")
+	//script lives at repo root, tests run from package dirs
+	if i := strings.LastIndex(previewSH, "/src/"); i > 0 {
+		previewSH = previewSH[:i]
+	}
 	t.Log(previewSH)
 	tc.PreviewConf.ScriptPath = previewSH + "/bfconvert.sh"
 
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"errors"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/lib/utils"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//what happens with user's data on delete
+const (
+	//files stays on disk
+	DeleteKeep = "keep"
+	//home zipped to the archive folder, after removed
+	DeleteArchive = "archive"
+	//home moved to the target user, shares re-homed
+	DeleteMove = "move"
+	//everything removed
+	DeletePurge = "purge"
+)
+
+type DeleteOptions struct {
+	Mode string
+	//user receives files in move mode
+	Target string
+	//only report what will be touched
+	DryRun bool
+}
+
+//everything delete touches on disk and in config
+type DeletePlan struct {
+	Username string `json:"username"`
+	Mode     string `json:"mode"`
+	Target   string `json:"target,omitempty"`
+	//archive file, or folder files moved to
+	Destination string `json:"destination,omitempty"`
+	//paths removed from disk
+	Remove []string `json:"remove"`
+	//share paths of the user, re-homed in move mode, otherwise dropped
+	Shares []string `json:"shares"`
+	//share links removed from shares folders of consumers
+	Links []string `json:"links"`
+	//groups user leaves
+	Groups []string `json:"groups"`
+}
+
+// ~/<<config dir>>/<<config name>>.archive
+func (cfg *GlobalConfig) GetArchivePath() string {
+	return cfg.Path + ".archive"
+}
+
+//delete user according mode, in dry run nothing changes. Returned plan lists everything touched
+func (cfg *GlobalConfig) DeleteUserData(username string, opt *DeleteOptions) (plan *DeletePlan, err error) {
+	var shares []*ShareItem
+	if plan, shares, err = cfg.planDelete(username, opt); err != nil || opt.DryRun {
+		return
+	}
+	username = plan.Username
+	switch plan.Mode {
+	case DeleteArchive:
+		if err = archiveHome(cfg.FilesPath, cfg.GetUserHomePath(username), plan.Destination); err != nil {
+			return
+		}
+	case DeleteMove:
+		if err = os.Rename(cfg.GetUserHomePath(username), plan.Destination); err != nil {
+			return
+		}
+		rel := strings.TrimPrefix(plan.Destination, cfg.GetUserHomePath(plan.Target))
+		_ = os.Rename(cfg.GetUserPreviewPath(username), filepath.Join(cfg.GetUserPreviewPath(plan.Target), rel))
+		defer cfg.rehomeShares(shares, username, plan.Target, rel)
+	}
+	if err = cfg.DeleteUser(username); err != nil {
+		return
+	}
+	cfg.dropShareLinks(username)
+	for _, p := range plan.Remove {
+		if err = os.RemoveAll(p); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (cfg *GlobalConfig) planDelete(username string, opt *DeleteOptions) (*DeletePlan, []*ShareItem, error) {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	i := cfg.getUserIndex(username)
+	if i < 0 {
+		return nil, nil, cnst.ErrNotExist
+	}
+	u := cfg.Users[i]
+	plan := &DeletePlan{Username: u.Username, Mode: opt.Mode}
+	if len(plan.Mode) == 0 {
+		plan.Mode = DeleteKeep
+	}
+	switch plan.Mode {
+	case DeleteKeep:
+	case DeleteArchive:
+		plan.Destination = filepath.Join(cfg.GetArchivePath(), u.Username+"_"+time.Now().Format("20060102150405")+".zip")
+		plan.Remove = []string{cfg.GetDavPath(u.Username)}
+	case DeleteMove:
+		j := cfg.getUserIndex(opt.Target)
+		if j < 0 || j == i {
+			return nil, nil, errors.New("wrong target user " + opt.Target)
+		}
+		plan.Target = cfg.Users[j].Username
+		plan.Destination = freeName(filepath.Join(cfg.GetUserHomePath(plan.Target), u.Username))
+		plan.Remove = []string{cfg.GetDavPath(u.Username)}
+	case DeletePurge:
+		plan.Remove = []string{cfg.GetDavPath(u.Username)}
+	default:
+		return nil, nil, cnst.ErrInvalidOption
+	}
+
+	shares := make([]*ShareItem, len(u.Shares))
+	for k, shr := range u.Shares {
+		shares[k] = shr.copyShare()
+		plan.Shares = append(plan.Shares, shr.Path)
+	}
+	for _, c := range cfg.Users {
+		if c == u {
+			continue
+		}
+		dir := filepath.Join(cfg.GetUserSharesPath(c.Username), u.Username)
+		_ = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err == nil {
+				plan.Links = append(plan.Links, p)
+			}
+			return nil
+		})
+	}
+	for _, g := range cfg.Groups {
+		if g != nil && hasName(g.Members, u.Username) {
+			plan.Groups = append(plan.Groups, g.Name)
+		}
+	}
+	return plan, shares, nil
+}
+
+//shares of deleted user goes to the target, under folder files moved to
+func (cfg *GlobalConfig) rehomeShares(shares []*ShareItem, owner, target, rel string) {
+	t, ok := cfg.GetUserByUsername(target)
+	if !ok || len(shares) == 0 {
+		return
+	}
+	for _, shr := range shares {
+		shr.Path = filepath.ToSlash(filepath.Join(rel, shr.Path))
+		users := shr.AllowUsers[:0]
+		for _, n := range shr.AllowUsers {
+			if !strings.EqualFold(n, target) && !strings.EqualFold(n, owner) {
+				users = append(users, n)
+			}
+		}
+		shr.AllowUsers = users
+		t.AddShare(shr)
+	}
+	_ = cfg.Update(t)
+}
+
+//path itself, or path with number suffix that not exists yet
+func freeName(p string) string {
+	res := p
+	for i := 1; utils.Exists(res); i++ {
+		res = p + "_" + strconv.Itoa(i)
+	}
+	return res
+}
+
+//zip regular files of home. Archive cuts filesPath and <<username>>/files after it, so paths inside relative to the home
+func archiveHome(filesPath, home, dst string) (err error) {
+	var paths []string
+	var infos []os.FileInfo
+	err = filepath.Walk(home, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			paths = append(paths, p)
+			infos = append(infos, info)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(dst), cnst.PERM_DEFAULT); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err = utils.ServeArchiveCompress(paths, filesPath, f, infos); err != nil {
+		_ = f.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return f.Close()
+}
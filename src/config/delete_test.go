@@ -0,0 +1,91 @@
+package config
+
+import (
+	"archive/zip"
+	"github.com/browsefile/backend/src/lib/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDeleteUserDryRun(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	cfg.Usr1.AddShare(&ShareItem{Path: cfg.SharePathUp, AllowLocal: true})
+	_ = cfg.Update(cfg.Usr1)
+	_ = cfg.SetGroup(&GroupConfig{Name: "family", Members: []string{cfg.Usr1.Username}})
+
+	if _, err := cfg.DeleteUserData("user1", &DeleteOptions{Mode: "bad", DryRun: true}); err == nil {
+		t.Fatal("unknown mode must fail")
+	}
+	if _, err := cfg.DeleteUserData("user1", &DeleteOptions{Mode: DeleteMove, Target: "user1", DryRun: true}); err == nil {
+		t.Fatal("move to self must fail")
+	}
+	plan, err := cfg.DeleteUserData("user1", &DeleteOptions{Mode: DeletePurge, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Remove) != 1 || len(plan.Shares) != 1 || len(plan.Groups) != 1 || len(plan.Links) < 4 {
+		t.Fatal("wrong plan", plan)
+	}
+	if _, ok := cfg.GetUserByUsername("user1"); !ok || !utils.Exists(cfg.GetUserHomePath("user1")) {
+		t.Fatal("dry run must not change anything")
+	}
+}
+
+func TestDeleteUserModes(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	shr := &ShareItem{Path: cfg.SharePathDeep, AllowUsers: []string{"admin", cfg.Usr2.Username}}
+	cfg.Usr1.AddShare(shr)
+	_ = cfg.Update(cfg.Usr1)
+
+	//files and shares goes to user2
+	plan, err := cfg.DeleteUserData("user1", &DeleteOptions{Mode: DeleteMove, Target: cfg.Usr2.Username})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.GetUserByUsername("user1"); ok || utils.Exists(cfg.GetDavPath("user1")) {
+		t.Fatal("user must be deleted")
+	}
+	if _, err = cfg.User2FS.Stat(filepath.Join("user1", cfg.SharePathDeep)); err != nil {
+		t.Fatal("files must be moved", plan.Destination, err)
+	}
+	if _, err = os.Stat(filepath.Join(cfg.GetUserSharesPath("admin"), "user1")); err == nil {
+		t.Fatal("consumer share folder must be removed")
+	}
+	u2, _ := cfg.GetUserByUsername(cfg.Usr2.Username)
+	moved := u2.GetShares(filepath.Join("/user1", cfg.SharePathDeep), false)
+	if len(moved) != 1 {
+		t.Fatal("share must be re-homed", u2.Shares)
+	}
+	p, _ := moved[0].ResolveSymlinkName()
+	if _, err = cfg.AdminFSShare.Stat(filepath.Join(cfg.Usr2.Username, p)); err != nil {
+		t.Fatal("consumer must get re-homed share", err)
+	}
+
+	//home zipped into archive folder
+	plan, err = cfg.DeleteUserData(cfg.Usr2.Username, &DeleteOptions{Mode: DeleteArchive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.OpenReader(plan.Destination)
+	if err != nil {
+		t.Fatal("archive must be written", err)
+	}
+	_ = r.Close()
+	if len(r.File) == 0 || utils.Exists(cfg.GetDavPath(cfg.Usr2.Username)) {
+		t.Fatal("home must be archived and removed")
+	}
+	for _, f := range r.File {
+		if strings.HasPrefix(f.Name, "/") || strings.Contains(f.Name, cfg.Usr2.Username+"/files") {
+			t.Fatal("archive paths must be relative to home", f.Name)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(cfg.GetUserSharesPath("admin"), cfg.Usr2.Username)); err == nil {
+		t.Fatal("consumer share folder must be removed")
+	}
+}
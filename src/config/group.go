@@ -0,0 +1,209 @@
+package config
+
+import (
+	"errors"
+	"github.com/browsefile/backend/src/cnst"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//named set of users, shares can be allowed to the whole group
+type GroupConfig struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+	//default permissions of members, granted in addition to their own
+	Perms *Permissions `json:"perms,omitempty"`
+}
+
+func (g *GroupConfig) copy() *GroupConfig {
+	res := &GroupConfig{Name: g.Name, Perms: g.Perms.copy(), Members: make([]string, len(g.Members))}
+	copy(res.Members, g.Members)
+	return res
+}
+
+func (cfg *GlobalConfig) GetGroups() (res []*GroupConfig) {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	return cfg.copyGroups()
+}
+
+func (cfg *GlobalConfig) copyGroups() (res []*GroupConfig) {
+	for _, g := range cfg.Groups {
+		if g != nil {
+			res = append(res, g.copy())
+		}
+	}
+	return
+}
+
+func (cfg *GlobalConfig) GetGroup(name string) (*GroupConfig, bool) {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	if i := cfg.getGroupIndex(name); i >= 0 {
+		return cfg.Groups[i].copy(), true
+	}
+	return nil, false
+}
+
+func (cfg *GlobalConfig) getGroupIndex(name string) int {
+	for i, g := range cfg.Groups {
+		if g != nil && strings.EqualFold(g.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+//add new or replace existing group, share links of users joined or left the group updated at once
+func (cfg *GlobalConfig) SetGroup(g *GroupConfig) error {
+	if len(g.Name) == 0 {
+		return errors.New("group name is empty")
+	}
+	g = g.copy()
+	updateLock.Lock()
+	for j, m := range g.Members {
+		//usernames only, ips of ip auth are not members
+		i := cfg.getUserIndex(m)
+		if i < 0 || strings.EqualFold(m, cnst.GUEST) {
+			updateLock.Unlock()
+			return errors.New("unknown user " + m)
+		}
+		g.Members[j] = cfg.Users[i].Username
+	}
+	var before []string
+	if i := cfg.getGroupIndex(g.Name); i >= 0 {
+		before = cfg.Groups[i].Members
+		cfg.Groups[i] = g
+	} else {
+		cfg.Groups = append(cfg.Groups, g)
+	}
+	cfg.markDirty()
+	updateLock.Unlock()
+
+	cfg.refreshGroupShares(g.Name, membersDiff(before, g.Members))
+	return nil
+}
+
+func (cfg *GlobalConfig) DeleteGroup(name string) error {
+	updateLock.Lock()
+	i := cfg.getGroupIndex(name)
+	if i < 0 {
+		updateLock.Unlock()
+		return cnst.ErrNotExist
+	}
+	members := cfg.Groups[i].Members
+	cfg.Groups = append(cfg.Groups[:i], cfg.Groups[i+1:]...)
+	cfg.markDirty()
+	updateLock.Unlock()
+
+	cfg.refreshGroupShares(name, members)
+	return nil
+}
+
+//users present only in one of lists
+func membersDiff(a, b []string) (res []string) {
+	for _, m := range a {
+		if !hasName(b, m) {
+			res = append(res, m)
+		}
+	}
+	for _, m := range b {
+		if !hasName(a, m) {
+			res = append(res, m)
+		}
+	}
+	return
+}
+
+//true in case user is member of any of groups, caller holds the lock
+func (cfg *GlobalConfig) inGroups(username string, groups []string) bool {
+	for _, name := range groups {
+		if i := cfg.getGroupIndex(name); i >= 0 && hasName(cfg.Groups[i].Members, username) {
+			return true
+		}
+	}
+	return false
+}
+
+//members of groups, caller holds the lock
+func (cfg *GlobalConfig) groupMembers(groups []string) (res []string) {
+	for _, name := range groups {
+		if i := cfg.getGroupIndex(name); i >= 0 {
+			res = append(res, cfg.Groups[i].Members...)
+		}
+	}
+	return
+}
+
+//true in case any group of the user grants permission
+func (cfg *GlobalConfig) groupCan(username, perm string) bool {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	for _, g := range cfg.Groups {
+		if g != nil && g.Perms != nil && hasName(g.Members, username) {
+			if f := g.Perms.field(perm); f != nil && *f {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//drop user from all groups, caller holds the lock
+func (cfg *GlobalConfig) removeMember(username string) {
+	for _, g := range cfg.Groups {
+		if g == nil {
+			continue
+		}
+		members := g.Members[:0]
+		for _, m := range g.Members {
+			if !strings.EqualFold(m, username) {
+				members = append(members, m)
+			}
+		}
+		g.Members = members
+	}
+}
+
+//create or remove share links of users, for shares allowed to the group
+func (cfg *GlobalConfig) refreshGroupShares(group string, users []string) {
+	if len(users) == 0 {
+		return
+	}
+	for _, owner := range cfg.GetUsers() {
+		for _, shr := range owner.Shares {
+			if !hasName(shr.AllowGroups, group) {
+				continue
+			}
+			for _, consumer := range users {
+				if strings.EqualFold(consumer, owner.Username) {
+					continue
+				}
+				if shr.IsAllowed(consumer) {
+					cfg.checkShareSymLinkPath(shr, consumer, owner.Username)
+				} else {
+					cfg.removeShareLink(shr, consumer, owner.Username)
+				}
+			}
+		}
+	}
+}
+
+//remove share link from consumer's shares folder
+func (cfg *GlobalConfig) removeShareLink(shr *ShareItem, consumer, owner string) {
+	p, err := shr.ResolveSymlinkName()
+	if err != nil {
+		return
+	}
+	_ = os.Remove(filepath.Join(cfg.GetUserSharesPath(consumer), owner, p))
+}
+
+func hasName(list []string, name string) bool {
+	for _, n := range list {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGroupShares(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	if err := cfg.SetGroup(&GroupConfig{Name: "family", Members: []string{cfg.Usr2.Username}}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SetGroup(&GroupConfig{Name: "bad", Members: []string{"nobody"}}) == nil {
+		t.Fatal("unknown member must fail")
+	}
+	cfg.Usr1.IpAuth = []string{"192.168.1.5"}
+	_ = cfg.Update(cfg.Usr1)
+	if cfg.SetGroup(&GroupConfig{Name: "bad", Members: []string{"192.168.1.5"}}) == nil {
+		t.Fatal("ip of ip auth is not a member")
+	}
+	shr := &ShareItem{Path: cfg.SharePathDeep, AllowGroups: []string{"family"}}
+	cfg.Usr1.AddShare(shr)
+	_ = cfg.Update(cfg.Usr1)
+	p, _ := shr.ResolveSymlinkName()
+	link := filepath.Join(cfg.Usr1.Username, p)
+	if !shr.IsAllowed(cfg.Usr2.Username) || shr.IsAllowed("admin") {
+		t.Fatal("wrong group access")
+	}
+	if _, err := cfg.User2FSShare.Stat(link); err != nil {
+		t.Fatal("group member must get share", err)
+	}
+	//membership changed
+	_ = cfg.SetGroup(&GroupConfig{Name: "family", Members: []string{"admin"}})
+	if _, err := cfg.User2FSShare.Stat(link); err == nil {
+		t.Fatal("share link must be removed from former member")
+	}
+	if _, err := cfg.AdminFSShare.Stat(link); err != nil {
+		t.Fatal("new member must get share", err)
+	}
+	//share links rebuilt from config
+	cfg.Flush()
+	cfg.ReadConfigFile()
+	if _, err := cfg.AdminFSShare.Stat(link); err != nil {
+		t.Fatal("group share lost after read", err)
+	}
+	_ = cfg.DeleteGroup("family")
+	if _, err := cfg.AdminFSShare.Stat(link); err == nil {
+		t.Fatal("share link must be removed with group")
+	}
+}
+
+func TestGroupPerms(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	cfg.Usr2.Perms = &Permissions{Download: true}
+	_ = cfg.Update(cfg.Usr2)
+	u, _ := cfg.GetUserByUsername(cfg.Usr2.Username)
+	if u.Can(PermUpload) {
+		t.Fatal("upload must be denied")
+	}
+	_ = cfg.SetGroup(&GroupConfig{Name: "uploaders", Members: []string{u.Username}, Perms: &Permissions{Upload: true}})
+	if !u.Can(PermUpload) || u.Can(PermDelete) {
+		t.Fatal("group permissions not applied")
+	}
+	_ = cfg.DeleteUser(u.Username)
+	if g, _ := cfg.GetGroup("uploaders"); len(g.Members) != 0 {
+		t.Fatal("deleted user must leave groups", g.Members)
+	}
+}
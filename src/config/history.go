@@ -0,0 +1,369 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/browsefile/backend/src/cnst"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const historyIdFormat = "20060102-150405.000000000"
+
+var errFileChanged = errors.New("config file changed outside since last read")
+
+//config and its history holds password hashes and keys, so readable by owner only
+const (
+	secretFilePerm os.FileMode = 0600
+	secretDirPerm  os.FileMode = 0700
+)
+
+//single previous version of config file
+type HistoryItem struct {
+	ID       string    `json:"id"`
+	Modified time.Time `json:"modified"`
+	Size     int64     `json:"size"`
+}
+
+// ~/<<config dir>>/<<config name>>.history
+func (cfg *GlobalConfig) GetHistoryPath() string {
+	return cfg.Path + ".history"
+}
+
+/*
+write data to temp file near config, and rename it to the config path, so the file never stays half written.
+previous content is saved to history. Must be called under update lock.
+*/
+func (cfg *GlobalConfig) writeFile(data []byte) (err error) {
+	dir := filepath.Dir(cfg.Path)
+	if err = os.MkdirAll(dir, cnst.PERM_DEFAULT); err != nil {
+		return err
+	}
+	if cur, rErr := ioutil.ReadFile(cfg.Path); rErr == nil {
+		//nothing changed, keep file and history as is
+		if bytes.Equal(cur, data) {
+			cfg.fileHash = sha256.Sum256(data)
+			return nil
+		}
+		//edit made outside wins, it is applied by reload
+		if cfg.fileHash != ([sha256.Size]byte{}) && sha256.Sum256(cur) != cfg.fileHash {
+			return errFileChanged
+		}
+	}
+	if err = writeTemp(cfg.Path, data, secretFilePerm, cfg.saveHistory); err != nil {
+		return err
+	}
+	cfg.fileHash = sha256.Sum256(data)
+
+	return nil
+}
+
+//write data to p through temp file in the same folder, before called right before temp file replaces p
+func writeTemp(p string, data []byte, perm os.FileMode, before func()) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if before != nil {
+		before()
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+//copy current config file to the history, and drop the oldest versions
+func (cfg *GlobalConfig) saveHistory() {
+	size := cfg.HistorySize
+	if size == 0 {
+		size = defaultHistorySize
+	}
+	if size < 0 {
+		return
+	}
+	cur, err := ioutil.ReadFile(cfg.Path)
+	if err != nil {
+		return
+	}
+	hp := cfg.GetHistoryPath()
+	if err = os.MkdirAll(hp, secretDirPerm); err == nil {
+		//folder might be created by older version
+		err = os.Chmod(hp, secretDirPerm)
+	}
+	if err != nil {
+		log.Println("config : cant create history folder", err)
+		return
+	}
+	id := time.Now().UTC().Format(historyIdFormat)
+	if err = ioutil.WriteFile(filepath.Join(hp, id+".json"), cur, secretFilePerm); err != nil {
+		log.Println("config : cant save history", err)
+		return
+	}
+	items := cfg.listHistory()
+	for i := size; i < len(items); i++ {
+		_ = os.Remove(filepath.Join(hp, items[i].ID+".json"))
+	}
+}
+
+//previous config versions, newest first
+func (cfg *GlobalConfig) GetHistory() []*HistoryItem {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	return cfg.listHistory()
+}
+
+func (cfg *GlobalConfig) listHistory() (res []*HistoryItem) {
+	infos, err := ioutil.ReadDir(cfg.GetHistoryPath())
+	if err != nil {
+		return []*HistoryItem{}
+	}
+	for _, inf := range infos {
+		if inf.IsDir() || filepath.Ext(inf.Name()) != ".json" {
+			continue
+		}
+		res = append(res, &HistoryItem{
+			ID:       strings.TrimSuffix(inf.Name(), ".json"),
+			Modified: inf.ModTime(),
+			Size:     inf.Size(),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID > res[j].ID
+	})
+	if res == nil {
+		res = []*HistoryItem{}
+	}
+	return res
+}
+
+//read version content, "current" or empty id means the actual config file
+func (cfg *GlobalConfig) ReadHistory(id string) ([]byte, error) {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	p, err := cfg.historyItemPath(id)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(p)
+}
+
+func (cfg *GlobalConfig) historyItemPath(id string) (string, error) {
+	if len(id) == 0 || id == "current" {
+		return cfg.Path, nil
+	}
+	if _, err := time.Parse(historyIdFormat, id); err != nil {
+		return "", cnst.ErrNotExist
+	}
+	return filepath.Join(cfg.GetHistoryPath(), id+".json"), nil
+}
+
+//unified diff between 2 versions, "current" or empty id means the actual config file
+func (cfg *GlobalConfig) DiffHistory(from, to string) ([]string, error) {
+	a, err := cfg.ReadHistory(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := cfg.ReadHistory(to)
+	if err != nil {
+		return nil, err
+	}
+	return diffLines(maskSecrets(splitLines(a)), maskSecrets(splitLines(b)), 3), nil
+}
+
+/*
+put specific version back as the actual config file, current one goes to the history.
+Version must pass same validation as reload, after it should be applied by ReloadConfigFile.
+*/
+func (cfg *GlobalConfig) RestoreHistory(id string) error {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	if len(id) == 0 || id == "current" {
+		return cnst.ErrInvalidOption
+	}
+	p, err := cfg.historyItemPath(id)
+	if err != nil {
+		return err
+	}
+	if _, err = cfg.loadConfigFile(p); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	return cfg.writeFile(data)
+}
+
+func splitLines(data []byte) []string {
+	return strings.Split(string(bytes.TrimRight(data, "\n")), "\n")
+}
+
+//values of password hashes, totp secrets, keys and token hashes, hidden in diffs
+var secretLine = regexp.MustCompile(`^(\s*"(?:password|secret|key|hash)"\s*:\s*)"([^"]*)"`)
+var recoveryLine = regexp.MustCompile(`^\s*"recovery"\s*:\s*\[\s*$`)
+var quotedValue = regexp.MustCompile(`"[^"]*"`)
+
+//replace secret values by short fingerprint, so diff shows that secret changed, but not the secret itself
+func maskSecrets(lines []string) []string {
+	res := make([]string, len(lines))
+	recovery := false
+	for i, l := range lines {
+		switch {
+		case recovery && strings.HasPrefix(strings.TrimSpace(l), "]"):
+			recovery = false
+		case recovery:
+			l = quotedValue.ReplaceAllStringFunc(l, maskValue)
+		case recoveryLine.MatchString(l):
+			recovery = true
+		default:
+			if m := secretLine.FindStringSubmatchIndex(l); m != nil && m[5] > m[4] {
+				l = l[:m[3]] + maskValue(l[m[3]:m[1]]) + l[m[1]:]
+			}
+		}
+		res[i] = l
+	}
+	return res
+}
+
+func maskValue(quoted string) string {
+	h := sha256.Sum256([]byte(quoted))
+	return fmt.Sprintf(`"***%x"`, h[:3])
+}
+
+//max cells of lcs table, bigger changed parts shown as whole replacement
+const maxDiffCells = 1 << 20
+
+type diffLine struct {
+	op     byte
+	text   string
+	ai, bi int
+}
+
+//line based diff with ctx lines of context around changes, in unified format
+func diffLines(a, b []string, ctx int) (res []string) {
+	//common head and tail never compared line by line
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	var lines []diffLine
+	for k := 0; k < pre; k++ {
+		lines = append(lines, diffLine{' ', a[k], k, k})
+	}
+	lines = append(lines, diffMiddle(a[pre:len(a)-suf], b[pre:len(b)-suf], pre)...)
+	for k := suf; k > 0; k-- {
+		lines = append(lines, diffLine{' ', a[len(a)-k], len(a) - k, len(b) - k})
+	}
+	//group changes into hunks
+	for k := 0; k < len(lines); {
+		if lines[k].op == ' ' {
+			k++
+			continue
+		}
+		start := k - ctx
+		if start < 0 {
+			start = 0
+		}
+		//merge next changes, while they close enough to share context
+		last := k
+		for n := k + 1; n < len(lines) && n-last <= 2*ctx; n++ {
+			if lines[n].op != ' ' {
+				last = n
+			}
+		}
+		end := last + ctx + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+		var aLen, bLen int
+		for _, l := range lines[start:end] {
+			if l.op != '+' {
+				aLen++
+			}
+			if l.op != '-' {
+				bLen++
+			}
+		}
+		res = append(res, fmt.Sprintf("@@ -%d,%d +%d,%d @@", lines[start].ai+1, aLen, lines[start].bi+1, bLen))
+		for _, l := range lines[start:end] {
+			res = append(res, string(l.op)+l.text)
+		}
+		k = end
+	}
+	if res == nil {
+		res = []string{}
+	}
+	return res
+}
+
+//lcs diff of changed part, off is line number of its start
+func diffMiddle(a, b []string, off int) (lines []diffLine) {
+	if len(a)*len(b) > maxDiffCells {
+		for i, l := range a {
+			lines = append(lines, diffLine{'-', l, off + i, off})
+		}
+		for j, l := range b {
+			lines = append(lines, diffLine{'+', l, off + len(a), off + j})
+		}
+		return
+	}
+	//lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i], off + i, off + j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i], off + i, off + j})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j], off + i, off + j})
+			j++
+		}
+	}
+	return
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWriteHistory(t *testing.T) {
+	cfg := TContext{}
+	cfg.Init()
+	defer cfg.Clean(t)
+	cfg.HistorySize = 3
+	cfg.WriteConfig()
+	if len(cfg.GetHistory()) != 0 {
+		t.Fatal("first write has no previous version")
+	}
+	for i := 0; i < 5; i++ {
+		cfg.Http.Port = 9000 + i
+		cfg.ExternalShareHost = "http://127.0.0.1:" + strconv.Itoa(9000+i)
+		cfg.WriteConfig()
+	}
+	h := cfg.GetHistory()
+	if len(h) != 3 {
+		t.Fatal("history must be rotated to 3, but", len(h))
+	}
+	//no temp files left
+	infos, _ := ioutil.ReadDir(cfg.ConfigPath)
+	for _, inf := range infos {
+		if strings.HasSuffix(inf.Name(), ".tmp") {
+			t.Fatal("temp file left", inf.Name())
+		}
+	}
+	//secrets inside, owner only
+	for _, p := range []string{cfg.Path, cfg.GetHistoryPath(), filepath.Join(cfg.GetHistoryPath(), h[0].ID+".json")} {
+		inf, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inf.Mode().Perm()&0077 != 0 {
+			t.Error("must be accessible by owner only", p, inf.Mode())
+		}
+	}
+
+	//newest version has port 9003, current 9004
+	diff, err := cfg.DiffHistory(h[0].ID, "current")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.Join(diff, "\n"), "-        \"port\": 9003,\n+        \"port\": 9004,") {
+		t.Fatal("wrong diff", diff)
+	}
+	if _, err = cfg.DiffHistory("../../etc/passwd", "current"); err == nil {
+		t.Fatal("only history ids allowed")
+	}
+
+	if err = cfg.RestoreHistory(h[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cfg.ReloadConfigFile(nil); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Http.Port != 9001 || cfg.ExternalShareHost != "http://127.0.0.1:9001" {
+		t.Fatal("version not restored", cfg.Http.Port)
+	}
+	if len(cfg.GetHistory()) != 3 {
+		t.Fatal("restore must keep replaced version in history")
+	}
+	if err = ioutil.WriteFile(cfg.GetHistoryPath()+"/"+h[0].ID+".json", []byte("{"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.RestoreHistory(h[0].ID); err == nil {
+		t.Fatal("broken version must not be restored")
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	a := strings.Split("1 2 3 4 5 6 7 8 9 10 11 12", " ")
+	b := strings.Split("1 2 3 x 5 6 7 8 9 10 11 12 13", " ")
+	res := strings.Join(diffLines(a, b, 1), ",")
+	if res != "@@ -3,3 +3,3 @@, 3,-4,+x, 5,@@ -12,1 +12,2 @@, 12,+13" {
+		t.Fatal("wrong diff", res)
+	}
+	if len(diffLines(a, a, 3)) != 0 {
+		t.Fatal("same content must not have diff")
+	}
+}
+
+func TestDiffLarge(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 2000; i++ {
+		a = append(a, "a"+strconv.Itoa(i))
+		b = append(b, "b"+strconv.Itoa(i))
+	}
+	a = append([]string{"same"}, a...)
+	b = append([]string{"same"}, b...)
+	res := diffLines(a, b, 1)
+	if res[0] != "@@ -1,2001 +1,2001 @@" || len(res) != 4002 {
+		t.Fatal("wrong diff of large change", res[0], len(res))
+	}
+}
+
+func TestMaskSecrets(t *testing.T) {
+	a := maskSecrets([]string{`"password": "hash1",`, `"recovery": [`, `    "code1",`, `],`, `"username": "bob"`})
+	b := maskSecrets([]string{`"password": "hash2",`, `"recovery": [`, `    "code1",`, `],`, `"username": "bob"`})
+	res := strings.Join(diffLines(a, b, 0), "\n")
+	if strings.Contains(res, "hash") || strings.Contains(strings.Join(a, ""), "code1") || !strings.Contains(res, `-"password": "***`) {
+		t.Fatal("secrets must be masked, but changes visible", res)
+	}
+	if a[4] != `"username": "bob"` {
+		t.Fatal("not secret value masked", a[4])
+	}
+}
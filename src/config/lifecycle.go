@@ -0,0 +1,78 @@
+package config
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+//how often expired accounts are looked for
+const expiryCheck = time.Minute
+
+//true in case expiration date passed
+func (u *UserConfig) Expired() bool {
+	return u.ExpiresAt != nil && !time.Now().Before(*u.ExpiresAt)
+}
+
+//user allowed to login
+func (u *UserConfig) Active() bool {
+	return !u.Disabled && !u.Expired()
+}
+
+//remember last successful login of the user
+func (cfg *GlobalConfig) SetLastLogin(username, ip string) {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	if i := cfg.getUserIndex(username); i >= 0 {
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		now := time.Now()
+		stateLock.Lock()
+		s := cfg.userState(cfg.Users[i].Username)
+		s.LastLogin, s.LastIP = &now, ip
+		cfg.markStateDirty()
+		stateLock.Unlock()
+	}
+}
+
+//disable users with passed expiration date and revoke their external shares, returns disabled usernames
+func (cfg *GlobalConfig) DisableExpired() (res []string) {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	for _, u := range cfg.Users {
+		if u.Disabled || !u.Expired() {
+			continue
+		}
+		u.Disabled = true
+		for _, shr := range u.Shares {
+			shr.AllowExternal = false
+		}
+		res = append(res, u.Username)
+		log.Println("config : account expired, disabled", u.Username)
+	}
+	if len(res) > 0 {
+		cfg.markDirty()
+	}
+	return
+}
+
+//disable expired accounts periodically until stop closed
+func (cfg *GlobalConfig) WatchExpired(stop <-chan struct{}) {
+	for {
+		cfg.DisableExpired()
+		select {
+		case <-stop:
+			return
+		case <-time.After(expiryCheck):
+		}
+	}
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	res := *t
+	return &res
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"testing"
+	"time"
+)
+
+func TestDisableExpired(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	past := time.Now().Add(-time.Minute)
+	cfg.Usr1.ExpiresAt = &past
+	cfg.Usr1.AddShare(&ShareItem{Path: cfg.SharePathUp, AllowExternal: true})
+	_ = cfg.Update(cfg.Usr1)
+	future := time.Now().Add(time.Hour)
+	cfg.Usr2.ExpiresAt = &future
+	_ = cfg.Update(cfg.Usr2)
+
+	u, _ := cfg.GetUserByUsername(cfg.Usr1.Username)
+	if u.Active() || !u.Expired() {
+		t.Fatal("expired user must not be active")
+	}
+	if res := cfg.DisableExpired(); len(res) != 1 || res[0] != cfg.Usr1.Username {
+		t.Fatal("only expired user must be disabled", res)
+	}
+	u, _ = cfg.GetUserByUsername(cfg.Usr1.Username)
+	if !u.Disabled || u.Shares[0].AllowExternal {
+		t.Fatal("user must be disabled with external shares revoked")
+	}
+	if u.Shares[0].IsAllowed(cnst.GUEST) {
+		t.Fatal("external share must be revoked")
+	}
+	u, _ = cfg.GetUserByUsername(cfg.Usr2.Username)
+	if !u.Active() {
+		t.Fatal("user not expired yet")
+	}
+
+	cfg.SetLastLogin(u.Username, "10.0.0.1:4321")
+	if u, _ = cfg.GetUserByUsername(u.Username); u.LastLogin == nil || u.LastIP != "10.0.0.1" {
+		t.Fatal("last login not recorded", u.LastIP)
+	}
+}
+
+func TestLastLoginState(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	cfg.WriteConfig()
+	before, _ := cfg.ReadHistory("current")
+	items := cfg.GetHistory()
+	name := cfg.Usr1.Username
+	cfg.SetLastLogin(name, "10.0.0.1")
+	cfg.Flush()
+	if after, _ := cfg.ReadHistory("current"); string(after) != string(before) {
+		t.Fatal("last login must not rewrite config")
+	}
+	if len(cfg.GetHistory()) != len(items) {
+		t.Fatal("last login must not go to history")
+	}
+
+	//state survives restart
+	n := &GlobalConfig{Path: cfg.Path}
+	if err := n.ReadConfigFile(); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := n.GetUserByUsername(name); u.LastLogin == nil || u.LastIP != "10.0.0.1" {
+		t.Fatal("state not restored", u.LastIP)
+	}
+}
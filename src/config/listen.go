@@ -0,0 +1,95 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	NetTCP     = "tcp"
+	NetUnix    = "unix"
+	NetSystemd = "systemd"
+)
+
+/*
+additional address to listen at, one of:
+tcp://host:port, unix:///path/to/socket, systemd: or systemd:name for socket activated by systemd
+*/
+type ListenSpec struct {
+	Addr string `json:"addr"`
+	//empty means auth method of the section
+	AuthMethod string `json:"authMethod"`
+}
+
+//network and address of the spec, for systemd address is socket name or index, may be empty
+func (s *ListenSpec) Parse() (network, address string, err error) {
+	switch {
+	case strings.HasPrefix(s.Addr, "tcp://"):
+		address = strings.TrimPrefix(s.Addr, "tcp://")
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return "", "", err
+		}
+		if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+			return "", "", fmt.Errorf("port %q out of range 1-65535", port)
+		}
+		return NetTCP, address, nil
+	case strings.HasPrefix(s.Addr, "unix://"):
+		address = strings.TrimPrefix(s.Addr, "unix://")
+		if !strings.HasPrefix(address, "/") {
+			return "", "", errors.New("unix socket path must be absolute")
+		}
+		return NetUnix, address, nil
+	case strings.HasPrefix(s.Addr, "systemd:"):
+		return NetSystemd, strings.TrimPrefix(s.Addr, "systemd:"), nil
+	}
+	return "", "", errors.New("must start with tcp://, unix:// or systemd:")
+}
+
+//all addresses of the section, ip and port first, auth method of the section used by default
+func (l *ListenConf) Specs() (res []*ListenSpec) {
+	if l == nil {
+		return
+	}
+	if l.Port > 0 {
+		res = append(res, &ListenSpec{Addr: "tcp://" + net.JoinHostPort(l.IP, strconv.Itoa(l.Port)), AuthMethod: l.AuthMethod})
+	}
+	for _, s := range l.Listen {
+		if s == nil {
+			continue
+		}
+		spec := &ListenSpec{Addr: s.Addr, AuthMethod: s.AuthMethod}
+		if len(spec.AuthMethod) == 0 {
+			spec.AuthMethod = l.AuthMethod
+		}
+		res = append(res, spec)
+	}
+	return
+}
+
+//extra listen specs as text, to detect changes
+func (l *ListenConf) specsKey() string {
+	if l == nil {
+		return ""
+	}
+	var res []string
+	for _, s := range l.Listen {
+		if s != nil {
+			res = append(res, s.Addr+" "+s.AuthMethod)
+		}
+	}
+	return strings.Join(res, ",")
+}
+
+//find spec of the section by address, nil in case address not in use
+func (l *ListenConf) FindSpec(addr string) *ListenSpec {
+	for _, s := range l.Specs() {
+		if s.Addr == addr {
+			return s
+		}
+	}
+	return nil
+}
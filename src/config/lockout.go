@@ -0,0 +1,73 @@
+package config
+
+import "time"
+
+//defaults of login limiter
+const (
+	defaultLockoutAttempts = 5
+	defaultLockoutBackoff  = 1
+	defaultLockoutDuration = 15 * 60
+)
+
+//limits failed logins per client ip and per username
+type LockoutConf struct {
+	//failed attempts before lockout
+	Attempts int `json:"attempts"`
+	//seconds of delay after first failure, doubled by every next failure
+	Backoff int `json:"backoff"`
+	//seconds of lockout, also time after which failures forgotten
+	Duration int `json:"duration"`
+}
+
+func (l *LockoutConf) copy() *LockoutConf {
+	if l == nil {
+		return nil
+	}
+	res := *l
+	return &res
+}
+
+//lockout settings with defaults in place of not set values
+func (cfg *GlobalConfig) GetLockoutConf() *LockoutConf {
+	updateLock.RLock()
+	res := cfg.Lockout.copy()
+	updateLock.RUnlock()
+	if res == nil {
+		res = &LockoutConf{}
+	}
+	if res.Attempts == 0 {
+		res.Attempts = defaultLockoutAttempts
+	}
+	if res.Backoff == 0 {
+		res.Backoff = defaultLockoutBackoff
+	}
+	if res.Duration == 0 {
+		res.Duration = defaultLockoutDuration
+	}
+	return res
+}
+
+//delay after n failed attempts, lockout duration once attempts reached
+func (l *LockoutConf) Delay(n int) time.Duration {
+	lock := time.Duration(l.Duration) * time.Second
+	if n >= l.Attempts {
+		return lock
+	}
+	d := time.Duration(l.Backoff) * time.Second << uint(n-1)
+	if d > lock || d <= 0 {
+		return lock
+	}
+	return d
+}
+
+func (cfg *GlobalConfig) checkLockout(v *validator) {
+	l := cfg.Lockout
+	if l == nil {
+		return
+	}
+	for field, n := range map[string]int{"lockout.attempts": l.Attempts, "lockout.backoff": l.Backoff, "lockout.duration": l.Duration} {
+		if n < 0 {
+			v.add(field, false, "must not be negative")
+		}
+	}
+}
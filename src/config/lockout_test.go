@@ -0,0 +1,28 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutDelay(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	l := cfg.GetLockoutConf()
+	if l.Attempts != defaultLockoutAttempts || l.Backoff != defaultLockoutBackoff || l.Duration != defaultLockoutDuration {
+		t.Fatal("defaults expected", l)
+	}
+	l = &LockoutConf{Attempts: 4, Backoff: 2, Duration: 5}
+	for n, d := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if res := l.Delay(n + 1); res != d {
+			t.Error("failure", n+1, "wrong delay", res)
+		}
+	}
+
+	v := newValidator("", nil)
+	(&GlobalConfig{Lockout: &LockoutConf{Attempts: -1, Backoff: -1}}).checkLockout(v)
+	if len(v.errs) != 2 {
+		t.Error("negative limits must be reported", v.errs)
+	}
+}
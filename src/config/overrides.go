@@ -0,0 +1,309 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	SrcEnv  = "env"
+	SrcFlag = "flag"
+)
+
+//setting, that can be set by BF_* environment variable or command line flag over the config file
+type overrideField struct {
+	//json path, like http.port
+	key  string
+	env  string
+	flag string
+	//field indexes from GlobalConfig down to the setting
+	index []int
+	typ   reflect.Type
+}
+
+//value applied over the config file, base keeps file value, in order to persist it instead
+type overrideValue struct {
+	value   string
+	source  string
+	base    interface{}
+	hasBase bool
+}
+
+//every scalar setting of GlobalConfig, users excluded
+var overrideFields = listOverrideFields(reflect.TypeOf(GlobalConfig{}), "", nil)
+
+func listOverrideFields(t reflect.Type, prefix string, index []int) (res []*overrideField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if len(f.PkgPath) > 0 || len(name) == 0 || name == "-" || name == "users" {
+			continue
+		}
+		key := joinField(prefix, name)
+		idx := append(append([]int{}, index...), i)
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct {
+			res = append(res, listOverrideFields(ft.Elem(), key, idx)...)
+			continue
+		}
+		switch ft.Kind() {
+		case reflect.String, reflect.Int, reflect.Int64, reflect.Bool:
+			words := splitKey(key)
+			res = append(res, &overrideField{
+				key:   key,
+				env:   "BF_" + strings.ToUpper(strings.Join(words, "_")),
+				flag:  strings.Join(words, "-"),
+				index: idx,
+				typ:   ft,
+			})
+		}
+	}
+	return
+}
+
+//http.authMethod -> [http auth method]
+func splitKey(key string) (res []string) {
+	for _, part := range strings.Split(key, ".") {
+		start := 0
+		for i, r := range part {
+			if i > 0 && unicode.IsUpper(r) {
+				res = append(res, strings.ToLower(part[start:i]))
+				start = i
+			}
+		}
+		res = append(res, strings.ToLower(part[start:]))
+	}
+	return
+}
+
+//resolve setting value at cfg, alloc creates missed sections
+func (f *overrideField) field(cfg *GlobalConfig, alloc bool) (reflect.Value, bool) {
+	v := reflect.ValueOf(cfg).Elem()
+	for _, i := range f.index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return v, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+func (f *overrideField) parse(s string) (interface{}, error) {
+	switch f.typ.Kind() {
+	case reflect.Int:
+		return strconv.Atoi(s)
+	case reflect.Int64:
+		return strconv.ParseInt(s, 10, 64)
+	case reflect.Bool:
+		return strconv.ParseBool(s)
+	}
+	return s, nil
+}
+
+func (f *overrideField) set(cfg *GlobalConfig, val interface{}) {
+	v, _ := f.field(cfg, true)
+	v.Set(reflect.ValueOf(val))
+}
+
+func findOverrideField(key string) *overrideField {
+	for _, f := range overrideFields {
+		if f.key == key {
+			return f
+		}
+	}
+	return nil
+}
+
+//define command line flag for every setting
+func DefineFlags(fs *flag.FlagSet) {
+	for _, f := range overrideFields {
+		usage := fmt.Sprintf("overrides %s, same as %s", f.key, f.env)
+		if f.typ.Kind() == reflect.Bool {
+			fs.Bool(f.flag, false, usage)
+		} else {
+			fs.String(f.flag, "", usage)
+		}
+	}
+}
+
+//settings set explicitly by command line, setting key to value
+func FlagOverrides(fs *flag.FlagSet) map[string]string {
+	res := make(map[string]string)
+	fs.Visit(func(fl *flag.Flag) {
+		for _, f := range overrideFields {
+			if f.flag == fl.Name {
+				res[f.key] = fl.Value.String()
+			}
+		}
+	})
+	return res
+}
+
+/*
+collect overrides from BF_* environment and flags, flags have priority.
+applied on top of the config file by ReadConfigFile and reload
+*/
+func (cfg *GlobalConfig) SetOverrides(flags map[string]string) error {
+	res := make(map[string]*overrideValue)
+	for _, f := range overrideFields {
+		if v, ok := os.LookupEnv(f.env); ok {
+			res[f.key] = &overrideValue{value: v, source: SrcEnv}
+		}
+	}
+	for k, v := range flags {
+		res[k] = &overrideValue{value: v, source: SrcFlag}
+	}
+	for k, v := range res {
+		f := findOverrideField(k)
+		if f == nil {
+			return fmt.Errorf("config: unknown setting %s", k)
+		}
+		if _, err := f.parse(v.value); err != nil {
+			return fmt.Errorf("config: bad %s value %q for %s: %v", v.source, v.value, k, err)
+		}
+	}
+	cfg.overrides = res
+	return nil
+}
+
+//same overrides, for config read from the file again
+func (cfg *GlobalConfig) copyOverrides() map[string]*overrideValue {
+	if cfg.overrides == nil {
+		return nil
+	}
+	res := make(map[string]*overrideValue, len(cfg.overrides))
+	for k, v := range cfg.overrides {
+		res[k] = &overrideValue{value: v.value, source: v.source}
+	}
+	return res
+}
+
+//put overridden values over the config, value from the file remembered at first apply
+func (cfg *GlobalConfig) applyOverrides() {
+	for k, o := range cfg.overrides {
+		f := findOverrideField(k)
+		if !o.hasBase {
+			if v, ok := f.field(cfg, false); ok {
+				o.base = v.Interface()
+			}
+			o.hasBase = true
+		}
+		val, _ := f.parse(o.value)
+		f.set(cfg, val)
+	}
+}
+
+//settings that can't be changed at runtime, setting key to the source
+func (cfg *GlobalConfig) ReadOnlySettings() map[string]string {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	res := make(map[string]string, len(cfg.overrides))
+	for k, o := range cfg.overrides {
+		res[k] = o.source
+	}
+	return res
+}
+
+//config with values from the file instead of overridden, in order to write it
+func (cfg *GlobalConfig) persisted() *GlobalConfig {
+	if len(cfg.overrides) == 0 {
+		return cfg
+	}
+	res := *cfg
+	//sections are shared with running config, so copy them before modify
+	v := reflect.ValueOf(&res).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Ptr && !fv.IsNil() && fv.Elem().Kind() == reflect.Struct && fv.CanSet() {
+			cp := reflect.New(fv.Type().Elem())
+			cp.Elem().Set(fv.Elem())
+			fv.Set(cp)
+		}
+	}
+	for k, o := range cfg.overrides {
+		f := findOverrideField(k)
+		if o.base != nil {
+			f.set(&res, o.base)
+		} else if fv, ok := f.field(&res, false); ok {
+			//section missed at the file
+			fv.Set(reflect.Zero(fv.Type()))
+		}
+	}
+	return &res
+}
+
+//change single setting by key, like http.port, read-only settings can't be changed. Returns keys of changed settings
+func (cfg *GlobalConfig) SetSetting(key, value string) ([]string, error) {
+	f := findOverrideField(key)
+	if f == nil {
+		return nil, fmt.Errorf("config: unknown setting %s", key)
+	}
+	val, err := f.parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("config: bad value %q for %s: %v", value, key, err)
+	}
+	updateLock.RLock()
+	o, ok := cfg.overrides[key]
+	updateLock.RUnlock()
+	if ok {
+		return nil, fmt.Errorf("config: %s is read-only, set by %s", key, o.source)
+	}
+	//validate copy, so bad value never reaches running config
+	mod := cfg.CopyConfig()
+	f.set(mod, val)
+	if err = mod.VerifySettings(); err != nil {
+		return nil, err
+	}
+	return cfg.UpdateConfig(mod), nil
+}
+
+//names of all settings, that can be set by SetSetting
+func SettingKeys() (res []string) {
+	for _, f := range overrideFields {
+		res = append(res, f.key)
+	}
+	return
+}
+
+//actual values of all settings, setting key to value
+func (cfg *GlobalConfig) settingValues() map[string]interface{} {
+	res := make(map[string]interface{}, len(overrideFields))
+	for _, f := range overrideFields {
+		if v, ok := f.field(cfg, false); ok {
+			res[f.key] = v.Interface()
+		} else {
+			res[f.key] = reflect.Zero(f.typ).Interface()
+		}
+	}
+	for name, l := range map[string]*ListenConf{"http": cfg.Http, "https": cfg.Tls} {
+		res[name+".listen"] = l.specsKey()
+	}
+	return res
+}
+
+//keys of settings, that differs from before
+func (cfg *GlobalConfig) changedSettings(before map[string]interface{}) (res []string) {
+	after := cfg.settingValues()
+	for _, f := range overrideFields {
+		if before[f.key] != after[f.key] {
+			res = append(res, f.key)
+		}
+	}
+	for _, k := range []string{"http.listen", "https.listen"} {
+		if before[k] != after[k] {
+			res = append(res, k)
+		}
+	}
+	return
+}
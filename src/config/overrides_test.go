@@ -0,0 +1,56 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestOverrides(t *testing.T) {
+	cfg := TContext{}
+	cfg.Init()
+	defer cfg.Clean(t)
+	cfg.ExternalShareHost = "http://file.host"
+	cfg.WriteConfig()
+
+	_ = os.Setenv("BF_EXTERNAL_SHARE_HOST", "http://env.host")
+	_ = os.Setenv("BF_HTTP_PORT", "9001")
+	defer os.Unsetenv("BF_EXTERNAL_SHARE_HOST")
+	defer os.Unsetenv("BF_HTTP_PORT")
+	n := GlobalConfig{Path: cfg.Path}
+	if err := n.SetOverrides(map[string]string{"http.port": "9002", "preview.threads": "x"}); err == nil {
+		t.Fatal("bad int value must fail")
+	}
+	if err := n.SetOverrides(map[string]string{"http.port": "9002"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.ReadConfigFile(); err != nil {
+		t.Fatal(err)
+	}
+	if n.ExternalShareHost != "http://env.host" || n.Http.Port != 9002 {
+		t.Fatal("overrides not applied", n.ExternalShareHost, n.Http.Port)
+	}
+	ro := n.ReadOnlySettings()
+	if ro["externalShareHost"] != SrcEnv || ro["http.port"] != SrcFlag {
+		t.Fatal("wrong read-only settings", ro)
+	}
+	//settings update can't change read-only ones
+	mod := n.CopyConfig()
+	mod.ExternalShareHost = "http://ui.host"
+	mod.Log = "stderr"
+	n.UpdateConfig(mod)
+	if n.ExternalShareHost != "http://env.host" || n.Log != "stderr" {
+		t.Fatal("read-only setting changed", n.ExternalShareHost)
+	}
+	n.WriteConfig()
+	data, _ := ioutil.ReadFile(n.Path)
+	f := GlobalConfig{}
+	_ = json.Unmarshal(data, &f)
+	if f.ExternalShareHost != "http://file.host" || f.Http.Port != cfg.Http.Port || f.Log != "stderr" {
+		t.Fatal("overridden settings written to the file", f.ExternalShareHost, f.Http.Port)
+	}
+	if _, err := n.ReloadConfigFile(nil); err != nil || n.ExternalShareHost != "http://env.host" {
+		t.Fatal("overrides lost on reload", err)
+	}
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/browsefile/backend/src/cnst"
+	"unicode"
+)
+
+//password hashing algorithms
+const (
+	AlgoBcrypt   = "bcrypt"
+	AlgoArgon2id = "argon2id"
+)
+
+//character classes, password can be required to contain
+const (
+	ClassLower  = "lower"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+//defaults of hashing parameters
+const (
+	defaultBcryptCost    = 10
+	defaultArgon2Time    = 1
+	defaultArgon2Memory  = 64 * 1024
+	defaultArgon2Threads = 4
+)
+
+//few most common passwords, rejected in case rejectCommon set
+var commonPasswords = []string{
+	"123456", "12345678", "123456789", "1234567890", "password", "password1", "qwerty", "qwerty123",
+	"111111", "123123", "abc123", "iloveyou", "admin", "letmein", "welcome", "monkey", "dragon", "000000",
+}
+
+//password policy and hashing settings, applied on create and change of passwords
+type PasswordConf struct {
+	MinLength int `json:"minLength"`
+	//every class must be present: lower, upper, digit, symbol
+	Classes []string `json:"classes,omitempty"`
+	//rejected passwords, case insensitive
+	Reject []string `json:"reject,omitempty"`
+	//reject built-in list of most common passwords as well
+	RejectCommon bool `json:"rejectCommon"`
+	//bcrypt or argon2id, stored hashes with other algorithm or parameters upgraded on login
+	Algo string `json:"algo"`
+	//bcrypt cost
+	Cost int `json:"cost,omitempty"`
+	//argon2id iterations, memory in KiB and threads
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+}
+
+func (p *PasswordConf) copy() *PasswordConf {
+	if p == nil {
+		return nil
+	}
+	res := *p
+	res.Classes = append([]string(nil), p.Classes...)
+	res.Reject = append([]string(nil), p.Reject...)
+	return &res
+}
+
+//password settings with defaults in place of not set values
+func (cfg *GlobalConfig) GetPasswordConf() *PasswordConf {
+	updateLock.RLock()
+	res := cfg.Password.copy()
+	updateLock.RUnlock()
+	if res == nil {
+		res = &PasswordConf{}
+	}
+	if len(res.Algo) == 0 {
+		res.Algo = AlgoBcrypt
+	}
+	if res.Cost == 0 {
+		res.Cost = defaultBcryptCost
+	}
+	if res.Time == 0 {
+		res.Time = defaultArgon2Time
+	}
+	if res.Memory == 0 {
+		res.Memory = defaultArgon2Memory
+	}
+	if res.Threads == 0 {
+		res.Threads = defaultArgon2Threads
+	}
+	return res
+}
+
+//error describes first broken rule, nil in case password fits the policy
+func (p *PasswordConf) Check(password string) error {
+	if len(password) == 0 {
+		return cnst.ErrEmptyPassword
+	}
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	for _, c := range p.Classes {
+		if !hasClass(password, c) {
+			return fmt.Errorf("password must contain %s character", c)
+		}
+	}
+	if hasName(p.Reject, password) || p.RejectCommon && hasName(commonPasswords, password) {
+		return errors.New("password is too common")
+	}
+	return nil
+}
+
+func hasClass(s, class string) bool {
+	for _, r := range s {
+		switch {
+		case class == ClassLower && unicode.IsLower(r),
+			class == ClassUpper && unicode.IsUpper(r),
+			class == ClassDigit && unicode.IsDigit(r),
+			class == ClassSymbol && !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r):
+			return true
+		}
+	}
+	return false
+}
+
+//check password policy and hashing parameters
+func (cfg *GlobalConfig) checkPassword(v *validator) {
+	p := cfg.Password
+	if p == nil {
+		return
+	}
+	if p.MinLength < 0 {
+		v.add("password.minLength", false, "must not be negative")
+	}
+	for _, c := range p.Classes {
+		if !contains([]string{ClassLower, ClassUpper, ClassDigit, ClassSymbol}, c) {
+			v.add("password.classes", false, "unknown class %q", c)
+		}
+	}
+	if len(p.Algo) > 0 && p.Algo != AlgoBcrypt && p.Algo != AlgoArgon2id {
+		v.add("password.algo", false, "unknown algorithm %q, allowed %s, %s", p.Algo, AlgoBcrypt, AlgoArgon2id)
+	}
+	//bcrypt limits
+	if p.Cost != 0 && (p.Cost < 4 || p.Cost > 31) {
+		v.add("password.cost", false, "must be in range 4..31")
+	}
+	if p.Memory != 0 && p.Memory < 8*uint32(p.Threads) {
+		v.add("password.memory", false, "must be at least 8 KiB per thread")
+	}
+}
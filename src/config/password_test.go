@@ -0,0 +1,31 @@
+package config
+
+import "testing"
+
+func TestPasswordPolicy(t *testing.T) {
+	p := &PasswordConf{MinLength: 8, Classes: []string{ClassDigit, ClassUpper}, Reject: []string{"Summer2020"}, RejectCommon: true}
+	for pwd, ok := range map[string]bool{
+		"":              false,
+		"Short1":        false,
+		"nouppercase1":  false,
+		"NoDigitsHere":  false,
+		"summer2020":    false,
+		"Tr0ubadour":    true,
+		"Correct9Horse": true,
+	} {
+		if err := p.Check(pwd); (err == nil) != ok {
+			t.Error("wrong policy result for", pwd, err)
+		}
+	}
+	p = &PasswordConf{RejectCommon: true}
+	if p.Check("qwerty") == nil || p.Check("1") != nil {
+		t.Error("only common passwords must be rejected")
+	}
+
+	v := newValidator("", nil)
+	cfg := &GlobalConfig{Password: &PasswordConf{Algo: "md5", Classes: []string{"emoji"}, Cost: 50}}
+	cfg.checkPassword(v)
+	if len(v.errs) != 3 {
+		t.Error("wrong password settings must be reported", v.errs)
+	}
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+//actions user allowed to do
+const (
+	PermDelete = "delete"
+	//rename and move
+	PermRename    = "rename"
+	PermOverwrite = "overwrite"
+	PermUpload    = "upload"
+	PermMkdir     = "mkdir"
+	PermDownload  = "download"
+	PermShare     = "share"
+	//share with anyone by link
+	PermShareExternal = "shareExternal"
+)
+
+type Permissions struct {
+	Delete        bool `json:"delete"`
+	Rename        bool `json:"rename"`
+	Overwrite     bool `json:"overwrite"`
+	Upload        bool `json:"upload"`
+	Mkdir         bool `json:"mkdir"`
+	Download      bool `json:"download"`
+	Share         bool `json:"share"`
+	ShareExternal bool `json:"shareExternal"`
+}
+
+//permissions equal to former allowEdit and allowNew flags
+func LegacyPermissions(allowEdit, allowNew bool) *Permissions {
+	return &Permissions{
+		Delete:        allowEdit,
+		Rename:        allowEdit,
+		Overwrite:     allowEdit,
+		Upload:        allowNew,
+		Mkdir:         allowNew,
+		Download:      true,
+		Share:         true,
+		ShareExternal: true,
+	}
+}
+
+//parse comma separated permission names, like "upload,mkdir,download"
+func ParsePermissions(list string) (*Permissions, error) {
+	p := &Permissions{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		f := p.field(name)
+		if f == nil {
+			return nil, errors.New("unknown permission " + name)
+		}
+		*f = true
+	}
+	return p, nil
+}
+
+//comma separated names of granted permissions
+func (p *Permissions) String() string {
+	var res []string
+	for _, name := range []string{PermDelete, PermRename, PermOverwrite, PermUpload, PermMkdir,
+		PermDownload, PermShare, PermShareExternal} {
+		if *p.field(name) {
+			res = append(res, name)
+		}
+	}
+	return strings.Join(res, ",")
+}
+
+func (p *Permissions) field(perm string) *bool {
+	switch perm {
+	case PermDelete:
+		return &p.Delete
+	case PermRename:
+		return &p.Rename
+	case PermOverwrite:
+		return &p.Overwrite
+	case PermUpload:
+		return &p.Upload
+	case PermMkdir:
+		return &p.Mkdir
+	case PermDownload:
+		return &p.Download
+	case PermShare:
+		return &p.Share
+	case PermShareExternal:
+		return &p.ShareExternal
+	}
+	return nil
+}
+
+func (p *Permissions) copy() *Permissions {
+	if p == nil {
+		return nil
+	}
+	res := *p
+	return &res
+}
+
+//check that user allowed to do action by own or groups permissions, users without permissions use allowEdit and allowNew
+func (u *UserConfig) Can(perm string) bool {
+	p := u.Perms
+	if p == nil {
+		p = LegacyPermissions(u.AllowEdit, u.AllowNew)
+	}
+	if f := p.field(perm); f != nil && *f {
+		return true
+	}
+	return config != nil && config.groupCan(u.Username, perm)
+}
+
+//migrate permissions of all users, true in case any user had no permissions
+func (cfg *GlobalConfig) migrateUsers() (res bool) {
+	for _, u := range cfg.Users {
+		if u == nil {
+			continue
+		}
+		res = res || u.Perms == nil
+		u.migratePerms()
+	}
+	return
+}
+
+//apply permissions of n, or its allowEdit and allowNew, in case only they were changed by former clients
+func (u *UserConfig) updatePerms(n *UserConfig) {
+	allowEdit, allowNew, perms := n.AllowEdit, n.AllowNew, n.Perms.copy()
+	if u.Perms == nil {
+		u.Perms = LegacyPermissions(u.AllowEdit, u.AllowNew)
+	}
+	p := u.Perms
+	if perms != nil && *perms != *p {
+		u.Perms = perms
+	} else {
+		if allowEdit != (p.Delete || p.Rename || p.Overwrite) {
+			p.Delete, p.Rename, p.Overwrite = allowEdit, allowEdit, allowEdit
+		}
+		if allowNew != (p.Upload || p.Mkdir) {
+			p.Upload, p.Mkdir = allowNew, allowNew
+		}
+	}
+	u.migratePerms()
+}
+
+/*
+set permissions from allowEdit and allowNew in case missed, like in configs written before permissions,
+keeps allowEdit and allowNew in sync with permissions, since front-end relies on them
+*/
+func (u *UserConfig) migratePerms() {
+	if u.Perms == nil {
+		u.Perms = LegacyPermissions(u.AllowEdit, u.AllowNew)
+	}
+	u.AllowEdit = u.Perms.Delete || u.Perms.Rename || u.Perms.Overwrite
+	u.AllowNew = u.Perms.Upload || u.Perms.Mkdir
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestPermsMigration(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	cfg.WriteConfig()
+	//config written before permissions
+	ext := GlobalConfig{Path: cfg.Path, FilesPath: cfg.FilesPath}
+	_, _ = ext.parseConf(cfg.Path)
+	for _, u := range ext.Users {
+		u.Perms = nil
+		u.AllowNew = false
+		u.AllowEdit = u.Username == cfg.Usr1.Username
+	}
+	data, _ := json.Marshal(ext)
+	if err := ioutil.WriteFile(cfg.Path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg2 := GlobalConfig{Path: cfg.Path, FilesPath: cfg.FilesPath}
+	cfg2.ReadConfigFile()
+	cfg2.Flush()
+	u, _ := cfg2.GetUserByUsername(cfg.Usr1.Username)
+	if u.Perms == nil || !u.Can(PermDelete) || !u.Can(PermRename) || !u.Can(PermOverwrite) ||
+		u.Can(PermUpload) || u.Can(PermMkdir) || !u.Can(PermDownload) || !u.Can(PermShare) {
+		t.Fatal("wrong migrated permissions", u.Perms)
+	}
+	//migrated permissions persisted
+	ext = GlobalConfig{Path: cfg.Path, FilesPath: cfg.FilesPath}
+	_, _ = ext.parseConf(cfg.Path)
+	for _, u := range ext.Users {
+		if u.Perms == nil {
+			t.Fatal("permissions not written for", u.Username)
+		}
+	}
+	//legacy flag update by former clients
+	u = u.copyUser()
+	u.AllowNew = true
+	_ = cfg2.Update(u)
+	u, _ = cfg2.GetUserByUsername(u.Username)
+	if !u.Can(PermUpload) || !u.Can(PermMkdir) || !u.Can(PermDelete) {
+		t.Fatal("allowNew not applied", u.Perms)
+	}
+	//fine-grained update keeps flags in sync
+	u = u.copyUser()
+	u.Perms = &Permissions{Download: true, Upload: true}
+	_ = cfg2.Update(u)
+	u, _ = cfg2.GetUserByUsername(u.Username)
+	if u.AllowEdit || !u.AllowNew || u.Can(PermMkdir) || u.Can(PermShare) {
+		t.Fatal("permissions not updated", u.Perms)
+	}
+	if p, err := ParsePermissions("upload, download"); err != nil || p.String() != "upload,download" {
+		t.Fatal("wrong parsed permissions", p, err)
+	}
+	if _, err := ParsePermissions("upload,fly"); err == nil {
+		t.Fatal("unknown permission must fail")
+	}
+}
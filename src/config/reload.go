@@ -0,0 +1,135 @@
+package config
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+//read config file into new object, without touching running config
+func (cfg *GlobalConfig) loadConfigFile(p string) (res *GlobalConfig, err error) {
+	res = &GlobalConfig{Path: p, overrides: cfg.copyOverrides()}
+	warns, err := res.parseConf(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range warns {
+		log.Println("config:", w)
+	}
+	res.migrateUsers()
+	//edited file may carry runtime fields of old format
+	cfg.moveState(res.Users)
+
+	return res, nil
+}
+
+/*
+re-read config file from cfg.Path, and apply users, shares and settings in place, returns keys of changed settings.
+prepare called before new users become visible, in order to setup runtime parts of it, like dav handlers.
+in case of any error running config stays untouched.
+*/
+func (cfg *GlobalConfig) ReloadConfigFile(prepare func(n *GlobalConfig) error) ([]string, error) {
+	n, err := cfg.loadConfigFile(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	//keep sessions alive, if key was dropped from the file
+	if len(n.Auth.Key) == 0 {
+		n.Auth.Key = cfg.Auth.Key
+	}
+	for _, u := range n.Users {
+		for _, shr := range u.Shares {
+			shr.Path = strings.TrimSuffix(shr.Path, "/")
+			shr.Hash = GenShareHash(u.Username, shr.Path)
+		}
+	}
+	if prepare != nil {
+		if err = prepare(n); err != nil {
+			return nil, err
+		}
+	}
+
+	updateLock.Lock()
+	before := cfg.settingValues()
+	cfg.Users = n.Users
+	cfg.Groups = n.Groups
+	cfg.Auth = n.copyAuth()
+	cfg.CaptchaConfig = n.copyCaptchaConfig()
+	cfg.PreviewConf = &PreviewConf{ScriptPath: n.ScriptPath, Threads: n.Threads, FirstRun: n.PreviewConf.FirstRun}
+	cfg.FilesPath = n.FilesPath
+	cfg.Http = n.Http.copy()
+	cfg.Tls = n.Tls.copy()
+	cfg.TLSCert = n.TLSCert
+	cfg.TLSKey = n.TLSKey
+	cfg.TLSSelfSigned = n.TLSSelfSigned
+	cfg.Log = n.Log
+	cfg.ExternalShareHost = n.ExternalShareHost
+	cfg.BaseURL = n.BaseURL
+	cfg.TrustedProxies = n.TrustedProxies
+	cfg.ShutdownTimeout = n.ShutdownTimeout
+	cfg.ConfigWatch = n.ConfigWatch
+	cfg.HistorySize = n.HistorySize
+	cfg.DefaultQuota = n.DefaultQuota
+	cfg.Password = n.Password
+	cfg.AdminTwoFactor = n.AdminTwoFactor
+	cfg.Lockout = n.Lockout
+	cfg.fileHash = n.fileHash
+	cfg.overrides = n.overrides
+	changed := cfg.changedSettings(before)
+	cfg.RefreshUserRam()
+	updateLock.Unlock()
+
+	cfg.setupLog()
+	cfg.setUpPaths()
+	log.Println("config : reloaded from", cfg.Path)
+
+	return changed, nil
+}
+
+//true in case config file at disk differs from the last one read or written by this process
+func (cfg *GlobalConfig) isFileChanged() bool {
+	data, err := ioutil.ReadFile(cfg.Path)
+	if err != nil {
+		return false
+	}
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	return sha256.Sum256(data) != cfg.fileHash
+}
+
+//polls config file for external edits until stop closed, onChange called for each detected edit
+func (cfg *GlobalConfig) WatchConfigFile(stop <-chan struct{}, onChange func()) {
+	var lastMod time.Time
+	if inf, err := os.Stat(cfg.Path); err == nil {
+		lastMod = inf.ModTime()
+	}
+	for {
+		//interval can be changed at runtime, 0 pauses watching
+		updateLock.RLock()
+		interval := time.Duration(cfg.ConfigWatch) * time.Second
+		updateLock.RUnlock()
+		paused := interval <= 0
+		if paused {
+			interval = defaultConfigWatch * time.Second
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+			if paused {
+				continue
+			}
+			inf, err := os.Stat(cfg.Path)
+			if err != nil || inf.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = inf.ModTime()
+			if cfg.isFileChanged() {
+				onChange()
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"errors"
+	"github.com/browsefile/backend/src/cnst"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//rename user, moves user's folder, regenerates share hashes and share links of all consumers.
+//prepare called under the lock with renamed user, in case any step fails, everything rolled back
+func (cfg *GlobalConfig) RenameUser(oldName, newName string, prepare func(u *UserConfig)) error {
+	if err := checkUserName(newName); err != nil {
+		return err
+	}
+	updateLock.Lock()
+	i := cfg.getUserIndex(oldName)
+	if i < 0 {
+		updateLock.Unlock()
+		return cnst.ErrNotExist
+	}
+	u := cfg.Users[i]
+	oldName = u.Username
+	if j := cfg.getUserIndex(newName); j >= 0 && j != i {
+		updateLock.Unlock()
+		return cnst.ErrExist
+	}
+	oldPath, newPath := cfg.GetDavPath(oldName), cfg.GetDavPath(newName)
+	moved := oldPath != newPath
+	if _, err := os.Stat(newPath); moved && err == nil && !strings.EqualFold(oldName, newName) {
+		updateLock.Unlock()
+		return errors.New("folder already exists " + newPath)
+	}
+	shares := u.Shares
+	updateLock.Unlock()
+
+	//drop share links of consumers, they contains old name and hash
+	cfg.dropShareLinks(oldName)
+	if moved {
+		if err := os.Rename(oldPath, newPath); err != nil {
+			cfg.linkShares(oldName, shares)
+			return err
+		}
+	}
+
+	updateLock.Lock()
+	if cfg.getUserIndex(oldName) != i || cfg.Users[i] != u {
+		//user changed meanwhile
+		updateLock.Unlock()
+		cfg.rollbackRename(oldPath, newPath, moved, oldName, shares)
+		return errors.New("user changed during rename " + oldName)
+	}
+	u.Username = newName
+	cfg.renameState(oldName, newName)
+	for _, shr := range u.Shares {
+		shr.Hash = GenShareHash(newName, shr.Path)
+	}
+	for _, o := range cfg.Users {
+		for _, shr := range o.Shares {
+			replaceName(shr.AllowUsers, oldName, newName)
+		}
+	}
+	for _, g := range cfg.Groups {
+		if g != nil {
+			replaceName(g.Members, oldName, newName)
+		}
+	}
+	cfg.RefreshUserRam()
+	if prepare != nil {
+		prepare(u)
+	}
+	cfg.markDirty()
+	updateLock.Unlock()
+
+	//dav links are absolute, so point them to the new folder
+	davPath := filepath.Join(newPath, cnst.WEB_DAV_FOLDER)
+	_ = os.Remove(filepath.Join(davPath, "files"))
+	_ = os.Remove(filepath.Join(davPath, "shares"))
+	_ = cfg.checkDavFolder(u)
+	cfg.linkShares(newName, shares)
+
+	return nil
+}
+
+//move folder back and restore share links of not renamed user
+func (cfg *GlobalConfig) rollbackRename(oldPath, newPath string, moved bool, oldName string, shares []*ShareItem) {
+	if moved {
+		_ = os.Rename(newPath, oldPath)
+	}
+	cfg.linkShares(oldName, shares)
+}
+
+//remove owner's folder from shares folder of every user
+func (cfg *GlobalConfig) dropShareLinks(owner string) {
+	for _, u := range cfg.GetUsers() {
+		if !strings.EqualFold(u.Username, owner) {
+			_ = os.RemoveAll(filepath.Join(cfg.GetUserSharesPath(u.Username), owner))
+		}
+	}
+}
+
+func (cfg *GlobalConfig) linkShares(owner string, shares []*ShareItem) {
+	for _, shr := range shares {
+		addSharePath(shr, owner)
+	}
+}
+
+func replaceName(list []string, oldName, newName string) {
+	for i, n := range list {
+		if strings.EqualFold(n, oldName) {
+			list[i] = newName
+		}
+	}
+}
+
+//username goes to the filesystem path, so it must be a single path element
+func checkUserName(name string) error {
+	if len(name) == 0 || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return errors.New("invalid username " + name)
+	}
+	if strings.EqualFold(name, cnst.GUEST) {
+		return errors.New("username is reserved " + name)
+	}
+	return nil
+}
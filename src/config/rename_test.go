@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenameUser(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	shr := &ShareItem{Path: cfg.SharePathDeep, AllowUsers: []string{cfg.Usr2.Username}}
+	cfg.Usr1.AddShare(shr)
+	_ = cfg.Update(cfg.Usr1)
+	_ = cfg.SetGroup(&GroupConfig{Name: "family", Members: []string{cfg.Usr1.Username}})
+	cfg.Usr2.AddShare(&ShareItem{Path: "/", AllowUsers: []string{cfg.Usr1.Username}})
+	_ = cfg.Update(cfg.Usr2)
+	oldHash := shr.Hash
+
+	if cfg.RenameUser(cfg.Usr1.Username, cfg.Usr2.Username, nil) == nil {
+		t.Fatal("rename to existing user must fail")
+	}
+	if cfg.RenameUser(cfg.Usr1.Username, "../x", nil) == nil {
+		t.Fatal("bad username must be rejected")
+	}
+	if err := cfg.RenameUser("user1", "renamed", nil); err != nil {
+		t.Fatal(err)
+	}
+	u, ok := cfg.GetUserByUsername("renamed")
+	if _, old := cfg.GetUserByUsername("user1"); !ok || old {
+		t.Fatal("user not renamed")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.GetUserHomePath("renamed"), cfg.SharePathDeep)); err != nil {
+		t.Fatal("files must be moved", err)
+	}
+	if u.Shares[0].Hash == oldHash {
+		t.Fatal("share hash must be regenerated")
+	}
+	p, _ := u.Shares[0].ResolveSymlinkName()
+	if _, err := cfg.User2FSShare.Stat(filepath.Join("renamed", p)); err != nil {
+		t.Fatal("consumer link must point to renamed user", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.GetUserSharesPath(cfg.Usr2.Username), "user1")); err == nil {
+		t.Fatal("old share links must be removed")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.GetDavPath("renamed"), "wd", "files", cfg.SharePathDeep)); err != nil {
+		t.Fatal("dav link must follow the user", err)
+	}
+	usr2, _ := cfg.GetUserByUsername(cfg.Usr2.Username)
+	if !usr2.Shares[0].IsAllowed("renamed") {
+		t.Fatal("allowed users must be renamed")
+	}
+	if g, _ := cfg.GetGroup("family"); !hasName(g.Members, "renamed") {
+		t.Fatal("group members must be renamed", g.Members)
+	}
+
+	//target folder exists, nothing must change
+	_ = os.MkdirAll(cfg.GetDavPath("busy"), 0700)
+	if cfg.RenameUser("renamed", "busy", nil) == nil {
+		t.Fatal("existing folder must fail rename")
+	}
+	if _, ok := cfg.GetUserByUsername("renamed"); !ok {
+		t.Fatal("user must stay after failed rename")
+	}
+	if _, err := cfg.User2FSShare.Stat(filepath.Join("renamed", p)); err != nil {
+		t.Fatal("share links must be restored", err)
+	}
+}
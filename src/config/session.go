@@ -0,0 +1,154 @@
+package config
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+//oldest sessions dropped, once user has more
+const maxSessions = 50
+
+//issued token of the user, token carries session id and refused once session revoked
+type Session struct {
+	ID string `json:"id"`
+	//client ip and user agent at login
+	IP      string    `json:"ip"`
+	Agent   string    `json:"agent"`
+	Created time.Time `json:"created"`
+	//token expiration, moved by renew
+	Expires time.Time `json:"expires"`
+}
+
+func copySessions(list []*Session) (res []*Session) {
+	for _, s := range list {
+		c := *s
+		res = append(res, &c)
+	}
+	return
+}
+
+//true in case session with id exists and not expired
+func (u *UserConfig) HasSession(id string) bool {
+	now := time.Now()
+	for _, s := range u.Sessions {
+		if s.ID == id {
+			return now.Before(s.Expires)
+		}
+	}
+	return false
+}
+
+//register new session of the user, expired and oldest sessions dropped
+func (cfg *GlobalConfig) AddSession(username string, s *Session) error {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	i := cfg.getUserIndex(username)
+	if i < 0 {
+		return cnst.ErrNotExist
+	}
+	if host, _, err := net.SplitHostPort(s.IP); err == nil {
+		s.IP = host
+	}
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	st := cfg.userState(cfg.Users[i].Username)
+	now := time.Now()
+	var list []*Session
+	for _, c := range st.Sessions {
+		if now.Before(c.Expires) {
+			list = append(list, c)
+		}
+	}
+	c := *s
+	list = append(list, &c)
+	if len(list) > maxSessions {
+		sort.SliceStable(list, func(a, b int) bool { return list[a].Created.Before(list[b].Created) })
+		list = list[len(list)-maxSessions:]
+	}
+	st.Sessions = list
+	cfg.markStateDirty()
+	return nil
+}
+
+//move expiration of the session on token renew, false in case session revoked
+func (cfg *GlobalConfig) RenewSession(username, id string, expires time.Time) bool {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	st, ok := cfg.state.users[strings.ToLower(username)]
+	if !ok {
+		return false
+	}
+	for _, s := range st.Sessions {
+		if s.ID == id && time.Now().Before(s.Expires) {
+			s.Expires = expires
+			cfg.markStateDirty()
+			return true
+		}
+	}
+	return false
+}
+
+//drop session of the user, cnst.ErrNotExist in case there is no such session
+func (cfg *GlobalConfig) RevokeSession(username, id string) error {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	st, ok := cfg.state.users[strings.ToLower(username)]
+	if !ok {
+		return cnst.ErrNotExist
+	}
+	for k, s := range st.Sessions {
+		if s.ID == id {
+			st.Sessions = append(st.Sessions[:k], st.Sessions[k+1:]...)
+			cfg.markStateDirty()
+			return nil
+		}
+	}
+	return cnst.ErrNotExist
+}
+
+//drop all sessions of the user except one, returns count of revoked sessions
+func (cfg *GlobalConfig) RevokeSessions(username, except string) int {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	st, ok := cfg.state.users[strings.ToLower(username)]
+	if !ok {
+		return 0
+	}
+	var keep []*Session
+	for _, s := range st.Sessions {
+		if len(except) > 0 && s.ID == except {
+			keep = append(keep, s)
+		}
+	}
+	res := len(st.Sessions) - len(keep)
+	st.Sessions = keep
+	if res > 0 {
+		cfg.markStateDirty()
+	}
+	return res
+}
+
+//admin flag, permissions, rules or disabled state differ, so issued tokens must not be trusted anymore
+func accessChanged(u, n *UserConfig) bool {
+	if u.Admin != n.Admin || u.Disabled != n.Disabled || (u.Perms == nil) != (n.Perms == nil) ||
+		u.Perms != nil && *u.Perms != *n.Perms || len(u.Rules) != len(n.Rules) {
+		return true
+	}
+	for i, r := range u.Rules {
+		o := n.Rules[i]
+		if r == nil || o == nil {
+			if r != o {
+				return true
+			}
+			continue
+		}
+		if r.Path != o.Path || r.Regex != o.Regex ||
+			strings.Join(r.Allow, ",") != strings.Join(o.Allow, ",") || strings.Join(r.Deny, ",") != strings.Join(o.Deny, ",") {
+			return true
+		}
+	}
+	return false
+}
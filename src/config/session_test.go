@@ -0,0 +1,72 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionRevoke(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	name := cfg.Usr1.Username
+	now := time.Now()
+	for _, id := range []string{"a", "b", "c"} {
+		if err := cfg.AddSession(name, &Session{ID: id, IP: "10.0.0.1:1234", Created: now, Expires: now.Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = cfg.AddSession(name, &Session{ID: "old", Created: now, Expires: now.Add(-time.Second)})
+	u, _ := cfg.GetUserByUsername(name)
+	if !u.HasSession("a") || u.HasSession("old") || u.Sessions[0].IP != "10.0.0.1" {
+		t.Fatal("wrong sessions", u.Sessions)
+	}
+	if err := cfg.RevokeSession(name, "a"); err != nil || cfg.RevokeSession(name, "a") == nil {
+		t.Fatal("session must be revoked once")
+	}
+	if !cfg.RenewSession(name, "b", now.Add(2*time.Hour)) || cfg.RenewSession(name, "a", now) {
+		t.Fatal("only active session can be renewed")
+	}
+
+	//not related changes keep sessions
+	u, _ = cfg.GetUserByUsername(name)
+	u.Locale = "de"
+	_ = cfg.Update(u)
+	if u, _ = cfg.GetUserByUsername(name); !u.HasSession("b") || !u.HasSession("c") {
+		t.Fatal("sessions must stay", u.Sessions)
+	}
+	u.Perms.Delete = !u.Perms.Delete
+	_ = cfg.Update(u)
+	if u, _ = cfg.GetUserByUsername(name); len(u.Sessions) != 0 {
+		t.Fatal("permission change must revoke sessions")
+	}
+}
+
+func TestSessionState(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	cfg.WriteConfig()
+	before, _ := cfg.ReadHistory("current")
+	items := cfg.GetHistory()
+	name := cfg.Usr1.Username
+	now := time.Now()
+	_ = cfg.AddSession(name, &Session{ID: "a", Created: now, Expires: now.Add(time.Hour)})
+	cfg.SetLastLogin(name, "10.0.0.1")
+	cfg.Flush()
+	if after, _ := cfg.ReadHistory("current"); string(after) != string(before) {
+		t.Fatal("runtime state must not rewrite config")
+	}
+	if len(cfg.GetHistory()) != len(items) {
+		t.Fatal("runtime state must not go to history")
+	}
+
+	//state survives restart
+	n := &GlobalConfig{Path: cfg.Path}
+	if err := n.ReadConfigFile(); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := n.GetUserByUsername(name); !u.HasSession("a") || u.LastIP != "10.0.0.1" {
+		t.Fatal("state not restored", u.Sessions, u.LastIP)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"encoding/base64"
 	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/lib/utils"
 	"github.com/pkg/errors"
 	"log"
 	"os"
@@ -20,6 +21,8 @@ type ShareItem struct {
 	AllowLocal bool `json:"allowLocal"`
 	//allowed by only specific users
 	AllowUsers []string `json:"allowedUsers"`
+	//allowed to members of groups
+	AllowGroups []string `json:"allowGroups,omitempty"`
 	//uses for external DMZ share request
 	Hash string `json:"-"`
 }
@@ -45,6 +48,9 @@ func (shr *ShareItem) IsAllowed(user string) (res bool) {
 				break
 			}
 		}
+		if !res && !strings.EqualFold(user, cnst.GUEST) {
+			res = config.inGroups(user, shr.AllowGroups)
+		}
 	}
 
 	return
@@ -61,6 +67,10 @@ func (shr *ShareItem) copyShare() (res *ShareItem) {
 		Hash:          shr.Hash,
 	}
 	copy(res.AllowUsers, shr.AllowUsers)
+	if shr.AllowGroups != nil {
+		res.AllowGroups = make([]string, len(shr.AllowGroups))
+		copy(res.AllowGroups, shr.AllowGroups)
+	}
 	return
 }
 
@@ -70,10 +80,15 @@ func addSharePath(shr *ShareItem, own string) {
 			processSharePath(shr, u, own)
 
 		}
-	} else if len(shr.AllowUsers) > 0 {
-		for _, uName := range shr.AllowUsers {
-			u, _ := usersRam[uName]
-			processSharePath(shr, u, own)
+	} else {
+		//users allowed directly and by groups
+		consumers := append(append([]string{}, shr.AllowUsers...), config.groupMembers(shr.AllowGroups)...)
+		var done []string
+		for _, uName := range consumers {
+			if u, ok := usersRam[uName]; ok && !hasName(done, uName) {
+				done = append(done, uName)
+				processSharePath(shr, u, own)
+			}
 		}
 	}
 }
@@ -95,13 +110,7 @@ func delSharePath(shr *ShareItem, owner string) {
 		if strings.EqualFold(owner, u.Username) {
 			continue
 		}
-		p, err := shr.ResolveSymlinkName()
-		if err != nil {
-			log.Println(err)
-		}
-		dp := filepath.Join(config.GetUserSharesPath(u.Username), owner, p)
-		_ = os.Remove(dp)
-
+		config.removeShareLink(shr, u.Username, owner)
 	}
 
 }
@@ -147,6 +156,31 @@ func (cfg *GlobalConfig) checkShareSymLinkPath(shr *ShareItem, consumer, owner s
 	return res
 }
 
+//share path fully readable by path rules of the owner, since shares are not limited by rules when served
+func (cfg *GlobalConfig) ShareReadable(u *UserConfig, p string) bool {
+	if len(u.Rules) == 0 {
+		return true
+	}
+	home := cfg.GetUserHomePath(u.Username)
+	err := filepath.Walk(filepath.Join(home, p), func(f string, info os.FileInfo, err error) error {
+		rel := strings.TrimPrefix(f, home)
+		if !u.Rules.Allowed(rel, utils.AccessRead) || !u.Rules.Allowed(rel, utils.AccessList) {
+			return os.ErrPermission
+		}
+		return nil
+	})
+	return err == nil
+}
+
+//drop shares, that new rules of the owner hide in part. Must be called under update lock
+func (cfg *GlobalConfig) dropUnreadableShares(u *UserConfig) {
+	for _, shr := range append([]*ShareItem(nil), u.Shares...) {
+		if !cfg.ShareReadable(u, shr.Path) && u.deleteShare(shr.Path) {
+			log.Printf("config : share %s of %s dropped, path rules deny it\n", shr.Path, u.Username)
+		}
+	}
+}
+
 //will create correct symlink name, err in case hash empty
 func (shr *ShareItem) ResolveSymlinkName() (string, error) {
 	if len(shr.Hash) == 0 {
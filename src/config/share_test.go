@@ -1,6 +1,7 @@
 package config
 
 import (
+	"github.com/browsefile/backend/src/lib/utils"
 	"os"
 	"path/filepath"
 	"strings"
@@ -233,3 +234,30 @@ func TestSharePreviewPath(t *testing.T) {
 	processSharePath(shrUp, cfg.GetAdmin(), cfg.Usr1.Username)
 }
 */
+
+func TestShareRulesChanged(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	shr := &ShareItem{Path: cfg.SharePathUp, AllowLocal: true}
+	cfg.Usr1.AddShare(shr)
+	_ = cfg.Update(cfg.Usr1)
+	p, _ := shr.ResolveSymlinkName()
+	if _, err := cfg.User2FSShare.Stat(filepath.Join(cfg.Usr1.Username, p)); err != nil {
+		t.Fatal("share does not exists, but should be", err)
+	}
+
+	//rule added after share hides part of it
+	u, _ := cfg.GetUserByUsername(cfg.Usr1.Username)
+	u.Rules = utils.Rules{{Path: cfg.SharePathDeep, Deny: []string{utils.AccessRead}}}
+	if err := cfg.Update(u); err != nil {
+		t.Fatal(err)
+	}
+	u, _ = cfg.GetUserByUsername(cfg.Usr1.Username)
+	if len(u.GetShares(cfg.SharePathUp, false)) != 0 {
+		t.Fatal("share with denied path must be dropped")
+	}
+	if _, err := cfg.User2FSShare.Stat(filepath.Join(cfg.Usr1.Username, p)); err == nil {
+		t.Fatal("share link must be removed")
+	}
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//guards runtime state of users, never taken before update lock
+var stateLock = new(sync.Mutex)
+
+/*
+runtime state of the user, changed on every login, token renew or api call.
+kept in own file next to config, so it never rewrites config file, nor goes to its history
+*/
+type userState struct {
+	LastLogin *time.Time `json:"lastLogin,omitempty"`
+	LastIP    string     `json:"lastIP,omitempty"`
+	//last accepted totp time step, so every code works once
+	TOTPStep int64 `json:"totpStep,omitempty"`
+	//issued tokens, not expired and not revoked
+	Sessions []*Session `json:"sessions,omitempty"`
+	//last use of personal api tokens by token name
+	TokensUsed map[string]time.Time `json:"tokensUsed,omitempty"`
+}
+
+//runtime state of users by lower case username, with pending write
+type runtimeState struct {
+	users map[string]*userState
+	dirty bool
+	timer *time.Timer
+}
+
+//file with runtime state of users
+func (cfg *GlobalConfig) GetStatePath() string {
+	return cfg.Path + ".state"
+}
+
+//state of the user, created if missed. stateLock must be held
+func (cfg *GlobalConfig) userState(username string) *userState {
+	if cfg.state.users == nil {
+		cfg.state.users = make(map[string]*userState)
+	}
+	k := strings.ToLower(username)
+	s, ok := cfg.state.users[k]
+	if !ok {
+		s = &userState{}
+		cfg.state.users[k] = s
+	}
+	return s
+}
+
+//set runtime fields of the user copy from the state
+func (cfg *GlobalConfig) fillState(u *UserConfig) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	s, ok := cfg.state.users[strings.ToLower(u.Username)]
+	if !ok {
+		return
+	}
+	u.LastLogin = copyTime(s.LastLogin)
+	u.LastIP = s.LastIP
+	u.Sessions = copySessions(s.Sessions)
+	for _, t := range u.APITokens {
+		if used, ok := s.TokensUsed[strings.ToLower(t.Name)]; ok {
+			t.LastUsed = &used
+		}
+	}
+}
+
+//move runtime fields of users, read from config file written before state file, into the state. True in case any moved
+func (cfg *GlobalConfig) moveState(users []*UserConfig) (res bool) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	for _, u := range users {
+		if u.LastLogin == nil && len(u.LastIP) == 0 && len(u.Sessions) == 0 && !u.hasTokensUsed() {
+			continue
+		}
+		s := cfg.userState(u.Username)
+		if s.LastLogin == nil {
+			s.LastLogin, s.LastIP = u.LastLogin, u.LastIP
+		}
+		if len(s.Sessions) == 0 {
+			s.Sessions = u.Sessions
+		}
+		for _, t := range u.APITokens {
+			if t.LastUsed != nil {
+				if s.TokensUsed == nil {
+					s.TokensUsed = make(map[string]time.Time)
+				}
+				s.TokensUsed[strings.ToLower(t.Name)] = *t.LastUsed
+			}
+		}
+		u.clearState()
+		res = true
+	}
+	if res {
+		cfg.markStateDirty()
+	}
+	return
+}
+
+func (u *UserConfig) hasTokensUsed() bool {
+	for _, t := range u.APITokens {
+		if t.LastUsed != nil {
+			return true
+		}
+	}
+	return false
+}
+
+//runtime fields never stored in config
+func (u *UserConfig) clearState() {
+	u.LastLogin, u.LastIP, u.Sessions = nil, "", nil
+	for _, t := range u.APITokens {
+		t.LastUsed = nil
+	}
+}
+
+//move state of renamed user
+func (cfg *GlobalConfig) renameState(oldName, newName string) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	o, n := strings.ToLower(oldName), strings.ToLower(newName)
+	if s, ok := cfg.state.users[o]; ok && o != n {
+		delete(cfg.state.users, o)
+		cfg.state.users[n] = s
+		cfg.markStateDirty()
+	}
+}
+
+//forget state of deleted user
+func (cfg *GlobalConfig) dropState(username string) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	k := strings.ToLower(username)
+	if _, ok := cfg.state.users[k]; ok {
+		delete(cfg.state.users, k)
+		cfg.markStateDirty()
+	}
+}
+
+//schedule state write, stateLock must be held
+func (cfg *GlobalConfig) markStateDirty() {
+	cfg.state.dirty = true
+	if cfg.state.timer == nil {
+		cfg.state.timer = time.AfterFunc(writeDelay, cfg.FlushState)
+	}
+}
+
+//write state in case it was changed since last write
+func (cfg *GlobalConfig) FlushState() {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	if cfg.state.timer != nil {
+		cfg.state.timer.Stop()
+		cfg.state.timer = nil
+	}
+	if !cfg.state.dirty {
+		return
+	}
+	cfg.state.dirty = false
+	data, err := json.Marshal(cfg.state.users)
+	if err == nil {
+		err = writeTemp(cfg.GetStatePath(), data, 0600, nil)
+	}
+	if err != nil {
+		log.Println("config : cant write state file", err)
+	}
+}
+
+//read state file, missed file means empty state
+func (cfg *GlobalConfig) readState() {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	cfg.state.users = make(map[string]*userState)
+	data, err := ioutil.ReadFile(cfg.GetStatePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("config : cant read state file", err)
+		}
+		return
+	}
+	if err = json.Unmarshal(data, &cfg.state.users); err != nil {
+		log.Println("config : broken state file, ignored", err)
+		cfg.state.users = make(map[string]*userState)
+	}
+}
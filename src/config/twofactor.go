@@ -0,0 +1,189 @@
+package config
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/browsefile/backend/src/cnst"
+	"strings"
+	"time"
+)
+
+//totp second factor of the user
+type TwoFactor struct {
+	//base32 totp secret
+	Secret string `json:"secret"`
+	//hashes of not used recovery codes
+	Recovery []string `json:"recovery"`
+}
+
+//password of dav client, since basic auth can't carry totp code
+type AppPassword struct {
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Created time.Time `json:"created"`
+}
+
+func (t *TwoFactor) copy() *TwoFactor {
+	if t == nil {
+		return nil
+	}
+	return &TwoFactor{Secret: t.Secret, Recovery: append([]string(nil), t.Recovery...)}
+}
+
+func copyAppPasswords(list []*AppPassword) (res []*AppPassword) {
+	for _, p := range list {
+		c := *p
+		res = append(res, &c)
+	}
+	return
+}
+
+//true in case second factor enrolled
+func (u *UserConfig) HasTwoFactor() bool {
+	return u.TwoFactor != nil && len(u.TwoFactor.Secret) > 0
+}
+
+//clear password hash, totp secret and hashes of codes and tokens, before user goes to the frontend.
+//enrolled two factor stays as empty object
+func (u *UserConfig) HideSecrets() {
+	u.Password = ""
+	if u.TwoFactor != nil {
+		u.TwoFactor = &TwoFactor{}
+	}
+	for _, p := range u.AppPasswords {
+		p.Hash = ""
+	}
+	for _, t := range u.APITokens {
+		t.Hash = ""
+	}
+	u.Sessions = nil
+}
+
+//user not allowed to work without two factor
+func (cfg *GlobalConfig) TwoFactorRequired(u *UserConfig) bool {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	return cfg.AdminTwoFactor && u.Admin
+}
+
+//two factor required, but user not enrolled yet
+func (cfg *GlobalConfig) NeedsTwoFactor(u *UserConfig) bool {
+	return !u.HasTwoFactor() && cfg.TwoFactorRequired(u)
+}
+
+//enable totp with recovery code hashes, empty secret disables two factor
+func (cfg *GlobalConfig) SetTwoFactor(username, secret string, recovery []string) error {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	i := cfg.getUserIndex(username)
+	if i < 0 {
+		return cnst.ErrNotExist
+	}
+	if len(secret) == 0 {
+		cfg.Users[i].TwoFactor = nil
+	} else {
+		cfg.Users[i].TwoFactor = &TwoFactor{Secret: secret, Recovery: recovery}
+	}
+	cfg.markDirty()
+	return nil
+}
+
+//replace recovery codes of enrolled user
+func (cfg *GlobalConfig) SetRecoveryCodes(username string, recovery []string) error {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	i := cfg.getUserIndex(username)
+	if i < 0 || !cfg.Users[i].HasTwoFactor() {
+		return cnst.ErrNotExist
+	}
+	cfg.Users[i].TwoFactor.Recovery = recovery
+	cfg.markDirty()
+	return nil
+}
+
+//accept totp time step once, false in case same or later step already used
+func (cfg *GlobalConfig) UseTOTPStep(username string, step int64) bool {
+	updateLock.RLock()
+	defer updateLock.RUnlock()
+	i := cfg.getUserIndex(username)
+	if i < 0 || !cfg.Users[i].HasTwoFactor() {
+		return false
+	}
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	s := cfg.userState(cfg.Users[i].Username)
+	if step <= s.TOTPStep {
+		return false
+	}
+	s.TOTPStep = step
+	cfg.markStateDirty()
+	return true
+}
+
+//consume recovery code by its hash, true in case code was valid
+func (cfg *GlobalConfig) UseRecoveryCode(username, hash string) bool {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	i := cfg.getUserIndex(username)
+	if i < 0 || !cfg.Users[i].HasTwoFactor() {
+		return false
+	}
+	tf := cfg.Users[i].TwoFactor
+	for k, h := range tf.Recovery {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			tf.Recovery = append(tf.Recovery[:k], tf.Recovery[k+1:]...)
+			cfg.markDirty()
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *GlobalConfig) AddAppPassword(username string, p *AppPassword) error {
+	if len(p.Name) == 0 {
+		return errors.New("app password name is empty")
+	}
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	i := cfg.getUserIndex(username)
+	if i < 0 {
+		return cnst.ErrNotExist
+	}
+	for _, a := range cfg.Users[i].AppPasswords {
+		if strings.EqualFold(a.Name, p.Name) {
+			return cnst.ErrExist
+		}
+	}
+	c := *p
+	cfg.Users[i].AppPasswords = append(cfg.Users[i].AppPasswords, &c)
+	cfg.markDirty()
+	return nil
+}
+
+func (cfg *GlobalConfig) DeleteAppPassword(username, name string) error {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	i := cfg.getUserIndex(username)
+	if i < 0 {
+		return cnst.ErrNotExist
+	}
+	list := cfg.Users[i].AppPasswords
+	for k, a := range list {
+		if strings.EqualFold(a.Name, name) {
+			cfg.Users[i].AppPasswords = append(list[:k], list[k+1:]...)
+			cfg.markDirty()
+			return nil
+		}
+	}
+	return cnst.ErrNotExist
+}
+
+//true in case hash belongs to one of app passwords of the user
+func (u *UserConfig) HasAppPassword(hash string) bool {
+	for _, a := range u.AppPasswords {
+		if subtle.ConstantTimeCompare([]byte(a.Hash), []byte(hash)) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package config
+
+import "testing"
+
+func TestTwoFactorCodes(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	name := cfg.Usr1.Username
+	if cfg.UseTOTPStep(name, 10) {
+		t.Fatal("step accepted without two factor")
+	}
+	if err := cfg.SetTwoFactor(name, "JBSWY3DPEHPK3PXP", []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.UseTOTPStep(name, 10) || cfg.UseTOTPStep(name, 10) || cfg.UseTOTPStep(name, 9) {
+		t.Fatal("every step must be accepted once, in order")
+	}
+	if !cfg.UseRecoveryCode(name, "b") || cfg.UseRecoveryCode(name, "b") || cfg.UseRecoveryCode(name, "c") {
+		t.Fatal("recovery code must be accepted once")
+	}
+	u, _ := cfg.GetUserByUsername(name)
+	if len(u.TwoFactor.Recovery) != 1 {
+		t.Fatal("used code must be removed", u.TwoFactor.Recovery)
+	}
+
+	//hidden copy does not touch config
+	u.AppPasswords = []*AppPassword{{Name: "x", Hash: "h"}}
+	u.HideSecrets()
+	if u.HasTwoFactor() || len(u.Password) > 0 || len(u.AppPasswords[0].Hash) > 0 {
+		t.Fatal("secrets must be hidden")
+	}
+	if u, _ = cfg.GetUserByUsername(name); !u.HasTwoFactor() {
+		t.Fatal("config must keep two factor")
+	}
+
+	cfg.AdminTwoFactor = true
+	admin := cfg.GetAdmin()
+	if !cfg.NeedsTwoFactor(admin) || cfg.NeedsTwoFactor(u) {
+		t.Fatal("only admin must be required to enroll")
+	}
+	_ = cfg.SetTwoFactor(name, "", nil)
+	if u, _ = cfg.GetUserByUsername(name); u.HasTwoFactor() {
+		t.Fatal("two factor must be disabled")
+	}
+}
@@ -3,8 +3,10 @@ package config
 import (
 	"errors"
 	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/lib/utils"
 	"golang.org/x/net/webdav"
 	"strings"
+	"time"
 )
 
 // User contains the configuration for each user.
@@ -15,6 +17,8 @@ type UserConfig struct {
 	// These indicate if the user can perform certain actions.
 	AllowEdit bool `json:"allowEdit"` // Edit/rename files
 	AllowNew  bool `json:"allowNew"`  // Create files and folders
+	//fine-grained actions, allowEdit and allowNew derived from them
+	Perms *Permissions `json:"perms"`
 
 	// Prevents the user to change its password.
 	LockPassword bool `json:"lockPassword"`
@@ -40,23 +44,56 @@ type UserConfig struct {
 	//create files/folders according this ownership
 	UID int `json:"uid"`
 	GID int `json:"gid"`
+	//max bytes in user home, 0 means default quota, negative is unlimited
+	Quota int64 `json:"quota"`
+	//ordered path rules inside user home, like read only /archive
+	Rules utils.Rules `json:"rules,omitempty"`
+	//login refused
+	Disabled bool `json:"disabled"`
+	//account disabled after this moment, nil never expires
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	//user has to set new password before anything else
+	MustChangePassword bool `json:"mustChangePassword"`
+	//last successful login, and ip it came from. Runtime fields, kept at state file instead of config
+	LastLogin *time.Time `json:"lastLogin,omitempty"`
+	LastIP    string     `json:"lastIP,omitempty"`
+	//totp, checked on login in addition to password
+	TwoFactor *TwoFactor `json:"twoFactor,omitempty"`
+	//passwords of dav clients
+	AppPasswords []*AppPassword `json:"appPasswords,omitempty"`
+	//issued tokens, not expired and not revoked
+	Sessions []*Session `json:"sessions,omitempty"`
+	//personal tokens of scripts
+	APITokens []*APIToken `json:"apiTokens,omitempty"`
 }
 
 func (u *UserConfig) copyUser() (res *UserConfig) {
 	res = &UserConfig{
-		Username:     u.Username,
-		FirstRun:     u.FirstRun,
-		Password:     u.Password,
-		AllowNew:     u.AllowNew,
-		LockPassword: u.LockPassword,
-		ViewMode:     u.ViewMode,
-		Admin:        u.Admin,
-		AllowEdit:    u.AllowEdit,
-		Locale:       u.Locale,
-		UID:          u.UID,
-		GID:          u.GID,
-		DavHandler:   u.DavHandler,
-		IpAuth:       make([]string, len(u.IpAuth)),
+		Username:           u.Username,
+		FirstRun:           u.FirstRun,
+		Password:           u.Password,
+		AllowNew:           u.AllowNew,
+		LockPassword:       u.LockPassword,
+		ViewMode:           u.ViewMode,
+		Admin:              u.Admin,
+		AllowEdit:          u.AllowEdit,
+		Perms:              u.Perms.copy(),
+		Locale:             u.Locale,
+		UID:                u.UID,
+		GID:                u.GID,
+		Quota:              u.Quota,
+		Rules:              u.Rules.Copy(),
+		Disabled:           u.Disabled,
+		MustChangePassword: u.MustChangePassword,
+		ExpiresAt:          copyTime(u.ExpiresAt),
+		LastLogin:          copyTime(u.LastLogin),
+		LastIP:             u.LastIP,
+		TwoFactor:          u.TwoFactor.copy(),
+		AppPasswords:       copyAppPasswords(u.AppPasswords),
+		Sessions:           copySessions(u.Sessions),
+		APITokens:          copyAPITokens(u.APITokens),
+		DavHandler:         u.DavHandler,
+		IpAuth:             make([]string, len(u.IpAuth)),
 	}
 	copy(res.IpAuth, u.IpAuth)
 	res.Shares = make([]*ShareItem, len(u.Shares))
@@ -131,6 +168,7 @@ func (cfg *GlobalConfig) GetUserByUsername(username string) (*UserConfig, bool)
 			ViewMode:  admin.ViewMode,
 			AllowNew:  false,
 			AllowEdit: false,
+			Perms:     &Permissions{Download: true},
 		}, true
 	}
 
@@ -139,7 +177,9 @@ func (cfg *GlobalConfig) GetUserByUsername(username string) (*UserConfig, bool)
 		return nil, ok
 	}
 
-	return res.copyUser(), ok
+	res = res.copyUser()
+	cfg.fillState(res)
+	return res, ok
 }
 func (cfg *GlobalConfig) GetUserByIp(ip string) (*UserConfig, bool) {
 	updateLock.RLock()
@@ -150,7 +190,9 @@ func (cfg *GlobalConfig) GetUserByIp(ip string) (*UserConfig, bool) {
 		return nil, ok
 	}
 
-	return res.copyUser(), ok
+	res = res.copyUser()
+	cfg.fillState(res)
+	return res, ok
 }
 
 func (cfg *GlobalConfig) GetUsers() (res []*UserConfig) {
@@ -159,6 +201,7 @@ func (cfg *GlobalConfig) GetUsers() (res []*UserConfig) {
 	res = make([]*UserConfig, len(cfg.Users))
 	for i, u := range cfg.Users {
 		res[i] = u.copyUser()
+		cfg.fillState(res[i])
 	}
 
 	return res
@@ -172,8 +215,11 @@ func (cfg *GlobalConfig) AddUser(u *UserConfig) error {
 		return errors.New("User exists " + u.Username)
 	}
 
+	u.migratePerms()
+	u.clearState()
 	cfg.Users = append(cfg.Users, u)
 	cfg.RefreshUserRam()
+	cfg.markDirty()
 
 	return nil
 }
@@ -184,6 +230,8 @@ func (cfg *GlobalConfig) UpdatePassword(u *UserConfig) error {
 	if i >= 0 {
 		//update only specific fields
 		cfg.Users[i].Password = u.Password
+		cfg.Users[i].MustChangePassword = u.MustChangePassword
+		cfg.markDirty()
 	} else {
 		return errors.New("User does not exists " + u.Username)
 	}
@@ -194,6 +242,8 @@ func (cfg *GlobalConfig) Update(u *UserConfig) error {
 	defer updateLock.Unlock()
 	i := cfg.getUserIndex(u.Username)
 	if i >= 0 {
+		old := cfg.Users[i]
+		before := &UserConfig{Admin: old.Admin, Disabled: old.Disabled, Perms: old.Perms.copy(), Rules: old.Rules.Copy()}
 		//update only specific fields
 		cfg.Users[i].Admin = u.Admin
 		cfg.Users[i].ViewMode = u.ViewMode
@@ -201,12 +251,24 @@ func (cfg *GlobalConfig) Update(u *UserConfig) error {
 		cfg.Users[i].Shares = u.Shares
 		cfg.Users[i].IpAuth = u.IpAuth
 		cfg.Users[i].Locale = u.Locale
-		cfg.Users[i].AllowEdit = u.AllowEdit
-		cfg.Users[i].AllowNew = u.AllowNew
+		cfg.Users[i].updatePerms(u)
 		cfg.Users[i].LockPassword = u.LockPassword
 		cfg.Users[i].UID = u.UID
 		cfg.Users[i].GID = u.GID
+		cfg.Users[i].Quota = u.Quota
+		if !cfg.Users[i].Rules.Equal(u.Rules) {
+			cfg.Users[i].Rules = u.Rules.Copy()
+			cfg.dropUnreadableShares(cfg.Users[i])
+		}
+		cfg.Users[i].Disabled = u.Disabled
+		cfg.Users[i].ExpiresAt = copyTime(u.ExpiresAt)
+		cfg.Users[i].MustChangePassword = u.MustChangePassword
+		//tokens carry old access
+		if accessChanged(before, cfg.Users[i]) {
+			cfg.RevokeSessions(u.Username, "")
+		}
 		cfg.RefreshUserRam()
+		cfg.markDirty()
 	} else {
 		return errors.New("User does not exists " + u.Username)
 	}
@@ -225,6 +287,9 @@ func (cfg *GlobalConfig) DeleteUser(username string) error {
 		}
 
 		cfg.Users = append(cfg.Users[:i], cfg.Users[i+1:]...)
+		cfg.removeMember(username)
+		cfg.dropState(username)
+		cfg.markDirty()
 	}
 	cfg.RefreshUserRam()
 
@@ -0,0 +1,567 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//single problem found in config, with position at the file in case it known
+type ConfigError struct {
+	File  string `json:"file,omitempty"`
+	Field string `json:"field,omitempty"`
+	Line  int    `json:"line,omitempty"`
+	Col   int    `json:"col,omitempty"`
+	Msg   string `json:"msg"`
+	//not fatal, server can start
+	Warning bool `json:"warning,omitempty"`
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	if len(e.File) > 0 {
+		b.WriteString(e.File)
+		if e.Line > 0 {
+			b.WriteString(fmt.Sprintf(":%d:%d", e.Line, e.Col))
+		}
+		b.WriteString(": ")
+	}
+	if e.Warning {
+		b.WriteString("warning: ")
+	}
+	if len(e.Field) > 0 {
+		b.WriteString(e.Field + ": ")
+	}
+	b.WriteString(e.Msg)
+	return b.String()
+}
+
+type ConfigErrors []*ConfigError
+
+func (errs ConfigErrors) Error() string {
+	res := make([]string, len(errs))
+	for i, e := range errs {
+		res[i] = e.Error()
+	}
+	return strings.Join(res, "\n")
+}
+
+//true in case any of problems prevents server start
+func (errs ConfigErrors) HasErrors() bool {
+	for _, e := range errs {
+		if !e.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+//split problems by severity
+func (errs ConfigErrors) split() (fatal, warns ConfigErrors) {
+	for _, e := range errs {
+		if e.Warning {
+			warns = append(warns, e)
+		} else {
+			fatal = append(fatal, e)
+		}
+	}
+	return
+}
+
+//collects config problems, resolve fields to the lines in the file
+type validator struct {
+	file string
+	data []byte
+	//json field path to the offset in the file, like users[1].shares[0].path
+	pos  map[string]int
+	errs ConfigErrors
+}
+
+func newValidator(file string, data []byte) *validator {
+	v := &validator{file: file, data: data}
+	if data != nil {
+		v.pos = indexFields(data)
+	}
+	return v
+}
+
+func (v *validator) add(field string, warn bool, format string, args ...interface{}) {
+	e := &ConfigError{File: v.file, Field: field, Msg: fmt.Sprintf(format, args...), Warning: warn}
+	if off, ok := v.pos[field]; ok {
+		e.Line, e.Col = v.lineCol(off)
+	}
+	v.errs = append(v.errs, e)
+}
+
+func (v *validator) addAt(off int, field, msg string) {
+	e := &ConfigError{File: v.file, Field: field, Msg: msg}
+	if v.data != nil && off >= 0 && off <= len(v.data) {
+		e.Line, e.Col = v.lineCol(off)
+	}
+	v.errs = append(v.errs, e)
+}
+
+func (v *validator) lineCol(off int) (line, col int) {
+	if off > len(v.data) {
+		off = len(v.data)
+	}
+	before := v.data[:off]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = off - bytes.LastIndexByte(before, '\n')
+	return
+}
+
+//convert json decoding error to the config error with position
+func (v *validator) addJSONError(err error) {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		v.addAt(int(e.Offset), "", e.Error())
+	case *json.UnmarshalTypeError:
+		v.addAt(int(e.Offset), e.Field, fmt.Sprintf("cannot use %s as %s", e.Value, e.Type.String()))
+	default:
+		v.addAt(-1, "", err.Error())
+	}
+}
+
+func (v *validator) result() ConfigErrors {
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		return v.errs[i].Line < v.errs[j].Line
+	})
+	return v.errs
+}
+
+//map json field paths to the offsets of their keys/values in data
+func indexFields(data []byte) map[string]int {
+	type frame struct {
+		path    string
+		isArr   bool
+		idx     int
+		key     string
+		wantKey bool
+	}
+	res := make(map[string]int)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var stack []*frame
+	for {
+		off := skipSeparators(data, int(dec.InputOffset()))
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		d, isDelim := tok.(json.Delim)
+		if isDelim && (d == '}' || d == ']') {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		var p string
+		if top != nil {
+			if !top.isArr && top.wantKey {
+				top.key, _ = tok.(string)
+				top.wantKey = false
+				res[joinField(top.path, top.key)] = off
+				continue
+			}
+			if top.isArr {
+				p = fmt.Sprintf("%s[%d]", top.path, top.idx)
+				top.idx++
+				res[p] = off
+			} else {
+				p = joinField(top.path, top.key)
+				top.wantKey = true
+			}
+		}
+		if isDelim {
+			stack = append(stack, &frame{path: p, isArr: d == '[', wantKey: d == '{'})
+		}
+	}
+	return res
+}
+
+func skipSeparators(data []byte, off int) int {
+	for off < len(data) && strings.IndexByte(" \t\r\n,:", data[off]) >= 0 {
+		off++
+	}
+	return off
+}
+
+func joinField(path, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
+
+//report json keys, that does not match any field of type t
+func (v *validator) checkKeys(val interface{}, t reflect.Type, path string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := jsonFields(t)
+		for k, fv := range obj {
+			var ft reflect.Type
+			for name, f := range fields {
+				if strings.EqualFold(name, k) {
+					ft = f
+					break
+				}
+			}
+			if ft == nil {
+				v.add(joinField(path, k), false, "unknown field")
+			} else {
+				v.checkKeys(fv, ft, joinField(path, k))
+			}
+		}
+	case reflect.Slice:
+		arr, ok := val.([]interface{})
+		if !ok {
+			return
+		}
+		for i, e := range arr {
+			v.checkKeys(e, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+//json names of struct fields with their types
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	res := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && len(name) == 0 {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for n, sub := range jsonFields(ft) {
+					res[n] = sub
+				}
+				continue
+			}
+		}
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		res[name] = f.Type
+	}
+	return res
+}
+
+var authMethods = []string{"default", "proxy", "none", "noauth", "ip"}
+
+//check listeners, tls, preview and files path settings
+func (cfg *GlobalConfig) checkSettings(v *validator) {
+	if len(cfg.FilesPath) == 0 {
+		v.add("filesPath", false, "must be set")
+	}
+	if cfg.Http == nil {
+		v.add("http", false, "section missed")
+	}
+	if cfg.Auth == nil {
+		v.add("auth", false, "section missed")
+	}
+	if cfg.PreviewConf == nil {
+		v.add("preview", false, "section missed")
+	} else {
+		if len(cfg.ScriptPath) > 0 && !fileExists(cfg.ScriptPath) {
+			v.add("preview.scriptPath", false, "file %q does not exist", cfg.ScriptPath)
+		}
+		if cfg.Threads < 0 {
+			v.add("preview.threads", false, "must not be negative")
+		}
+	}
+	enabled := false
+	addrs := make(map[string]string)
+	for _, name := range []string{"http", "https"} {
+		l := cfg.Http
+		if name == "https" {
+			l = cfg.Tls
+		}
+		if l == nil {
+			continue
+		}
+		if l.Port < 0 || l.Port > 65535 {
+			v.add(name+".port", false, "port %d out of range 0-65535", l.Port)
+		}
+		if len(l.IP) > 0 && net.ParseIP(l.IP) == nil {
+			if _, err := net.LookupHost(l.IP); err != nil {
+				v.add(name+".ip", false, "%q is not an ip address or known host", l.IP)
+			}
+		}
+		//empty means default
+		if len(l.AuthMethod) > 0 && !contains(authMethods, l.AuthMethod) && len(l.Specs()) > 0 {
+			v.add(name+".authMethod", false, "unknown auth method %q, allowed %s", l.AuthMethod, strings.Join(authMethods, ", "))
+		}
+		if l.Port > 0 {
+			enabled = true
+			addrs["tcp://"+net.JoinHostPort(l.IP, strconv.Itoa(l.Port))] = name + ".port"
+		}
+		for i, s := range l.Listen {
+			field := fmt.Sprintf("%s.listen[%d]", name, i)
+			if s == nil {
+				v.add(field, false, "must not be null")
+				continue
+			}
+			enabled = true
+			if _, _, err := s.Parse(); err != nil {
+				v.add(field+".addr", false, "%q is not valid, %v", s.Addr, err)
+			} else if prev, ok := addrs[s.Addr]; ok {
+				v.add(field+".addr", false, "same address %q used by %s", s.Addr, prev)
+			} else {
+				addrs[s.Addr] = field
+			}
+			if len(s.AuthMethod) > 0 && !contains(authMethods, s.AuthMethod) {
+				v.add(field+".authMethod", false, "unknown auth method %q, allowed %s", s.AuthMethod, strings.Join(authMethods, ", "))
+			}
+		}
+	}
+	if cfg.Http != nil && cfg.Tls != nil && cfg.Http.Port > 0 && cfg.Http.Port == cfg.Tls.Port && cfg.Http.IP == cfg.Tls.IP {
+		v.add("https.port", false, "same port %d used by http", cfg.Tls.Port)
+	}
+	if cfg.Http != nil && !enabled {
+		v.add("http.port", false, "neither http nor https port or listen address set")
+	}
+	if _, _, err := parseBaseURL(cfg.BaseURL); err != nil {
+		v.add("baseURL", false, "%q is not valid, %v", cfg.BaseURL, err)
+	}
+	if _, err := parseProxies(cfg.TrustedProxies); err != nil {
+		v.add("trustedProxies", false, "%v", err)
+	}
+	if cfg.DefaultQuota < 0 {
+		v.add("defaultQuota", false, "must not be negative")
+	}
+	cfg.checkPassword(v)
+	cfg.checkLockout(v)
+	//self-signed files generated on start
+	if len(cfg.Tls.Specs()) > 0 && !cfg.TLSSelfSigned {
+		for field, p := range map[string]string{"tlsCert": cfg.TLSCert, "tlsKey": cfg.TLSKey} {
+			if len(p) == 0 {
+				v.add(field, false, "must be set, since https enabled")
+			} else if !fileExists(p) {
+				v.add(field, false, "file %q does not exist", p)
+			}
+		}
+	}
+}
+
+//check users and their shares
+func (cfg *GlobalConfig) checkUsers(v *validator) {
+	names := make(map[string]int)
+	ips := make(map[string]string)
+	hasAdmin := false
+	for i, u := range cfg.Users {
+		field := fmt.Sprintf("users[%d]", i)
+		if u == nil {
+			v.add(field, false, "empty user")
+			continue
+		}
+		hasAdmin = hasAdmin || u.Admin
+		n := strings.ToLower(u.Username)
+		if len(n) == 0 {
+			v.add(field+".username", false, "must be set")
+		} else if u.IsGuest() {
+			v.add(field+".username", false, "%q is reserved", u.Username)
+		} else if prev, ok := names[n]; ok {
+			v.add(field+".username", false, "duplicate username %q, already used by users[%d]", u.Username, prev)
+		} else {
+			names[n] = i
+		}
+		if len(u.Password) == 0 {
+			v.add(field+".password", false, "must be set")
+		}
+		if err := u.Rules.Validate(); err != nil {
+			v.add(field+".rules", false, "%v", err)
+		}
+		for j, ip := range u.IpAuth {
+			ipField := fmt.Sprintf("%s.ipAuth[%d]", field, j)
+			if net.ParseIP(ip) == nil {
+				v.add(ipField, false, "%q is not an ip address", ip)
+			} else if owner, ok := ips[ip]; ok && owner != u.Username {
+				v.add(ipField, false, "ip %s already used by %q", ip, owner)
+			} else {
+				ips[ip] = u.Username
+			}
+		}
+	}
+	if !hasAdmin {
+		v.add("users", false, "at least 1 admin user required")
+	}
+	for i, u := range cfg.Users {
+		if u == nil {
+			continue
+		}
+		paths := make(map[string]bool)
+		for j, shr := range u.Shares {
+			field := fmt.Sprintf("users[%d].shares[%d]", i, j)
+			p := strings.TrimSuffix(shr.Path, "/")
+			if len(p) == 0 {
+				v.add(field+".path", false, "must be set")
+				continue
+			}
+			if paths[p] {
+				v.add(field+".path", false, "duplicate share %q", shr.Path)
+			}
+			paths[p] = true
+			if len(cfg.FilesPath) > 0 && !fileExists(filepath.Join(cfg.GetUserHomePath(u.Username), p)) {
+				v.add(field+".path", true, "%q does not exist, share will be dropped", shr.Path)
+			}
+			for k, uName := range shr.AllowUsers {
+				if _, ok := names[strings.ToLower(uName)]; !ok {
+					v.add(fmt.Sprintf("%s.allowedUsers[%d]", field, k), true, "unknown user %q", uName)
+				}
+			}
+			for k, gName := range shr.AllowGroups {
+				if cfg.getGroupIndex(gName) < 0 {
+					v.add(fmt.Sprintf("%s.allowGroups[%d]", field, k), true, "unknown group %q", gName)
+				}
+			}
+		}
+	}
+	cfg.checkGroups(v, names)
+}
+
+//check group names and members, names are known usernames in lower case
+func (cfg *GlobalConfig) checkGroups(v *validator, names map[string]int) {
+	groups := make(map[string]int)
+	for i, g := range cfg.Groups {
+		field := fmt.Sprintf("groups[%d]", i)
+		if g == nil {
+			v.add(field, false, "empty group")
+			continue
+		}
+		n := strings.ToLower(g.Name)
+		if len(n) == 0 {
+			v.add(field+".name", false, "must be set")
+		} else if prev, ok := groups[n]; ok {
+			v.add(field+".name", false, "duplicate group %q, already used by groups[%d]", g.Name, prev)
+		} else {
+			groups[n] = i
+		}
+		for j, m := range g.Members {
+			if _, ok := names[strings.ToLower(m)]; !ok {
+				v.add(fmt.Sprintf("%s.members[%d]", field, j), true, "unknown user %q", m)
+			}
+		}
+	}
+}
+
+//validate settings part of config, used on settings update. Missed optional sections set to defaults
+func (cfg *GlobalConfig) VerifySettings() error {
+	cfg.defaultSections()
+	v := newValidator("", nil)
+	cfg.checkSettings(v)
+	if fatal, _ := v.result().split(); len(fatal) > 0 {
+		return fatal
+	}
+	return nil
+}
+
+/*
+parse file at path and report all problems, without applying config, or touching filesystem.
+error returned only in case file can't be read
+*/
+func ValidateConfigFile(p string) (ConfigErrors, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &GlobalConfig{Path: p}
+	return cfg.decode(p, data), nil
+}
+
+/*
+strict parse of the config file into cfg.
+err contains only problems, that prevents start, warnings returned separately
+*/
+func (cfg *GlobalConfig) parseConf(p string) (warns ConfigErrors, err error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	fatal, warns := cfg.decode(p, data).split()
+	if len(fatal) > 0 {
+		return warns, fatal
+	}
+	return warns, nil
+}
+
+//decode data into cfg, with validation of all the fields
+func (cfg *GlobalConfig) decode(p string, data []byte) ConfigErrors {
+	v := newValidator(p, data)
+	if err := json.Unmarshal(data, cfg); err != nil {
+		v.addJSONError(err)
+		return v.result()
+	}
+	var raw interface{}
+	_ = json.Unmarshal(data, &raw)
+	v.checkKeys(raw, reflect.TypeOf(cfg), "")
+	cfg.applyOverrides()
+	cfg.checkSettings(v)
+	cfg.checkUsers(v)
+	for _, e := range v.errs {
+		if o, ok := cfg.overrides[e.Field]; ok {
+			f := findOverrideField(e.Field)
+			name := f.env
+			if o.source == SrcFlag {
+				name = "-" + f.flag
+			}
+			e.Msg += fmt.Sprintf(" (set by %s %s)", o.source, name)
+			e.Line, e.Col = 0, 0
+		}
+	}
+	cfg.fileHash = sha256.Sum256(data)
+	cfg.defaultSections()
+
+	return v.result()
+}
+
+//optional sections, that may be missed in file or request
+func (cfg *GlobalConfig) defaultSections() {
+	if cfg.Tls == nil {
+		cfg.Tls = &ListenConf{}
+	}
+	if cfg.CaptchaConfig == nil {
+		cfg.CaptchaConfig = &CaptchaConfig{}
+	}
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+func contains(arr []string, s string) bool {
+	for _, a := range arr {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "bf_")
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "bf.json")
+	data := `{
+    "users": [
+        {"username": "admin", "password": "x", "admin": true},
+        {"username": "Admin", "password": "x"}
+    ],
+    "http": {"port": 70000, "authMethod": "default"},
+    "https": {"port": 0, "listen": [{"addr": "udp://:80"}]},
+    "preview": {"threads": 1, "scriptPath": "/not/exists.sh"},
+    "filesPath": "` + dir + `",
+    "auth": {"key": "k"},
+    "unknownKey": 1,
+    "baseURL": "ftp://host/files",
+    "trustedProxies": "10.0.0.0/8, proxy"
+}`
+	if err := ioutil.WriteFile(p, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	errs, err := ValidateConfigFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]int{
+		"users[1].username":    4,
+		"http.port":            6,
+		"https.listen[0].addr": 7,
+		"preview.scriptPath":   8,
+		"unknownKey":           11,
+		"baseURL":              12,
+		"trustedProxies":       13,
+	}
+	for _, e := range errs {
+		if l, ok := expect[e.Field]; ok {
+			if l != e.Line {
+				t.Error("wrong line for", e.Field, e.Line)
+			}
+			delete(expect, e.Field)
+		}
+	}
+	if len(expect) > 0 {
+		t.Fatal("problems not reported", expect, errs)
+	}
+	if !errs.HasErrors() {
+		t.Fatal("config must be invalid")
+	}
+
+	//syntax error points to position
+	_ = ioutil.WriteFile(p, []byte("{\n\"http\": {\"port\": \"80\"}}"), 0600)
+	errs, _ = ValidateConfigFile(p)
+	if len(errs) != 1 || errs[0].Line != 2 || !strings.Contains(errs[0].Field, "port") {
+		t.Fatal("type error must carry line and field", errs)
+	}
+
+	cfg := GlobalConfig{Path: p}
+	if cfg.ReadConfigFile() == nil {
+		t.Fatal("invalid config must not be applied")
+	}
+}
+
+func TestValidateWrittenConfig(t *testing.T) {
+	cfg := TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	shr := &ShareItem{Path: cfg.SharePathDeep, AllowUsers: []string{"admin"}}
+	cfg.Usr1.AddShare(shr)
+	_ = cfg.Update(cfg.Usr1)
+	shr.AllowUsers = append(shr.AllowUsers, "nobody")
+	cfg.WriteConfig()
+	errs, err := ValidateConfigFile(cfg.Path)
+	if err != nil || errs.HasErrors() {
+		t.Fatal("written config must be valid", err, errs)
+	}
+	if len(errs) != 1 || !errs[0].Warning {
+		t.Fatal("unknown share user must be warning", errs)
+	}
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/browsefile/backend/src/config"
+	"io"
+	"strings"
+)
+
+/*
+run admin command from the command line, through admin socket in case server running,
+otherwise directly against config file. returns process exit code
+*/
+func Run(cfg *config.GlobalConfig, args []string, stdin io.Reader, out io.Writer) int {
+	if err := readPassword(args, stdin); err != nil {
+		_, _ = fmt.Fprintln(out, err)
+		return 1
+	}
+	if !cfg.ResolvePath() {
+		_, _ = fmt.Fprintln(out, "config file not found")
+		return 1
+	}
+	res, ok, err := execRemote(cfg.GetAdminSocketPath(), args)
+	if ok {
+		if err != nil {
+			_, _ = fmt.Fprintln(out, "admin socket :", err)
+			return 1
+		}
+		_, _ = io.WriteString(out, res.Out)
+		if len(res.Error) > 0 {
+			return printErr(out, errors.New(res.Error))
+		}
+		return 0
+	}
+
+	if err = cfg.ReadConfigFile(); err != nil {
+		_, _ = fmt.Fprintln(out, err)
+		return 1
+	}
+	err = Exec(&Env{Config: cfg}, args, out)
+	if fErr := cfg.Flush(); err == nil {
+		err = fErr
+	}
+	if err != nil {
+		return printErr(out, err)
+	}
+	return 0
+}
+
+func printErr(out io.Writer, err error) int {
+	_, _ = fmt.Fprintln(out, "error:", err)
+	if err.Error() == errUsage.Error() {
+		_, _ = fmt.Fprintln(out, usage)
+	}
+	return 1
+}
+
+//replace password "-" by line from stdin, so it not visible at process list
+func readPassword(args []string, stdin io.Reader) error {
+	if len(args) < 3 || args[0] != "user" || (args[1] != "add" && args[1] != "passwd") {
+		return nil
+	}
+	last := len(args) - 1
+	if args[last] != "-" {
+		return nil
+	}
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	args[last] = strings.TrimRight(line, "\r\n")
+	return nil
+}
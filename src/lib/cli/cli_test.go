@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"bytes"
+	"github.com/browsefile/backend/src/config"
+	"github.com/browsefile/backend/src/lib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExec(t *testing.T) {
+	cfg := config.TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	env := &Env{Config: cfg.GlobalConfig}
+	out := new(bytes.Buffer)
+
+	if err := Exec(env, []string{"user", "add", "-admin", "bob", "secret"}, out); err != nil {
+		t.Fatal(err)
+	}
+	u, ok := cfg.GetUserByUsername("bob")
+	if !ok || !u.Admin || !lib.CheckPasswordHash("secret", u.Password) {
+		t.Fatal("user not added")
+	}
+	if Exec(env, []string{"user", "add", "bob", "x"}, out) == nil {
+		t.Fatal("duplicate user added")
+	}
+	_ = Exec(env, []string{"user", "passwd", "bob", "other"}, out)
+	u, _ = cfg.GetUserByUsername("bob")
+	if !lib.CheckPasswordHash("other", u.Password) {
+		t.Fatal("password not changed")
+	}
+	out.Reset()
+	_ = Exec(env, []string{"user", "list"}, out)
+	if !strings.Contains(out.String(), "bob") || !strings.Contains(out.String(), "user1") {
+		t.Fatal("users not listed", out.String())
+	}
+
+	cfg.Usr1.AddShare(&config.ShareItem{Path: cfg.SharePathUp, AllowLocal: true})
+	_ = cfg.Update(cfg.Usr1)
+	out.Reset()
+	_ = Exec(env, []string{"share", "list", "user1"}, out)
+	if !strings.Contains(out.String(), cfg.SharePathUp) {
+		t.Fatal("share not listed", out.String())
+	}
+	if err := Exec(env, []string{"share", "revoke", "user1", cfg.SharePathUp}, out); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ = cfg.GetUserByUsername("user1"); len(u.Shares) > 0 {
+		t.Fatal("share not revoked")
+	}
+
+	if err := Exec(env, []string{"config", "set", "externalShareHost", "http://cli.host"}, out); err != nil ||
+		cfg.ExternalShareHost != "http://cli.host" {
+		t.Fatal("setting not changed", err)
+	}
+	if Exec(env, []string{"config", "set", "http.port", "abc"}, out) == nil {
+		t.Fatal("bad value accepted")
+	}
+	port := cfg.Http.Port
+	if Exec(env, []string{"config", "set", "http.port", "70000"}, out) == nil || cfg.Http.Port != port {
+		t.Fatal("invalid setting applied")
+	}
+	_ = ioutil.WriteFile(filepath.Join(cfg.GetUserHomePath("bob"), "big.txt"), make([]byte, 1000), 0600)
+	_ = lib.UsedSpace(cfg.GlobalConfig, "bob")
+	if err := Exec(env, []string{"user", "rename", "bob", "rob"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = cfg.GetUserByUsername("rob"); !ok {
+		t.Fatal("user not renamed")
+	}
+	if lib.UsedSpace(cfg.GlobalConfig, "bob") != 0 {
+		t.Fatal("used space of old name must be forgotten")
+	}
+	_ = Exec(env, []string{"user", "delete", "rob"}, out)
+	if _, ok = cfg.GetUserByUsername("rob"); ok {
+		t.Fatal("user not deleted")
+	}
+	if Exec(env, []string{"user", "delete", "admin"}, out) == nil {
+		t.Fatal("last admin deleted")
+	}
+
+	//cached used space of move target follows moved files
+	_ = ioutil.WriteFile(filepath.Join(cfg.GetUserHomePath("user1"), "big.txt"), make([]byte, 1000), 0600)
+	before := lib.UsedSpace(cfg.GlobalConfig, "admin")
+	if err := Exec(env, []string{"user", "delete", "-mode", "move", "-target", "admin", "user1"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if lib.UsedSpace(cfg.GlobalConfig, "admin") < before+1000 {
+		t.Fatal("used space of move target must be recounted")
+	}
+}
+
+func TestRunSocket(t *testing.T) {
+	cfg := config.TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	cfg.WriteConfig()
+	l, err := Listen(cfg.GetAdminSocketPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if inf, err := os.Stat(filepath.Dir(cfg.GetAdminSocketPath())); err != nil || inf.Mode().Perm() != 0700 {
+		t.Fatal("socket folder must be private", err)
+	}
+	added := false
+	go Serve(l, &Env{Config: cfg.GlobalConfig, PrepareUser: func(u *config.UserConfig) { added = true }})
+	if _, err = Listen(cfg.GetAdminSocketPath()); err == nil {
+		t.Fatal("second server must not take socket")
+	}
+
+	out := new(bytes.Buffer)
+	n := &config.GlobalConfig{Path: cfg.Path}
+	code := Run(n, []string{"user", "add", "carl", "-"}, strings.NewReader("pwd\n"), out)
+	if code != 0 || !added {
+		t.Fatal("command not executed by server", out.String())
+	}
+	u, ok := cfg.GetUserByUsername("carl")
+	if !ok || !lib.CheckPasswordHash("pwd", u.Password) {
+		t.Fatal("user not added to running config")
+	}
+	out.Reset()
+	if Run(n, []string{"user", "delete", "nobody"}, nil, out) == 0 || !strings.Contains(out.String(), "error") {
+		t.Fatal("error not reported", out.String())
+	}
+	//server stopped, config file used directly
+	_ = l.Close()
+	cfg.Flush()
+	out.Reset()
+	if Run(n, []string{"user", "list"}, nil, out) != 0 || !strings.Contains(out.String(), "carl") {
+		t.Fatal("offline command failed", out.String())
+	}
+}
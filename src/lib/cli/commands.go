@@ -0,0 +1,436 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"github.com/browsefile/backend/src/lib"
+	"github.com/browsefile/backend/src/lib/preview"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+//target of admin commands, running server or config file
+type Env struct {
+	Config *config.GlobalConfig
+	//preview generator of running server, nil in offline mode
+	Pgen *preview.PreviewGen
+	//setup runtime parts of new user, like dav handler
+	PrepareUser func(u *config.UserConfig)
+	//apply changed settings to running server, returns settings that need restart
+	ApplySettings func(changed []string) []string
+}
+
+var errUsage = errors.New("wrong arguments")
+
+var usage = `admin commands:
+  user add [-admin] [-allow-edit] [-allow-new] [-perms list] [-expires 168h|date] [-must-change-password] <username> <password|->
+  user passwd <username> <password|->
+  user list
+  user delete [-mode keep|archive|move|purge] [-target username] [-dry-run] <username>
+  user rename <username> <new username>
+  user reset-2fa <username>
+  share list [username]
+  share revoke <username> <path>
+  config show
+  config set <key> <value>
+  preview rebuild <username>
+password "-" reads it from stdin`
+
+//true in case args starts with admin command
+func IsCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "user", "share", "config", "preview":
+		return true
+	}
+	return false
+}
+
+//run command against env, output goes to out
+func Exec(env *Env, args []string, out io.Writer) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	cmd, args := args[0]+" "+args[1], args[2:]
+	switch cmd {
+	case "user add":
+		return userAdd(env, args, out)
+	case "user passwd":
+		return userPasswd(env, args, out)
+	case "user list":
+		return userList(env, out)
+	case "user delete":
+		return userDelete(env, args, out)
+	case "user rename":
+		return userRename(env, args, out)
+	case "user reset-2fa":
+		return userResetTwoFactor(env, args, out)
+	case "share list":
+		return shareList(env, args, out)
+	case "share revoke":
+		return shareRevoke(env, args, out)
+	case "config show":
+		return configShow(env, out)
+	case "config set":
+		return configSet(env, args, out)
+	case "preview rebuild":
+		return previewRebuild(env, args, out)
+	}
+	return errUsage
+}
+
+func userAdd(env *Env, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+	fs.SetOutput(out)
+	admin := fs.Bool("admin", false, "admin user")
+	allowEdit := fs.Bool("allow-edit", false, "allow edit/rename files")
+	allowNew := fs.Bool("allow-new", false, "allow create files and folders")
+	perms := fs.String("perms", "", "comma separated permissions, overrides allow-edit and allow-new")
+	expires := fs.String("expires", "", "account expires after duration like 168h, or at date like 2006-01-02")
+	mustChange := fs.Bool("must-change-password", false, "user has to change password on first login")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+	name, pwd := fs.Arg(0), fs.Arg(1)
+	if len(name) == 0 {
+		return cnst.ErrEmptyUsername
+	}
+	if name == cnst.GUEST {
+		return errors.New("username " + name + " is reserved")
+	}
+	if len(pwd) == 0 {
+		return cnst.ErrEmptyPassword
+	}
+	if err := env.Config.GetPasswordConf().Check(pwd); err != nil {
+		return err
+	}
+	hash, err := lib.HashPassword(env.Config, pwd)
+	if err != nil {
+		return err
+	}
+	u := &config.UserConfig{
+		Username:  name,
+		Password:  hash,
+		Admin:     *admin,
+		AllowEdit: *allowEdit,
+		AllowNew:  *allowNew,
+		ViewMode:  cnst.MosaicViewMode,
+		Locale:    "en",
+
+		MustChangePassword: *mustChange,
+	}
+	if len(*expires) > 0 {
+		if u.ExpiresAt, err = parseExpiry(*expires); err != nil {
+			return err
+		}
+	}
+	if len(*perms) > 0 {
+		if u.Perms, err = config.ParsePermissions(*perms); err != nil {
+			return err
+		}
+	}
+	if env.PrepareUser != nil {
+		env.PrepareUser(u)
+	}
+	if err = os.MkdirAll(env.Config.GetUserHomePath(name), cnst.PERM_DEFAULT); err != nil {
+		return err
+	}
+	if err = env.Config.AddUser(u); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(out, "user", name, "added")
+	return nil
+}
+
+func userPasswd(env *Env, args []string, out io.Writer) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	u, ok := env.Config.GetUserByUsername(args[0])
+	if !ok || u.IsGuest() {
+		return cnst.ErrNotExist
+	}
+	if len(args[1]) == 0 {
+		return cnst.ErrEmptyPassword
+	}
+	err := env.Config.GetPasswordConf().Check(args[1])
+	if err != nil {
+		return err
+	}
+	if u.Password, err = lib.HashPassword(env.Config, args[1]); err != nil {
+		return err
+	}
+	if err = env.Config.UpdatePassword(u); err != nil {
+		return err
+	}
+	env.Config.RevokeSessions(u.Username, "")
+	_, _ = fmt.Fprintln(out, "password of", u.Username, "changed")
+	return nil
+}
+
+func userList(env *Env, out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "USERNAME\tADMIN\tSTATE\tPERMS\tSHARES\tIP\tLAST LOGIN")
+	for _, u := range env.Config.GetUsers() {
+		last := "-"
+		if u.LastLogin != nil {
+			last = u.LastLogin.Format("2006-01-02 15:04") + " " + u.LastIP
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%v\t%s\t%s\t%d\t%s\t%s\n", u.Username, u.Admin, userState(u), u.Perms,
+			len(u.Shares), strings.Join(u.IpAuth, ","), last)
+	}
+	return w.Flush()
+}
+
+func userState(u *config.UserConfig) string {
+	switch {
+	case u.Disabled:
+		return "disabled"
+	case u.Expired():
+		return "expired"
+	case u.MustChangePassword:
+		return "must-change-password"
+	}
+	return "active"
+}
+
+//duration from now, or date
+func parseExpiry(s string) (*time.Time, error) {
+	var t time.Time
+	if d, err := time.ParseDuration(s); err == nil {
+		t = time.Now().Add(d)
+	} else if t, err = time.ParseInLocation("2006-01-02", s, time.Local); err != nil {
+		if t, err = time.Parse(time.RFC3339, s); err != nil {
+			return nil, errors.New("wrong expiry " + s)
+		}
+	}
+	return &t, nil
+}
+
+func userDelete(env *Env, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("user delete", flag.ContinueOnError)
+	fs.SetOutput(out)
+	mode := fs.String("mode", config.DeleteKeep, "what to do with files: keep, archive, move or purge")
+	target := fs.String("target", "", "user receives files in move mode")
+	dryRun := fs.Bool("dry-run", false, "only print what will be touched")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	u, ok := env.Config.GetUserByUsername(fs.Arg(0))
+	if !ok || u.IsGuest() {
+		return cnst.ErrNotExist
+	}
+	if u.Admin && countAdmins(env.Config) == 1 {
+		return errors.New("can't delete last admin " + u.Username)
+	}
+	plan, err := env.Config.DeleteUserData(u.Username, &config.DeleteOptions{Mode: *mode, Target: *target, DryRun: *dryRun})
+	if plan == nil {
+		return err
+	}
+	printPlan(plan, out)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		_, _ = fmt.Fprintln(out, "dry run, nothing changed")
+		return nil
+	}
+	//server keeps used space of homes cached
+	lib.ResetUsedSpace(env.Config, u.Username)
+	if len(plan.Target) > 0 {
+		lib.ResetUsedSpace(env.Config, plan.Target)
+	}
+	if plan.Mode == config.DeleteKeep {
+		_, _ = fmt.Fprintln(out, "user", u.Username, "deleted, files kept at", env.Config.GetDavPath(u.Username))
+	} else {
+		_, _ = fmt.Fprintln(out, "user", u.Username, "deleted")
+	}
+	return nil
+}
+
+func printPlan(plan *config.DeletePlan, out io.Writer) {
+	_, _ = fmt.Fprintln(out, "mode:", plan.Mode)
+	if len(plan.Destination) > 0 {
+		_, _ = fmt.Fprintln(out, "files to:", plan.Destination)
+	}
+	for _, p := range plan.Remove {
+		_, _ = fmt.Fprintln(out, "remove:", p)
+	}
+	for _, p := range plan.Shares {
+		_, _ = fmt.Fprintln(out, "share:", p)
+	}
+	for _, p := range plan.Links {
+		_, _ = fmt.Fprintln(out, "link:", p)
+	}
+	for _, g := range plan.Groups {
+		_, _ = fmt.Fprintln(out, "group:", g)
+	}
+}
+
+func userRename(env *Env, args []string, out io.Writer) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	u, ok := env.Config.GetUserByUsername(args[0])
+	if !ok || u.IsGuest() {
+		return cnst.ErrNotExist
+	}
+	if err := env.Config.RenameUser(u.Username, args[1], env.PrepareUser); err != nil {
+		return err
+	}
+	//used space cached by home path
+	lib.ResetUsedSpace(env.Config, u.Username)
+	_, _ = fmt.Fprintln(out, "user", u.Username, "renamed to", args[1])
+	return nil
+}
+
+//disable two factor of user, who lost the device. App passwords stay
+func userResetTwoFactor(env *Env, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := env.Config.SetTwoFactor(args[0], "", nil); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(out, "two factor disabled for", args[0])
+	return nil
+}
+
+func countAdmins(cfg *config.GlobalConfig) (res int) {
+	for _, u := range cfg.GetUsers() {
+		if u.Admin {
+			res++
+		}
+	}
+	return
+}
+
+func shareList(env *Env, args []string, out io.Writer) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	var users []*config.UserConfig
+	if len(args) == 1 {
+		u, ok := env.Config.GetUserByUsername(args[0])
+		if !ok || u.IsGuest() {
+			return cnst.ErrNotExist
+		}
+		users = append(users, u)
+	} else {
+		users = env.Config.GetUsers()
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "OWNER\tPATH\tLOCAL\tEXTERNAL\tUSERS\tHASH")
+	for _, u := range users {
+		for _, shr := range u.Shares {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%v\t%v\t%s\t%s\n", u.Username, shr.Path, shr.AllowLocal, shr.AllowExternal,
+				strings.Join(shr.AllowUsers, ","), shr.Hash)
+		}
+	}
+	return w.Flush()
+}
+
+func shareRevoke(env *Env, args []string, out io.Writer) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	u, ok := env.Config.GetUserByUsername(args[0])
+	if !ok || u.IsGuest() {
+		return cnst.ErrNotExist
+	}
+	p := args[1]
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	if len(u.GetShares(p, false)) == 0 || !u.DeleteShare(p) {
+		return errors.New("share " + p + " of " + u.Username + " not found")
+	}
+	if err := env.Config.Update(u); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(out, "share", p, "of", u.Username, "revoked")
+	return nil
+}
+
+func configShow(env *Env, out io.Writer) error {
+	cfg := env.Config.CopyConfig()
+	//users managed by user commands
+	cfg.Users = nil
+	data, err := json.MarshalIndent(cfg, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(out, string(data))
+	if ro := env.Config.ReadOnlySettings(); len(ro) > 0 {
+		_, _ = fmt.Fprintln(out, "read-only:")
+		for k, src := range ro {
+			_, _ = fmt.Fprintf(out, "  %s (%s)\n", k, src)
+		}
+	}
+	return nil
+}
+
+func configSet(env *Env, args []string, out io.Writer) error {
+	if len(args) != 2 {
+		_, _ = fmt.Fprintln(out, "settings:", strings.Join(config.SettingKeys(), ", "))
+		return errUsage
+	}
+	changed, err := env.Config.SetSetting(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(out, args[0], "set to", args[1])
+	if env.ApplySettings != nil {
+		if restart := env.ApplySettings(changed); len(restart) > 0 {
+			_, _ = fmt.Fprintln(out, "restart required to apply", strings.Join(restart, ", "))
+		}
+	}
+	return nil
+}
+
+func previewRebuild(env *Env, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	u, ok := env.Config.GetUserByUsername(args[0])
+	if !ok || u.IsGuest() {
+		return cnst.ErrNotExist
+	}
+	if len(env.Config.ScriptPath) == 0 {
+		return errors.New("preview script not set")
+	}
+	home, prevPath := env.Config.GetUserHomePath(u.Username), env.Config.GetUserPreviewPath(u.Username)
+	if err := os.RemoveAll(prevPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(prevPath, cnst.PERM_DEFAULT); err != nil {
+		return err
+	}
+	if env.Pgen != nil {
+		go env.Pgen.ProcessPath(home, prevPath)
+		_, _ = fmt.Fprintln(out, "preview rebuild of", u.Username, "started")
+		return nil
+	}
+	pgen := new(preview.PreviewGen)
+	pgen.Setup(1, env.Config.ScriptPath)
+	pgen.ProcessPath(home, prevPath)
+	_ = pgen.Stop(context.Background())
+	_, _ = fmt.Fprintln(out, "preview of", u.Username, "rebuilt")
+	return nil
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//command sent to the running server over admin socket
+type request struct {
+	Args []string `json:"args"`
+}
+
+type response struct {
+	Out   string `json:"out"`
+	Error string `json:"error,omitempty"`
+}
+
+/*
+open admin socket of running server, next to the config file.
+only owner of the process can connect, since socket created inside 0700 folder, so it never reachable with default umask
+*/
+func Listen(socketPath string) (net.Listener, error) {
+	dir := filepath.Dir(socketPath)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	//folder may exist with wider permissions
+	if err := os.Chmod(dir, 0700); err != nil {
+		return nil, err
+	}
+	//previous process may left socket file, reuse it in case nobody listens
+	if c, err := net.DialTimeout("unix", socketPath, time.Second); err == nil {
+		_ = c.Close()
+		return nil, os.ErrExist
+	}
+	_ = os.Remove(socketPath)
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(socketPath, 0600); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+//execute commands from admin socket, until listener closed
+func Serve(l net.Listener, env *Env) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go handle(conn, env)
+	}
+}
+
+func handle(conn net.Conn, env *Env) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Minute))
+	var req request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		log.Println("admin : bad request", err)
+		return
+	}
+	out := new(bytes.Buffer)
+	res := &response{}
+	if err := Exec(env, req.Args, out); err != nil {
+		res.Error = err.Error()
+	}
+	res.Out = out.String()
+	_ = json.NewEncoder(conn).Encode(res)
+}
+
+//run command at the server listening on socketPath, false in case server not running
+func execRemote(socketPath string, args []string) (res *response, ok bool, err error) {
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		return nil, false, nil
+	}
+	defer conn.Close()
+	if err = json.NewEncoder(conn).Encode(&request{Args: args}); err != nil {
+		return nil, true, err
+	}
+	res = &response{}
+	err = json.NewDecoder(conn).Decode(res)
+	return res, true, err
+}
@@ -1,11 +1,14 @@
 package lib
 
 import (
+	"context"
 	"github.com/browsefile/backend/src/cnst"
 	"github.com/browsefile/backend/src/config"
 	"github.com/browsefile/backend/src/lib/utils"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -16,7 +19,13 @@ type FitFilter func(name, p string) bool
 type Context struct {
 	*FileBrowser
 	User *UserModel
-	File *File
+	//session id of request token
+	Session string
+	//personal api token of the request, nil for login tokens
+	APIToken *config.APIToken
+	//request authenticated by signed url
+	Signed bool
+	File   *File
 	// On API handlers, Router is the APi handler we want.
 	Router int
 	*Params
@@ -47,6 +56,8 @@ type Params struct {
 	Checksum string
 
 	Inline bool
+	//answer download by redirect to signed url
+	Redirect bool
 	// playlist & search file mime types, true if any was specified at request url, uses in FitFilter type
 	Audio bool
 	Image bool
@@ -56,7 +67,7 @@ type Params struct {
 	Query url.Values
 	//override existing file
 	Override bool
-	// used in resource patch requests type
+	// used in resource patch requests type, destination unescaped and cleaned
 	Destination string
 	Action      string
 
@@ -121,12 +132,40 @@ func (c *Context) GenSharesPreview(out string) {
 	}
 }
 
+//check path rules of the user, p is relative to user home. Shares of other users checked when created and when owner rules change
+func (c *Context) Allowed(p, access string) bool {
+	if c.IsShare && !c.IsExternalShare() {
+		return true
+	}
+	return c.User.FileSystem.Allowed(p, access)
+}
+
 //true if request contains rootHash param
 func (c *Context) IsExternalShare() (r bool) {
 	return len(c.RootHash) > 0
 }
+//listener request came from, http or https section and listen spec address
+type Listener struct {
+	Section string
+	Addr    string
+	//unix socket, peers are local processes like reverse proxy, trusted same as TrustedProxies
+	Unix bool
+}
+
+type listenerKey struct{}
+
+//context of requests accepted by listener
+func WithListener(ctx context.Context, l *Listener) context.Context {
+	return context.WithValue(ctx, listenerKey{}, l)
+}
+
+//settings of the listener request came from, auth method of its listen spec
 func (c *Context) GetAuthConfig() *config.ListenConf {
 	isTls := c.REQ.TLS != nil
+	l, ok := c.REQ.Context().Value(listenerKey{}).(*Listener)
+	if ok {
+		isTls = l.Section == "https"
+	}
 	var cfgM *config.ListenConf
 	if isTls {
 		cfgM = c.Config.Tls
@@ -134,5 +173,93 @@ func (c *Context) GetAuthConfig() *config.ListenConf {
 		cfgM = c.Config.Http
 
 	}
+	if ok {
+		if s := cfgM.FindSpec(l.Addr); s != nil {
+			return &config.ListenConf{Port: cfgM.Port, IP: cfgM.IP, AuthMethod: s.AuthMethod}
+		}
+	}
 	return cfgM
 }
+
+//request came from trusted reverse proxy, by TrustedProxies or through unix socket
+func (c *Context) fromProxy() bool {
+	if l, ok := c.REQ.Context().Value(listenerKey{}).(*Listener); ok && l.Unix {
+		return true
+	}
+	return c.Config.IsTrustedProxy(c.REQ.RemoteAddr)
+}
+
+//public path prefix of the app, X-Forwarded-Prefix of trusted proxy or path of baseURL
+func (c *Context) BasePath() string {
+	_, p := c.Config.GetBaseURL()
+	if c.fromProxy() {
+		if fp := forwarded(c.REQ, "X-Forwarded-Prefix"); len(fp) > 0 {
+			p = "/" + strings.Trim(fp, "/")
+			if p == "/" {
+				p = ""
+			}
+		}
+	}
+	return p
+}
+
+/*
+public scheme://host with base path, used in absolute links.
+baseURL origin wins, next X-Forwarded-Proto and X-Forwarded-Host of trusted proxy, next request itself
+*/
+func (c *Context) PublicURL() string {
+	origin, _ := c.Config.GetBaseURL()
+	if len(origin) == 0 {
+		scheme, host := "http", c.REQ.Host
+		if c.REQ.TLS != nil {
+			scheme = "https"
+		}
+		if c.fromProxy() {
+			if p := forwarded(c.REQ, "X-Forwarded-Proto"); p == "http" || p == "https" {
+				scheme = p
+			}
+			if h := forwarded(c.REQ, "X-Forwarded-Host"); len(h) > 0 {
+				host = h
+			}
+		}
+		if len(host) == 0 {
+			//bind address, ipv6 in brackets
+			l := c.GetAuthConfig()
+			ip := l.IP
+			if len(ip) == 0 {
+				ip = "localhost"
+			}
+			host = net.JoinHostPort(ip, strconv.Itoa(l.Port))
+		}
+		origin = scheme + "://" + host
+	}
+	return origin + c.BasePath()
+}
+
+//first value of comma separated forwarded header
+func forwarded(r *http.Request, name string) string {
+	return strings.TrimSpace(strings.SplitN(r.Header.Get(name), ",", 2)[0])
+}
+
+//address of the client, X-Forwarded-For resolved through trusted proxies
+func (c *Context) ClientIP() string {
+	ip := c.REQ.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !c.fromProxy() {
+		return ip
+	}
+	hops := strings.Split(c.REQ.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		h := strings.TrimSpace(hops[i])
+		if len(h) == 0 {
+			continue
+		}
+		ip = h
+		if !c.Config.IsTrustedProxy(h) {
+			break
+		}
+	}
+	return ip
+}
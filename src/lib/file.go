@@ -106,6 +106,10 @@ func MakeInfo(c *Context) (*File, error) {
 	if err != nil {
 		return nil, err
 	}
+	//hidden by path rules
+	if !c.Allowed(c.URL, utils.AccessList) && !c.Allowed(c.URL, utils.AccessRead) {
+		return nil, os.ErrNotExist
+	}
 	i := &File{
 		URL:         c.URL,
 		VirtualPath: c.URL,
@@ -158,17 +162,26 @@ func (i *File) GetListing(c *Context) (files []os.FileInfo, paths []string, err
 			names, err := f.Readdirnames(-1)
 			for _, n := range names {
 				nMod := filepath.Join(i.VirtualPath, n)
-				paths = append(paths, nMod)
+				if !c.Allowed(nMod, utils.AccessList) {
+					continue
+				}
 				inf, err := fs.Stat(nMod)
 				if err != nil {
 					return nil, nil, err
 				}
+				if !i.allowed(c, nMod, inf.IsDir()) {
+					continue
+				}
+				paths = append(paths, nMod)
 				files = append(files, inf)
 			}
 			if err != nil {
 				return nil, nil, err
 			}
 		} else {
+			if (c.Router == cnst.R_DOWNLOAD || c.Router == cnst.R_PLAYLIST) && !c.Allowed(i.VirtualPath, utils.AccessRead) {
+				return nil, nil, os.ErrPermission
+			}
 			p := filepath.Join(fs.String(), i.VirtualPath)
 			if c.IsShare {
 				inf, p, err = utils.ResolveSymlink(p)
@@ -221,6 +234,9 @@ func (i *File) listRecurs(c *Context, path string) (files []os.FileInfo, paths [
 				return nil
 
 			} else {
+				if !i.allowed(c, strings.TrimPrefix(path, c.GetUserHomePath()), info.IsDir()) {
+					return nil
+				}
 				if c.FitFilter != nil && c.FitFilter(info.Name(), path) || c.FitFilter == nil {
 					files = append(files, info)
 					paths = append(paths, c.CutPath(path))
@@ -235,6 +251,14 @@ func (i *File) listRecurs(c *Context, path string) (files []os.FileInfo, paths [
 	return files, paths
 }
 
+//entry visible in listing, files served as content by download and playlist must be readable as well
+func (i *File) allowed(c *Context, p string, isDir bool) bool {
+	if !c.Allowed(p, utils.AccessList) {
+		return false
+	}
+	return isDir || c.Router != cnst.R_DOWNLOAD && c.Router != cnst.R_PLAYLIST || c.Allowed(p, utils.AccessRead)
+}
+
 // ProcessList generate metainfo about dir/files
 func (i *File) ProcessList(c *Context) error {
 	// GetUsers the directory information using the Virtual File System of
@@ -7,7 +7,6 @@ import (
 	"github.com/browsefile/backend/src/config"
 	"github.com/browsefile/backend/src/lib/preview"
 	"github.com/browsefile/backend/src/lib/utils"
-	"golang.org/x/crypto/bcrypt"
 	"log"
 	"os"
 )
@@ -31,6 +30,8 @@ type FileBrowser struct {
 	//generates preview
 	Pgen   *preview.PreviewGen
 	Config *config.GlobalConfig
+	//rebind listeners according actual config, nil in case listeners not managed
+	Rebind func() error
 }
 
 // FileSystem is the interface to work with the file system.
@@ -42,6 +43,8 @@ type FileSystem interface {
 	Stat(name string) (os.FileInfo, error)
 	Copy(src, dst string, uid, gid int) error
 	String() string
+	//check path rules of the user, access is one of utils.AccessRead, AccessWrite, AccessList
+	Allowed(name, access string) bool
 }
 
 type UserModel struct {
@@ -80,18 +83,8 @@ func (fb *FileBrowser) Setup() (bool, error) {
 		fb.Config.SetKey(bytes)
 	}
 	users := fb.Config.GetUsers()
-	for _, u := range users {
-		if u.FirstRun {
-			u.FirstRun = false
-			needUpdate = true
-			u.Password, err = HashPassword(u.Password)
-			if err != nil {
-				log.Println(err)
-			}
-		}
-	}
-
-	if needUpdate {
+	if HashFirstRun(fb.Config, users) {
+		needUpdate = true
 		fb.Config.Users = users
 		fb.Config.RefreshUserRam()
 	}
@@ -114,26 +107,30 @@ func (fb *FileBrowser) Setup() (bool, error) {
 	return needUpdate, nil
 }
 
+//hash plain passwords of users marked as first run, true in case any password was hashed
+func HashFirstRun(cfg *config.GlobalConfig, users []*config.UserConfig) (res bool) {
+	var err error
+	for _, u := range users {
+		if u.FirstRun {
+			u.FirstRun = false
+			res = true
+			u.Password, err = HashPassword(cfg, u.Password)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	return res
+}
+
 func ToUserModel(u *config.UserConfig, cfg *config.GlobalConfig) *UserModel {
 	return &UserModel{u, u.Username,
-		utils.Dir(cfg.GetUserHomePath(u.Username)),
+		utils.RulesDir{Dir: utils.Dir(cfg.GetUserHomePath(u.Username)), Rules: u.Rules},
 		utils.Dir(cfg.GetUserPreviewPath(u.Username)),
 		utils.Dir(cfg.GetUserSharesPath(u.Username)),
 	}
 }
 
-// HashPassword generates an hash from a password using bcrypt.
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
-}
-
-// CheckPasswordHash compares a password with an hash to check if they match.
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
-
 // GenerateRandomBytes returns securely generated random bytes.
 // It will return an fm.Error if the system's secure random
 // number generator fails to function correctly, in which
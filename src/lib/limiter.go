@@ -0,0 +1,198 @@
+package lib
+
+import (
+	"github.com/browsefile/backend/src/config"
+	"github.com/browsefile/backend/src/lib/metrics"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//kinds of limited keys
+const (
+	LockIP   = "ip"
+	LockUser = "user"
+)
+
+//stale entries removed, once there are more failures tracked
+const limiterPrune = 1024
+
+//failed logins by key
+type loginFailures struct {
+	failures int
+	//attempts reserved and not verified yet
+	pending int
+	last    time.Time
+	//no attempts before
+	until  time.Time
+	locked bool
+}
+
+var loginLimiter = struct {
+	sync.Mutex
+	keys map[string]*loginFailures
+}{keys: make(map[string]*loginFailures)}
+
+//active lockout, returned to admin
+type Lockout struct {
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+func limiterKeys(ip, username string) []string {
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	res := []string{LockIP + ":" + ip}
+	if len(username) > 0 {
+		res = append(res, LockUser+":"+strings.ToLower(username))
+	}
+	return res
+}
+
+/*
+reserve login attempt from ip for username, returns time to wait instead, in case not allowed yet.
+reserved attempts counted with failures against attempts limit, so parallel attempts can't pass before the first failure recorded,
+but delay starts only after failure. After verification attempt must end with LoginFailed, LoginSucceeded or LoginRelease
+*/
+func LoginAttempt(cfg *config.GlobalConfig, ip, username string) (res time.Duration) {
+	conf := cfg.GetLockoutConf()
+	forget := time.Duration(conf.Duration) * time.Second
+	now := time.Now()
+	loginLimiter.Lock()
+	defer loginLimiter.Unlock()
+	keys := limiterKeys(ip, username)
+	for _, k := range keys {
+		f, ok := loginLimiter.keys[k]
+		if !ok {
+			continue
+		}
+		if d := f.until.Sub(now); d > res {
+			res = d
+		}
+		//limit taken by attempts in progress, next one allowed once any of them ends
+		if f.failures+f.pending >= conf.Attempts && f.pending > 0 && res <= 0 {
+			res = time.Duration(conf.Backoff) * time.Second
+		}
+	}
+	if res > 0 {
+		return
+	}
+	for _, k := range keys {
+		f, ok := loginLimiter.keys[k]
+		if !ok || f.pending == 0 && now.Sub(f.last) > forget {
+			f = &loginFailures{}
+			loginLimiter.keys[k] = f
+		}
+		f.pending++
+		f.last = now
+	}
+	if len(loginLimiter.keys) > limiterPrune {
+		for k, f := range loginLimiter.keys {
+			if f.pending == 0 && now.Sub(f.last) > forget && now.After(f.until) {
+				delete(loginLimiter.keys, k)
+			}
+		}
+	}
+	return
+}
+
+//reserved attempt failed, next attempt delayed, ip or username locked out once attempts reached
+func LoginFailed(cfg *config.GlobalConfig, ip, username string) {
+	conf := cfg.GetLockoutConf()
+	now := time.Now()
+	loginLimiter.Lock()
+	defer loginLimiter.Unlock()
+	for _, k := range limiterKeys(ip, username) {
+		f, ok := loginLimiter.keys[k]
+		if !ok {
+			//cleared while attempt was verified
+			f = &loginFailures{}
+			loginLimiter.keys[k] = f
+		}
+		if f.pending > 0 {
+			f.pending--
+		}
+		f.failures++
+		f.last = now
+		f.until = now.Add(conf.Delay(f.failures))
+		if !f.locked && f.failures >= conf.Attempts {
+			f.locked = true
+			kind := k[:strings.IndexByte(k, ':')]
+			metrics.AuthLockouts.Inc(kind)
+			log.Printf("auth : %s locked out until %s after %d failed logins\n", k, f.until.Format(time.RFC3339), f.failures)
+		}
+	}
+}
+
+//reserved attempt succeeded, failures of username forgotten, failures of ip decay with time
+func LoginSucceeded(ip, username string) {
+	keys := limiterKeys(ip, username)
+	loginLimiter.Lock()
+	if len(keys) > 1 {
+		delete(loginLimiter.keys, keys[1])
+	}
+	loginLimiter.Unlock()
+	LoginRelease(ip, "")
+}
+
+//reserved attempt neither failed nor succeeded, like login waiting for second factor
+func LoginRelease(ip, username string) {
+	loginLimiter.Lock()
+	defer loginLimiter.Unlock()
+	for _, k := range limiterKeys(ip, username) {
+		f, ok := loginLimiter.keys[k]
+		if !ok {
+			continue
+		}
+		if f.pending > 0 {
+			f.pending--
+		}
+		if f.pending == 0 && f.failures == 0 {
+			delete(loginLimiter.keys, k)
+		}
+	}
+}
+
+//ip addresses and usernames locked out right now
+func Lockouts() (res []*Lockout) {
+	now := time.Now()
+	loginLimiter.Lock()
+	for k, f := range loginLimiter.keys {
+		if f.locked && now.Before(f.until) {
+			i := strings.IndexByte(k, ':')
+			res = append(res, &Lockout{Kind: k[:i], Name: k[i+1:], Failures: f.failures, Until: f.until})
+		}
+	}
+	loginLimiter.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Kind != res[j].Kind {
+			return res[i].Kind < res[j].Kind
+		}
+		return res[i].Name < res[j].Name
+	})
+	return
+}
+
+//clear failures of ip or username, empty kind clears everything. False in case nothing tracked
+func ClearLockout(kind, name string) bool {
+	loginLimiter.Lock()
+	defer loginLimiter.Unlock()
+	if len(kind) == 0 {
+		loginLimiter.keys = make(map[string]*loginFailures)
+		return true
+	}
+	k := kind + ":" + name
+	if kind == LockUser {
+		k = strings.ToLower(k)
+	}
+	_, ok := loginLimiter.keys[k]
+	delete(loginLimiter.keys, k)
+	return ok
+}
+
@@ -0,0 +1,40 @@
+package metrics
+
+//metrics of file browser
+var (
+	Requests = NewCounterVec("browsefile_http_requests_total",
+		"Count of http requests by router and status code.", "router", "code")
+	Latency = NewHistogramVec("browsefile_http_request_duration_seconds",
+		"Latency of http requests by router.", DefBuckets, "router")
+	UploadedBytes = NewCounterVec("browsefile_uploaded_bytes_total",
+		"Bytes received as file uploads, api and webdav.")
+	DownloadedBytes = NewCounterVec("browsefile_downloaded_bytes_total",
+		"Bytes sent as file downloads, api and webdav.")
+	ZipArchives = NewCounterVec("browsefile_zip_archives_total",
+		"Count of served zip archives.")
+	PreviewFailures = NewCounterVec("browsefile_preview_failures_total",
+		"Count of failed preview generations.")
+	DavRequests = NewCounterVec("browsefile_webdav_requests_total",
+		"Count of webdav requests by method.", "method")
+	AuthFailures = NewCounterVec("browsefile_auth_failures_total",
+		"Count of failed authentications by method.", "method")
+	AuthLockouts = NewCounterVec("browsefile_auth_lockouts_total",
+		"Count of login lockouts by kind, ip or user.", "kind")
+)
+
+//source of preview queue depth, set by running server
+var previewQueue func() int
+
+func init() {
+	NewGaugeFunc("browsefile_preview_queue_depth", "Count of preview jobs waiting in the queue.", func() float64 {
+		if previewQueue == nil {
+			return 0
+		}
+		return float64(previewQueue())
+	})
+}
+
+//set source of preview queue depth
+func SetPreviewQueue(fn func() int) {
+	previewQueue = fn
+}
@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//metric, that can write itself in prometheus text format
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	regLock  sync.Mutex
+	registry []collector
+)
+
+func register(c collector) {
+	regLock.Lock()
+	registry = append(registry, c)
+	regLock.Unlock()
+}
+
+//write all registered metrics in prometheus text exposition format
+func WriteText(out io.Writer) error {
+	w := bufio.NewWriter(out)
+	regLock.Lock()
+	all := make([]collector, len(registry))
+	copy(all, registry)
+	regLock.Unlock()
+	for _, c := range all {
+		c.write(w)
+	}
+	return w.Flush()
+}
+
+//counters with same name, separated by label values
+type CounterVec struct {
+	name, help string
+	labels     []string
+	lock       sync.Mutex
+	values     map[string]float64
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	k := strings.Join(labelValues, "\xff")
+	c.lock.Lock()
+	c.values[k] += v
+	c.lock.Unlock()
+}
+
+//value for specific labels, uses by tests
+func (c *CounterVec) Get(labelValues ...string) float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.values[strings.Join(labelValues, "\xff")]
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, k := range sortedKeys(c.values) {
+		writeSample(w, c.name, labelPairs(c.labels, k), c.values[k])
+	}
+}
+
+//value evaluated on each scrape
+type GaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	writeSample(w, g.name, "", g.fn())
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+//observations distributed by buckets, separated by label values
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	lock       sync.Mutex
+	values     map[string]*histogram
+}
+
+//request latency buckets in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	k := strings.Join(labelValues, "\xff")
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	hv, ok := h.values[k]
+	if !ok {
+		hv = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hv
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hv.counts[i]++
+		}
+	}
+	hv.sum += v
+	hv.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hv := h.values[k]
+		lp := labelPairs(h.labels, k)
+		for i, b := range h.buckets {
+			writeSample(w, h.name+"_bucket", joinLabels(lp, `le="`+formatFloat(b)+`"`), float64(hv.counts[i]))
+		}
+		writeSample(w, h.name+"_bucket", joinLabels(lp, `le="+Inf"`), float64(hv.count))
+		writeSample(w, h.name+"_sum", lp, hv.sum)
+		writeSample(w, h.name+"_count", lp, float64(hv.count))
+	}
+}
+
+func writeHeader(w *bufio.Writer, name, help, t string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, t)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	_, _ = w.WriteString(name)
+	if len(labels) > 0 {
+		_, _ = w.WriteString("{" + labels + "}")
+	}
+	_, _ = w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+//label="value" pairs from names and joined values
+func labelPairs(names []string, key string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	vals := strings.Split(key, "\xff")
+	res := make([]string, len(names))
+	for i, n := range names {
+		v := ""
+		if i < len(vals) {
+			v = vals[i]
+		}
+		res[i] = n + `="` + escape(v) + `"`
+	}
+	return strings.Join(res, ",")
+}
+
+func joinLabels(a, b string) string {
+	if len(a) == 0 {
+		return b
+	}
+	return a + "," + b
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	c := NewCounterVec("test_total", "Test counter.", "method")
+	c.Inc("GET")
+	c.Add(2, `a"b`)
+	h := NewHistogramVec("test_seconds", "Test histogram.", []float64{1, 2}, "router")
+	h.Observe(1.5, "users")
+	NewGaugeFunc("test_gauge", "Test gauge.", func() float64 { return 7 })
+
+	out := new(bytes.Buffer)
+	if err := WriteText(out); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"# TYPE test_total counter",
+		`test_total{method="GET"} 1`,
+		`test_total{method="a\"b"} 2`,
+		`test_seconds_bucket{router="users",le="1"} 0`,
+		`test_seconds_bucket{router="users",le="2"} 1`,
+		`test_seconds_bucket{router="users",le="+Inf"} 1`,
+		`test_seconds_sum{router="users"} 1.5`,
+		"test_gauge 7",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatal("missed", s, out.String())
+		}
+	}
+}
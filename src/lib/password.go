@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/browsefile/backend/src/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+const (
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+var errBadHash = errors.New("unknown password hash format")
+
+//argon2id hash parameters, stored in hash string
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// HashPassword generates an hash from a password, with algorithm and cost from config.
+func HashPassword(cfg *config.GlobalConfig, password string) (string, error) {
+	p := cfg.GetPasswordConf()
+	if p.Algo == config.AlgoArgon2id {
+		salt, err := GenerateRandomBytes(argon2SaltLen)
+		if err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), p.Cost)
+	return string(bytes), err
+}
+
+// CheckPasswordHash compares a password with an hash to check if they match, algorithm taken from the hash.
+func CheckPasswordHash(password, hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		a, err := parseArgon2(hash)
+		if err != nil {
+			return false
+		}
+		key := argon2.IDKey([]byte(password), a.salt, a.time, a.memory, a.threads, uint32(len(a.key)))
+		return subtle.ConstantTimeCompare(key, a.key) == 1
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+//true in case hash made with other algorithm or parameters than configured
+func NeedsRehash(cfg *config.GlobalConfig, hash string) bool {
+	p := cfg.GetPasswordConf()
+	if p.Algo == config.AlgoArgon2id {
+		a, err := parseArgon2(hash)
+		return err != nil || a.time != p.Time || a.memory != p.Memory || a.threads != p.Threads
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != p.Cost
+}
+
+// $argon2id$v=19$m=65536,t=1,p=4$salt$key
+func parseArgon2(hash string) (res *argon2Params, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, errBadHash
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errBadHash
+	}
+	res = new(argon2Params)
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &res.memory, &res.time, &res.threads); err != nil {
+		return nil, errBadHash
+	}
+	if res.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errBadHash
+	}
+	if res.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(res.key) == 0 {
+		return nil, errBadHash
+	}
+	return res, nil
+}
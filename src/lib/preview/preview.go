@@ -1,20 +1,39 @@
 package preview
 
 import (
+	"context"
+	"errors"
 	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/lib/metrics"
 	"github.com/browsefile/backend/src/lib/utils"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+var errStopped = errors.New("preview: generator stopped")
+
 // should be 1 global object
 type PreviewGen struct {
 	ch           chan *PreviewData
 	threadsCount int
 	scriptPath   string
+	//closed by Stop, tells workers to drain the queue and path walkers to exit
+	quit     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+	//closed once Stop deadline passed, workers exit without taking queued jobs
+	abort     chan struct{}
+	abortOnce sync.Once
+	//each value stops 1 worker, used on resize
+	shrink chan struct{}
+	lock   sync.RWMutex
+	//running workers
+	workers int32
 }
 
 func genPrew(pd *PreviewData) {
@@ -28,6 +47,7 @@ func genPrew(pd *PreviewData) {
 		err := cmd.Run()
 
 		if err != nil {
+			metrics.PreviewFailures.Inc()
 			log.Println(err)
 		}
 	}
@@ -38,31 +58,146 @@ func (p *PreviewGen) Setup(t int, scr string) {
 	p.scriptPath = scr
 	if p.threadsCount <= 0 {
 		p.threadsCount = 1
-	} else {
-		//todo make async
-		p.ch = make(chan *PreviewData, 10000)
-		for ; p.threadsCount > 0; p.threadsCount-- {
-			go func() {
-			Begin:
-				scp := <-p.ch
-				_, t := utils.GetBasedOnExtensions(scp.in)
-				genPrew(p.GetDefaultData(scp.in, scp.out, t))
-				//p.ProcessPath(filepath.Dir(scp.in), filepath.Dir(scp.out))
-				goto Begin
-			}()
+	}
+	p.quit = make(chan struct{})
+	p.abort = make(chan struct{})
+	p.shrink = make(chan struct{})
+	p.ch = make(chan *PreviewData, 10000)
+	for i := 0; i < p.threadsCount; i++ {
+		p.wg.Add(1)
+		atomic.AddInt32(&p.workers, 1)
+		go p.work()
+	}
+}
+
+//apply new threads count and script path to the running generator, queued jobs are kept
+func (p *PreviewGen) Reconfigure(t int, scr string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	//new workers must not be added, while Stop waits for them
+	if p.isStopped() {
+		return
+	}
+	p.scriptPath = scr
+	if t <= 0 {
+		t = 1
+	}
+	for ; p.threadsCount < t; p.threadsCount++ {
+		p.wg.Add(1)
+		atomic.AddInt32(&p.workers, 1)
+		go p.work()
+	}
+	for ; p.threadsCount > t; p.threadsCount-- {
+		//busy worker will pick it after current job
+		go func() {
+			select {
+			case <-p.quit:
+			case p.shrink <- struct{}{}:
+			}
+		}()
+	}
+}
+
+//takes preview jobs from the queue until Stop called, then drains the queue. Current job always finished
+func (p *PreviewGen) work() {
+	defer atomic.AddInt32(&p.workers, -1)
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.quit:
+			p.drain()
+			return
+		case <-p.shrink:
+			return
+		case scp := <-p.ch:
+			p.gen(scp)
+		}
+	}
+}
+
+//generate queued previews until queue empty, or Stop deadline passed
+func (p *PreviewGen) drain() {
+	for {
+		select {
+		case <-p.abort:
+			return
+		default:
+		}
+		select {
+		case scp := <-p.ch:
+			p.gen(scp)
+		default:
+			return
 		}
 	}
 }
+
+func (p *PreviewGen) gen(scp *PreviewData) {
+	_, t := utils.GetBasedOnExtensions(scp.in)
+	genPrew(p.GetDefaultData(scp.in, scp.out, t))
+}
+
+/*
+stop accepting new jobs, and wait until queued and running ones finished, or ctx done.
+After ctx done, jobs left in the queue are dropped, running ones still finish in background
+*/
+func (p *PreviewGen) Stop(ctx context.Context) error {
+	//under lock, so Reconfigure never adds workers after Stop
+	p.lock.Lock()
+	p.stopOnce.Do(func() {
+		close(p.quit)
+	})
+	p.lock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.abortOnce.Do(func() {
+			close(p.abort)
+		})
+		return ctx.Err()
+	}
+}
+
+//jobs waiting for the worker
+func (p *PreviewGen) QueueDepth() int {
+	return len(p.ch)
+}
+
+//true in case generator not stopped, and at least 1 worker running
+func (p *PreviewGen) Alive() bool {
+	return !p.isStopped() && atomic.LoadInt32(&p.workers) > 0
+}
+
+func (p *PreviewGen) isStopped() bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
 func (p *PreviewGen) Process(pc *PreviewData) {
 	if len(pc.in) == 0 || len(pc.out) == 0 {
 		log.Printf("Error, in(%v) or out(%v) paths are empty ", pc.in, pc.out)
 	} else if _, err := os.Stat(pc.out); err != nil {
-		p.ch <- pc
+		select {
+		case <-p.quit:
+		case p.ch <- pc:
+		}
 	}
 }
-func (pd PreviewGen) GetDefaultData(in, out, t string) (rs *PreviewData) {
+func (pd *PreviewGen) GetDefaultData(in, out, t string) (rs *PreviewData) {
 	rs = new(PreviewData)
+	pd.lock.RLock()
 	rs.Setup(pd.scriptPath)
+	pd.lock.RUnlock()
 	if len(in) > 0 && len(out) > 0 && len(t) > 0 {
 		rs.SetPaths(in, out, t)
 	}
@@ -77,6 +212,9 @@ func (p *PreviewGen) ProcessPath(scope string, previewScope string) {
 			if err != nil {
 				return err
 			}
+			if p.isStopped() {
+				return errStopped
+			}
 
 			ok, t := utils.GetBasedOnExtensions(path)
 			if ok && (strings.EqualFold(cnst.IMAGE, t) || strings.EqualFold(cnst.VIDEO, t)) {
@@ -87,7 +225,7 @@ func (p *PreviewGen) ProcessPath(scope string, previewScope string) {
 
 			return nil
 		})
-	if err != nil {
+	if err != nil && err != errStopped {
 		log.Println(err)
 	}
 
@@ -1,5 +1,16 @@
 package preview
 
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
 const inI, resI string = "imgIn.jpg", "../../resI.jpg"
 const inV, resV string = "vidIn.mp4", "../../vidIn.gif"
 
@@ -41,3 +52,94 @@ func TestVideoThumbGen(t *testing.T) {
 	os.Remove(resV)
 }*/
 
+func TestStop(t *testing.T) {
+	p := new(PreviewGen)
+	p.Setup(2, "")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Stop(ctx); err != nil {
+		t.Fatal("workers not stopped", err)
+	}
+	//must not block after stop
+	p.Process(p.GetDefaultData("/in.jpg", "/not/exists/out.jpg", "image"))
+	p.ProcessPath("/", "/tmp")
+	if err := p.Stop(ctx); err != nil {
+		t.Fatal("second stop should not fail", err)
+	}
+}
+
+func TestStopDrain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preview")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	scr := filepath.Join(dir, "convert.sh")
+	if err = ioutil.WriteFile(scr, []byte("sleep 0.05\ntouch \"$2\"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	p := new(PreviewGen)
+	p.Setup(1, scr)
+	var outs []string
+	for i := 0; i < 5; i++ {
+		out := filepath.Join(dir, strconv.Itoa(i)+".jpg")
+		outs = append(outs, out)
+		p.Process(p.GetDefaultData(filepath.Join(dir, "in.jpg"), out, "image"))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = p.Stop(ctx); err != nil {
+		t.Fatal("workers not stopped", err)
+	}
+	for _, out := range outs {
+		if _, err = os.Stat(out); err != nil {
+			t.Error("queued job dropped on stop", out)
+		}
+	}
+}
+
+func TestStopDeadline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preview")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	scr := filepath.Join(dir, "convert.sh")
+	if err = ioutil.WriteFile(scr, []byte("sleep 0.3\ntouch \"$2\"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	p := new(PreviewGen)
+	p.Setup(1, scr)
+	for i := 0; i < 5; i++ {
+		p.Process(p.GetDefaultData(filepath.Join(dir, "in.jpg"), filepath.Join(dir, strconv.Itoa(i)+".jpg"), "image"))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = p.Stop(ctx); err != context.DeadlineExceeded {
+		t.Fatal("stop must end on deadline", err)
+	}
+	//running job finished, queued ones dropped
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = p.Stop(ctx); err != nil {
+		t.Fatal("running job not finished", err)
+	}
+	if atomic.LoadInt32(&p.workers) != 0 {
+		t.Error("worker still running after stop")
+	}
+	if m, _ := filepath.Glob(filepath.Join(dir, "*.jpg")); len(m) == 5 {
+		t.Error("queued jobs must be dropped after deadline")
+	}
+}
+
+func TestReconfigureAfterStop(t *testing.T) {
+	p := new(PreviewGen)
+	p.Setup(1, "")
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	p.Reconfigure(4, "")
+	if n := atomic.LoadInt32(&p.workers); n != 0 {
+		t.Fatal("workers started after stop", n)
+	}
+}
@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//bytes used by users homes by home path, home walked once and kept up to date by writes after
+var usedSpace = struct {
+	sync.Mutex
+	bytes map[string]int64
+}{bytes: make(map[string]int64)}
+
+//bytes used by files in user home
+func UsedSpace(cfg *config.GlobalConfig, username string) int64 {
+	home := cfg.GetUserHomePath(username)
+	usedSpace.Lock()
+	n, ok := usedSpace.bytes[home]
+	usedSpace.Unlock()
+	if ok {
+		return n
+	}
+	n = DiskUsage(home)
+	usedSpace.Lock()
+	defer usedSpace.Unlock()
+	if cur, ok := usedSpace.bytes[home]; ok {
+		return cur
+	}
+	usedSpace.bytes[home] = n
+	return n
+}
+
+//account written or removed bytes, ignored until usage walked once
+func AddUsedSpace(cfg *config.GlobalConfig, username string, delta int64) {
+	home := cfg.GetUserHomePath(username)
+	usedSpace.Lock()
+	defer usedSpace.Unlock()
+	if n, ok := usedSpace.bytes[home]; ok {
+		n += delta
+		if n < 0 {
+			n = 0
+		}
+		usedSpace.bytes[home] = n
+	}
+}
+
+//forget usage, next call walks user home again
+func ResetUsedSpace(cfg *config.GlobalConfig, username string) {
+	usedSpace.Lock()
+	defer usedSpace.Unlock()
+	delete(usedSpace.bytes, cfg.GetUserHomePath(username))
+}
+
+//check that size more bytes fits into user quota, returns cnst.ErrQuotaExceeded otherwise
+func CheckQuota(cfg *config.GlobalConfig, u *config.UserConfig, size int64) error {
+	limit := cfg.GetUserQuota(u)
+	if limit <= 0 || size <= 0 {
+		return nil
+	}
+	if UsedSpace(cfg, u.Username)+size > limit {
+		return cnst.ErrQuotaExceeded
+	}
+	return nil
+}
+
+/*
+reserve size more bytes of user quota, cnst.ErrQuotaExceeded in case they don't fit.
+reserved bytes counted as used right away, so parallel writes can't pass the check together.
+Released by AddUsedSpace with negative size, negative size frees space
+*/
+func ReserveQuota(cfg *config.GlobalConfig, u *config.UserConfig, size int64) error {
+	limit := cfg.GetUserQuota(u)
+	if limit <= 0 || size <= 0 {
+		AddUsedSpace(cfg, u.Username, size)
+		return nil
+	}
+	used := UsedSpace(cfg, u.Username)
+	home := cfg.GetUserHomePath(u.Username)
+	usedSpace.Lock()
+	defer usedSpace.Unlock()
+	if n, ok := usedSpace.bytes[home]; ok {
+		used = n
+	}
+	if used+size > limit {
+		return cnst.ErrQuotaExceeded
+	}
+	usedSpace.bytes[home] = used + size
+	return nil
+}
+
+//writer, that reserves quota of the user for bytes beyond already reserved
+type QuotaWriter struct {
+	io.Writer
+	Cfg  *config.GlobalConfig
+	User *config.UserConfig
+	//bytes reserved, and written so far
+	Reserved, Written int64
+}
+
+func (w *QuotaWriter) Write(p []byte) (int, error) {
+	if extra := w.Written + int64(len(p)) - w.Reserved; extra > 0 {
+		if err := ReserveQuota(w.Cfg, w.User, extra); err != nil {
+			return 0, err
+		}
+		w.Reserved += extra
+	}
+	n, err := w.Writer.Write(p)
+	w.Written += int64(n)
+	return n, err
+}
+
+//size of regular files at path, symlinks not followed
+func DiskUsage(p string) (res int64) {
+	_ = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			res += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+//user quota state for users API
+type QuotaInfo struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit"`
+}
+
+func GetQuotaInfo(cfg *config.GlobalConfig, u *config.UserConfig) *QuotaInfo {
+	return &QuotaInfo{Used: UsedSpace(cfg, u.Username), Limit: cfg.GetUserQuota(u)}
+}
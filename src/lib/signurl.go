@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+//signature over api path with files list, external share hash, user, method and expiration, so it opens single resource only
+func signURL(cfg *config.GlobalConfig, username, method, p, files, rootHash string, exp int64) (string, error) {
+	k, err := cfg.GetKeyBytes()
+	if err != nil {
+		return "", err
+	}
+	//own key for urls, derived from auth key
+	d := hmac.New(sha256.New, k)
+	d.Write([]byte("browsefile signed url"))
+	mac := hmac.New(sha256.New, d.Sum(nil))
+	for _, s := range []string{username, method, strconv.FormatInt(exp, 10), p, files, rootHash} {
+		mac.Write([]byte(s))
+		mac.Write([]byte{0})
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+/*
+query params of signed url to api path p, like /download/photos/a.jpg, valid for ttl.
+files is comma separated files param of the same url, rootHash is hash of external share, both empty in case not used
+*/
+func SignedQuery(cfg *config.GlobalConfig, username, p, files, rootHash string, ttl time.Duration) (url.Values, error) {
+	exp := time.Now().Add(ttl).Unix()
+	sig, err := signURL(cfg, username, http.MethodGet, p, files, rootHash, exp)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	if len(files) > 0 {
+		q.Set("files", files)
+	}
+	if len(rootHash) > 0 {
+		q.Set(cnst.P_ROOTHASH, rootHash)
+	}
+	q.Set(cnst.P_SIG_USER, username)
+	q.Set(cnst.P_SIG_METHOD, http.MethodGet)
+	q.Set(cnst.P_SIG_EXP, strconv.FormatInt(exp, 10))
+	q.Set(cnst.P_SIG, sig)
+	return q, nil
+}
+
+//username of valid signed request to api path p, false in case signature wrong, expired, or issued for other resource
+func CheckSignedURL(cfg *config.GlobalConfig, r *http.Request, p string) (string, bool) {
+	q := r.URL.Query()
+	exp, err := strconv.ParseInt(q.Get(cnst.P_SIG_EXP), 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return "", false
+	}
+	method := q.Get(cnst.P_SIG_METHOD)
+	if method != r.Method && !(method == http.MethodGet && r.Method == http.MethodHead) {
+		return "", false
+	}
+	username := q.Get(cnst.P_SIG_USER)
+	sig, err := signURL(cfg, username, method, p, q.Get("files"), q.Get(cnst.P_ROOTHASH), exp)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(q.Get(cnst.P_SIG))) {
+		return "", false
+	}
+	return username, true
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//rfc 6238 defaults, supported by every authenticator app
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	//accepted clock drift in periods
+	totpSkew = 1
+
+	recoveryCodes = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//random base32 secret of 160 bits
+func GenerateTOTPSecret() (string, error) {
+	b, err := GenerateRandomBytes(20)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+//otpauth uri for qr code of authenticator app
+func TOTPURI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + q.Encode()
+}
+
+//code of the time step
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	//dynamic truncation
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, v%1000000), nil
+}
+
+//time step of the moment
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+//step matching the code within allowed clock drift, false in case code wrong
+func CheckTOTP(secret, code string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	now := TOTPStep(t)
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if c, err := TOTPCode(secret, step); err == nil && hmac.Equal([]byte(c), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+//one-time recovery codes like abcde-fghij, and their hashes to store
+func GenerateRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodes; i++ {
+		b, err := GenerateRandomBytes(7)
+		if err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		c := s[:5] + "-" + s[5:]
+		codes = append(codes, c)
+		hashes = append(hashes, HashToken(c))
+	}
+	return
+}
+
+//random password for dav client, shown to the user once
+func GenerateAppPassword() (string, error) {
+	b, err := GenerateRandomBytes(15)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(totpEncoding.EncodeToString(b)), nil
+}
+
+//hash of random token, like recovery code or app password. Tokens has enough entropy, so no salt and cost needed
+func HashToken(token string) string {
+	token = strings.ToLower(strings.TrimSpace(token))
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
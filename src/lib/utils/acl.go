@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//kinds of access, path rules applied to
+const (
+	//open file content, download
+	AccessRead = "read"
+	//create, modify, rename or remove
+	AccessWrite = "write"
+	//see entry in listings and search
+	AccessList = "list"
+)
+
+/*
+path rule inside user's scope, path is glob like /archive or /photos/*.raw, or regex in case Regex set.
+rule matches the path itself and anything below it, so /private hides whole folder.
+allow and deny contain kinds of access: read, write, list
+*/
+type Rule struct {
+	Path  string   `json:"path"`
+	Regex bool     `json:"regex,omitempty"`
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+//ordered rules, first matching rule that mentions access decides, access allowed by default
+type Rules []*Rule
+
+//compiled regex rules by pattern, since rules checked for every listed entry
+var ruleRegex sync.Map
+
+//check access to path p, relative to user's scope
+func (r Rules) Allowed(p, access string) bool {
+	if len(r) == 0 {
+		return true
+	}
+	p = SlashClean(p)
+	for _, rule := range r {
+		if rule == nil {
+			continue
+		}
+		allow, deny := hasAccess(rule.Allow, access), hasAccess(rule.Deny, access)
+		if !allow && !deny || !rule.Match(p) {
+			continue
+		}
+		//deny wins inside the same rule
+		return !deny
+	}
+	return true
+}
+
+//true in case rule matches path or any of its parents
+func (rule *Rule) Match(p string) bool {
+	p = SlashClean(p)
+	for {
+		if rule.matchOne(p) {
+			return true
+		}
+		if p == "/" {
+			return false
+		}
+		p = path.Dir(p)
+	}
+}
+
+func (rule *Rule) matchOne(p string) bool {
+	if rule.Regex {
+		re, err := rule.regex()
+		return err == nil && re.MatchString(p)
+	}
+	ok, _ := path.Match(SlashClean(rule.Path), p)
+	return ok
+}
+
+func (rule *Rule) regex() (*regexp.Regexp, error) {
+	if re, ok := ruleRegex.Load(rule.Path); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(rule.Path)
+	if err != nil {
+		return nil, err
+	}
+	ruleRegex.Store(rule.Path, re)
+	return re, nil
+}
+
+//check rule patterns and access kinds
+func (r Rules) Validate() error {
+	for i, rule := range r {
+		if rule == nil || len(rule.Path) == 0 {
+			return errors.New("rule " + strconv.Itoa(i) + ": empty path")
+		}
+		if rule.Regex {
+			if _, err := rule.regex(); err != nil {
+				return errors.New("rule " + strconv.Itoa(i) + ": " + err.Error())
+			}
+		} else if _, err := path.Match(rule.Path, "/"); err != nil {
+			return errors.New("rule " + strconv.Itoa(i) + ": bad glob " + rule.Path)
+		}
+		if len(rule.Allow) == 0 && len(rule.Deny) == 0 {
+			return errors.New("rule " + strconv.Itoa(i) + ": no access to allow or deny")
+		}
+		for _, a := range append(append([]string{}, rule.Allow...), rule.Deny...) {
+			if a != AccessRead && a != AccessWrite && a != AccessList {
+				return errors.New("rule " + strconv.Itoa(i) + ": unknown access " + a)
+			}
+		}
+	}
+	return nil
+}
+
+func (r Rules) Copy() Rules {
+	if r == nil {
+		return nil
+	}
+	res := make(Rules, 0, len(r))
+	for _, rule := range r {
+		if rule == nil {
+			continue
+		}
+		c := *rule
+		c.Allow = append([]string{}, rule.Allow...)
+		c.Deny = append([]string{}, rule.Deny...)
+		res = append(res, &c)
+	}
+	return res
+}
+
+//same rules in the same order
+func (r Rules) Equal(o Rules) bool {
+	if len(r) != len(o) {
+		return false
+	}
+	for i, rule := range r {
+		n := o[i]
+		if rule == nil || n == nil {
+			if rule != n {
+				return false
+			}
+			continue
+		}
+		if rule.Path != n.Path || rule.Regex != n.Regex ||
+			strings.Join(rule.Allow, ",") != strings.Join(n.Allow, ",") || strings.Join(rule.Deny, ",") != strings.Join(n.Deny, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+func hasAccess(list []string, access string) bool {
+	for _, a := range list {
+		if strings.EqualFold(a, access) {
+			return true
+		}
+	}
+	return false
+}
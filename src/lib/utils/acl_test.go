@@ -0,0 +1,47 @@
+package utils
+
+import "testing"
+
+func TestRules(t *testing.T) {
+	rules := Rules{
+		{Path: "/archive/public", Allow: []string{AccessWrite}},
+		{Path: "/archive", Deny: []string{AccessWrite}},
+		{Path: "/*.raw", Deny: []string{AccessList}},
+		{Path: `^/private(/|$)`, Regex: true, Deny: []string{AccessRead, AccessWrite, AccessList}},
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		p, access string
+		res       bool
+	}{
+		{"/archive", AccessWrite, false},
+		{"/archive/2019/a.txt", AccessWrite, false},
+		{"/archive/2019/a.txt", AccessRead, true},
+		{"/archive/public/a.txt", AccessWrite, true},
+		{"/archived", AccessWrite, true},
+		{"/a.raw", AccessList, false},
+		{"/a.raw", AccessRead, true},
+		{"/dir/a.raw", AccessList, true},
+		{"/private/a.txt", AccessRead, false},
+		{"private", AccessList, false},
+		{"/privateer", AccessList, true},
+		{"/", AccessWrite, true},
+	}
+	for _, c := range cases {
+		if rules.Allowed(c.p, c.access) != c.res {
+			t.Error("wrong result for", c.p, c.access)
+		}
+	}
+	for _, bad := range []Rules{
+		{{Path: "(", Regex: true, Deny: []string{AccessRead}}},
+		{{Path: "/[", Deny: []string{AccessRead}}},
+		{{Path: "/a"}},
+		{{Path: "/a", Deny: []string{"exec"}}},
+	} {
+		if bad.Validate() == nil {
+			t.Error("bad rule must fail", bad[0])
+		}
+	}
+}
@@ -123,3 +123,112 @@ func (d Dir) Copy(src, dst string, uid, gid int) error {
 func (d Dir) String() string {
 	return string(d)
 }
+
+// Allowed reports whether access to the name is permitted, plain Dir has no rules.
+func (d Dir) Allowed(name, access string) bool {
+	return true
+}
+
+// RulesDir is a Dir, that checks user's path rules on every operation.
+// Hidden entries are reported as not existing, visible but denied ones as permission error.
+type RulesDir struct {
+	Dir
+	Rules Rules
+}
+
+// Allowed checks path rules for the name.
+func (d RulesDir) Allowed(name, access string) bool {
+	return d.Rules.Allowed(name, access)
+}
+
+// Check reports hidden name as not existing, and denied access as permission error.
+func (d RulesDir) Check(name, access string) error {
+	if d.Rules.Allowed(name, access) {
+		return nil
+	}
+	if !d.Rules.Allowed(name, AccessList) && !d.Rules.Allowed(name, AccessRead) {
+		return os.ErrNotExist
+	}
+	return os.ErrPermission
+}
+
+// CheckTree checks the name and everything below it, so folder with denied entries can't be moved or removed at once
+func (d RulesDir) CheckTree(name, access string) error {
+	if err := d.Check(name, access); err != nil || len(d.Rules) == 0 {
+		return err
+	}
+	root := d.resolve(name)
+	if root == "" {
+		return os.ErrNotExist
+	}
+	base := filepath.Clean(d.Dir.String())
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Check(filepath.ToSlash(strings.TrimPrefix(p, base)), access) != nil {
+			return os.ErrPermission
+		}
+		return nil
+	})
+}
+
+// Mkdir implements os.Mkdir in this directory context.
+func (d RulesDir) Mkdir(name string, perm os.FileMode, uid, gid int) error {
+	if err := d.Check(name, AccessWrite); err != nil {
+		return err
+	}
+	return d.Dir.Mkdir(name, perm, uid, gid)
+}
+
+// OpenFile implements os.OpenFile in this directory context.
+func (d RulesDir) OpenFile(name string, flag int, perm os.FileMode, uid, gid int) (*os.File, error) {
+	access := AccessRead
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		access = AccessWrite
+	} else if info, err := d.Dir.Stat(name); err == nil && info.IsDir() {
+		access = AccessList
+	}
+	if err := d.Check(name, access); err != nil {
+		return nil, err
+	}
+	return d.Dir.OpenFile(name, flag, perm, uid, gid)
+}
+
+// RemoveAll implements os.RemoveAll in this directory context.
+func (d RulesDir) RemoveAll(name string) error {
+	if err := d.CheckTree(name, AccessWrite); err != nil {
+		return err
+	}
+	return d.Dir.RemoveAll(name)
+}
+
+// Rename implements os.Rename in this directory context.
+func (d RulesDir) Rename(oldName, newName string) error {
+	if err := d.CheckTree(oldName, AccessWrite); err != nil {
+		return err
+	}
+	if err := d.Check(newName, AccessWrite); err != nil {
+		return err
+	}
+	return d.Dir.Rename(oldName, newName)
+}
+
+// Stat implements os.Stat in this directory context.
+func (d RulesDir) Stat(name string) (os.FileInfo, error) {
+	if !d.Rules.Allowed(name, AccessList) && !d.Rules.Allowed(name, AccessRead) {
+		return nil, os.ErrNotExist
+	}
+	return d.Dir.Stat(name)
+}
+
+// Copy copies a file or directory from src to dst.
+func (d RulesDir) Copy(src, dst string, uid, gid int) error {
+	if err := d.CheckTree(src, AccessRead); err != nil {
+		return err
+	}
+	if err := d.Check(dst, AccessWrite); err != nil {
+		return err
+	}
+	return d.Dir.Copy(src, dst, uid, gid)
+}
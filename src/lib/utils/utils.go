@@ -40,6 +40,8 @@ func parseRouter(r string) (res int) {
 		res = cnst.R_SEARCH
 	case "playlist":
 		res = cnst.R_PLAYLIST
+	case "groups":
+		res = cnst.R_GROUPS
 
 	default:
 		res = 0
@@ -0,0 +1,78 @@
+package web
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"net/http"
+	"time"
+
+	fb "github.com/browsefile/backend/src/lib"
+)
+
+//personal api tokens starts with, so they never confused with jwt
+const apiTokenPrefix = "bf_"
+
+//authenticate request by personal api token
+func apiTokenAuth(c *fb.Context, token string) (bool, *fb.UserModel) {
+	u, t, ok := c.Config.GetUserByAPIToken(fb.HashToken(token))
+	if !ok || !isActive(u, "api token") {
+		authFailed("apitoken")
+		return false, nil
+	}
+	c.Config.TouchAPIToken(u.Username, t.Name)
+	c.User = fb.ToUserModel(u, c.Config)
+	c.APIToken = t
+	return true, c.User
+}
+
+//router, method and paths of request fit token scopes
+func apiTokenAllowed(c *fb.Context) bool {
+	write := c.Method != http.MethodGet && c.Method != http.MethodHead
+	paths := append([]string{c.URL}, c.FilePaths...)
+	if len(c.Destination) > 0 {
+		paths = append(paths, c.Destination)
+	}
+	return c.APIToken.Allows(routerNames[c.Router], write, paths...)
+}
+
+//manage personal api tokens of current user, token itself shown once on create
+func apiTokensHandler(c *fb.Context, name string) (int, error) {
+	switch c.Method {
+	case http.MethodGet:
+		u, _ := c.Config.GetUserByUsername(c.User.Username)
+		u.HideSecrets()
+		res := u.APITokens
+		if res == nil {
+			res = []*config.APIToken{}
+		}
+		return renderJSON(c.RESP, res)
+	case http.MethodPost:
+		t := &config.APIToken{}
+		if c.REQ.Body == nil || json.NewDecoder(c.REQ.Body).Decode(t) != nil {
+			return http.StatusBadRequest, cnst.ErrEmptyRequest
+		}
+		b, err := fb.GenerateRandomBytes(20)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		token := apiTokenPrefix + hex.EncodeToString(b)
+		t.Hash, t.Created, t.LastUsed = fb.HashToken(token), time.Now(), nil
+		err = c.Config.AddAPIToken(c.User.Username, t)
+		if err == cnst.ErrExist {
+			return http.StatusConflict, err
+		} else if err != nil {
+			return http.StatusBadRequest, err
+		}
+		return renderJSON(c.RESP, map[string]string{"name": t.Name, "token": token})
+	case http.MethodDelete:
+		if err := c.Config.DeleteAPIToken(c.User.Username, name); err == cnst.ErrNotExist {
+			return http.StatusNotFound, err
+		} else if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return http.StatusOK, nil
+	}
+	return http.StatusMethodNotAllowed, nil
+}
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestAPITokens(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	dat := map[string]interface{}{"u": "/", "method": http.MethodGet}
+	_, _, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false)
+	session := cfg.Token
+
+	if c := authRequest(&cfg, http.MethodPost, "/auth/tokens", `{"name":"bad","scopes":["users"]}`, nil); c != http.StatusBadRequest {
+		t.Error("unknown scope must be refused", c)
+	}
+	res := map[string]string{}
+	body := `{"name":"backup","scopes":["download","resource:read"],"path":"` + cfg.SharePathUp + `"}`
+	if c := authRequest(&cfg, http.MethodPost, "/auth/tokens", body, &res); c != http.StatusOK {
+		t.Fatal("token not created", c)
+	}
+	if c := authRequest(&cfg, http.MethodPost, "/auth/tokens", body, nil); c != http.StatusConflict {
+		t.Error("token names must be unique", c)
+	}
+
+	cfg.Token = res["token"]
+	for _, tc := range []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodGet, "/resource" + cfg.SharePathDeep, http.StatusOK},
+		{http.MethodGet, "/download" + cfg.SharePathDeep + "/real.jpg", http.StatusOK},
+		{http.MethodGet, "/resource/", http.StatusForbidden},
+		{http.MethodGet, "/resource/testtest", http.StatusForbidden},
+		{http.MethodDelete, "/resource" + cfg.SharePathDeep + "/real.jpg", http.StatusForbidden},
+		{http.MethodGet, "/search" + cfg.SharePathUp, http.StatusForbidden},
+		{http.MethodGet, "/users/", http.StatusForbidden},
+		{http.MethodGet, "/auth/tokens", http.StatusForbidden},
+		{http.MethodGet, "/auth/renew", http.StatusForbidden},
+	} {
+		if c := authRequest(&cfg, tc.method, tc.path, "", nil); c != tc.code {
+			t.Error(tc.method, tc.path, "wrong status", c)
+		}
+	}
+
+	//listed without hash, last use recorded
+	cfg.Token = session
+	var list []map[string]interface{}
+	if c := authRequest(&cfg, http.MethodGet, "/auth/tokens", "", &list); c != http.StatusOK || len(list) != 1 {
+		t.Fatal("token must be listed", c)
+	}
+	if list[0]["hash"] != "" || list[0]["lastUsed"] == nil {
+		t.Error("hash must be hidden and last use recorded", list[0])
+	}
+	if c := authRequest(&cfg, http.MethodDelete, "/auth/tokens/backup", "", nil); c != http.StatusOK {
+		t.Error("token not deleted", c)
+	}
+	cfg.Token = res["token"]
+	if c := authRequest(&cfg, http.MethodGet, "/resource"+cfg.SharePathUp, "", nil); c != http.StatusForbidden {
+		t.Error("deleted token must be refused", c)
+	}
+}
+
+func TestAPITokenDestination(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	dat := map[string]interface{}{"u": "/", "method": http.MethodGet}
+	_, _, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false)
+	res := map[string]string{}
+	body := `{"name":"sync","scopes":["resource"],"path":"` + cfg.SharePathDeep + `"}`
+	if c := authRequest(&cfg, http.MethodPost, "/auth/tokens", body, &res); c != http.StatusOK {
+		t.Fatal("token not created", c)
+	}
+	move := func(dst string) int {
+		req, _ := http.NewRequest(http.MethodPatch, cfg.Srv.URL+"/api/resource"+cfg.SharePathDeep+"/real.jpg", nil)
+		req.Header.Set(cnst.H_XAUTH, res["token"])
+		req.Header.Set("Destination", dst)
+		req.Header.Set("action", "rename")
+		rs, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rs.Body.Close()
+		return rs.StatusCode
+	}
+	if c := move(cfg.SharePathDeep + "/%2E%2E/out.jpg"); c != http.StatusForbidden {
+		t.Error("escaped .. must not leave token path", c)
+	}
+	if _, err := cfg.User1FS.Stat(cfg.SharePathUp + "/out.jpg"); err == nil {
+		t.Error("file moved out of token path")
+	}
+	if c := move(url.QueryEscape(cfg.SharePathDeep + "/moved.jpg")); c != http.StatusOK {
+		t.Error("escaped destination inside token path refused", c)
+	}
+	if _, err := cfg.User1FS.Stat(cfg.SharePathDeep + "/moved.jpg"); err != nil {
+		t.Error("file not moved", err)
+	}
+}
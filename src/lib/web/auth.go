@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,16 +18,22 @@ import (
 )
 
 var (
-	authKeySession = make(map[string]bool)
+	//checked basic auth headers, with credentials stamp of the user at check time
+	authKeySession = make(map[string]string)
 	authKeyLock    = new(sync.RWMutex)
 )
 
 const reCaptchaAPI = "/recaptcha/api/siteverify"
 
+//lifetime of issued token
+const tokenTTL = time.Hour * 24
+
 type cred struct {
 	Password  string `json:"password"`
 	Username  string `json:"username"`
 	ReCaptcha string `json:"recaptcha"`
+	//totp or recovery code, in case user enrolled two factor
+	OTP string `json:"otp"`
 }
 
 // reCaptcha checks the reCaptcha code.
@@ -61,7 +68,8 @@ func authDavHandler(c *fb.Context, w http.ResponseWriter, r *http.Request) (res
 	cfgM := c.GetAuthConfig()
 	if cfgM.AuthMethod == "ip" {
 		u, res := c.Config.GetUserByIp(r.RemoteAddr)
-		if !res {
+		if !res || !davActive(u, "ip") {
+			authFailed("ip")
 			return false
 		}
 		c.User = fb.ToUserModel(u, c.Config)
@@ -77,26 +85,49 @@ func authDavHandler(c *fb.Context, w http.ResponseWriter, r *http.Request) (res
 		return
 	}
 
-	user, ok := c.Config.GetUserByUsername(username)
-	if !ok {
-		http.Error(w, "Not authorized", http.StatusUnauthorized)
-		return
-	}
 	auth := r.Header.Get("Authorization")
 	authKeyLock.RLock()
-	isAuth := authKeySession[auth]
+	stamp, isAuth := authKeySession[auth]
 	authKeyLock.RUnlock()
+	ip := c.ClientIP()
 	if !isAuth {
-		//very expensive operation, need to minimize hash function call
-		if !fb.CheckPasswordHash(password, user.Password) {
-			log.Println("Wrong Password for user", username)
-			http.Error(w, "Not authorized", http.StatusUnauthorized)
+		if wait := fb.LoginAttempt(c.Config, ip, username); wait > 0 {
+			retryAfter(w, wait)
+			http.Error(w, "Too many failed attempts", http.StatusTooManyRequests)
 			return
 		}
+	}
+
+	user, ok := c.Config.GetUserByUsername(username)
+	if !ok || !davActive(user, "dav") {
+		if !ok && !isAuth {
+			fb.LoginFailed(c.Config, ip, username)
+		} else if !isAuth {
+			fb.LoginRelease(ip, username)
+		}
+		authFailed("dav")
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	//password changed since header was checked
+	isAuth = isAuth && stamp == credStamp(user)
+	if !isAuth {
+		//app passwords are cheap to check, account password not accepted with two factor enabled
+		if !user.HasAppPassword(fb.HashToken(password)) {
+			//very expensive operation, need to minimize hash function call
+			if user.HasTwoFactor() || !fb.CheckPasswordHash(password, user.Password) {
+				log.Println("Wrong Password for user", username)
+				fb.LoginFailed(c.Config, ip, username)
+				authFailed("dav")
+				http.Error(w, "Not authorized", http.StatusUnauthorized)
+				return
+			}
+			rehash(c, user, password)
+		}
+		fb.LoginSucceeded(ip, username)
 
-		auth := r.Header.Get("Authorization")
 		authKeyLock.Lock()
-		authKeySession[auth] = true
+		authKeySession[auth] = credStamp(user)
 		authKeyLock.Unlock()
 	}
 	c.User = fb.ToUserModel(user, c.Config)
@@ -123,9 +154,11 @@ func authHandler(c *fb.Context) (int, error) {
 		}
 
 		// Receive the Username from the Header and check if it exists.
-		if !ok {
+		if !ok || !isActive(uc, cfgM.AuthMethod) {
+			authFailed(cfgM.AuthMethod)
 			return http.StatusForbidden, nil
 		}
+		c.Config.SetLastLogin(uc.Username, c.REQ.RemoteAddr)
 		c.User = fb.ToUserModel(uc, c.Config)
 
 		return printToken(c)
@@ -142,30 +175,69 @@ func authHandler(c *fb.Context) (int, error) {
 	if err != nil {
 		return http.StatusForbidden, err
 	}
+	ip := c.ClientIP()
+	if wait := fb.LoginAttempt(c.Config, ip, cred.Username); wait > 0 {
+		retryAfter(c.RESP, wait)
+		return http.StatusTooManyRequests, nil
+	}
 
 	// If ReCaptcha is enabled, check the code.
 	if len(c.ReCaptcha.Secret) > 0 {
 		ok, err := reCaptcha(c.ReCaptcha.Host, c.ReCaptcha.Secret, cred.ReCaptcha)
 		if err != nil {
+			fb.LoginRelease(ip, cred.Username)
 			return http.StatusForbidden, err
 		}
 		if !ok {
+			fb.LoginFailed(c.Config, ip, cred.Username)
+			authFailed(cfgM.AuthMethod)
 			return http.StatusForbidden, nil
 		}
 	}
 
 	uc, ok := c.Config.GetUserByUsername(cred.Username)
 	if !ok {
+		fb.LoginFailed(c.Config, ip, cred.Username)
+		authFailed(cfgM.AuthMethod)
 		return http.StatusForbidden, nil
 	}
 	if !uc.IsGuest() {
 		// Checks if the password is correct.
 		if !ok || !fb.CheckPasswordHash(cred.Password, uc.Password) {
+			fb.LoginFailed(c.Config, ip, cred.Username)
+			authFailed(cfgM.AuthMethod)
 			return http.StatusForbidden, nil
 		}
+		if !isActive(uc, cfgM.AuthMethod) {
+			//password was right, account state refused
+			fb.LoginRelease(ip, cred.Username)
+			authFailed(cfgM.AuthMethod)
+			return http.StatusForbidden, nil
+		}
+		if uc.HasTwoFactor() {
+			//ask client for the code
+			if len(cred.OTP) == 0 {
+				fb.LoginRelease(ip, cred.Username)
+				c.RESP.Header().Set("X-OTP-Required", "true")
+				return http.StatusForbidden, nil
+			}
+			if !checkSecondFactor(c, uc, cred.OTP) {
+				fb.LoginFailed(c.Config, ip, cred.Username)
+				authFailed("otp")
+				return http.StatusForbidden, nil
+			}
+		}
+		rehash(c, uc, cred.Password)
+		c.Config.SetLastLogin(uc.Username, c.REQ.RemoteAddr)
 	}
+	fb.LoginSucceeded(ip, cred.Username)
 
 	c.User = fb.ToUserModel(uc, c.Config)
+	if !uc.IsGuest() {
+		if err = newSession(c); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
 	return printToken(c)
 }
 
@@ -173,10 +245,14 @@ func authHandler(c *fb.Context) (int, error) {
 // and is checking if it is up to date. If so, updates its info.
 func renewAuthHandler(c *fb.Context) (int, error) {
 	ok, u := validateAuth(c)
-	if !ok {
+	//personal api token or signed url never turns into login token
+	if !ok || c.APIToken != nil || c.Signed {
 		return http.StatusForbidden, nil
 	}
 	c.User = u
+	if len(c.Session) > 0 && !c.Config.RenewSession(u.Username, c.Session, time.Now().Add(tokenTTL)) {
+		return http.StatusForbidden, nil
+	}
 	return printToken(c)
 }
 
@@ -192,14 +268,15 @@ func printToken(c *fb.Context) (int, error) {
 	// hash so it never arrives to the user.
 	u := fb.UserModel{}
 	u = *c.User
-	u.Password = ""
+	u.HideSecrets()
 
 	// Builds the claims.
 	claims := Claims{
 		u,
 		jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
+			ExpiresAt: time.Now().Add(tokenTTL).Unix(),
 			Issuer:    "Browse File",
+			Id:        c.Session,
 		},
 	}
 
@@ -262,7 +339,8 @@ func validateAuth(c *fb.Context) (bool, *fb.UserModel) {
 	// If proxy auth is used do not verify the JWT token if the header is provided.
 	if cfgM.AuthMethod == "proxy" {
 		u, ok := c.Config.GetUserByUsername(c.REQ.Header.Get(c.Config.Header))
-		if !ok {
+		if !ok || !isActive(u, cfgM.AuthMethod) {
+			authFailed(cfgM.AuthMethod)
 			return false, nil
 		}
 		c.User = fb.ToUserModel(u, c.Config)
@@ -278,24 +356,88 @@ func validateAuth(c *fb.Context) (bool, *fb.UserModel) {
 	var ok bool
 	if cfgM.AuthMethod == "ip" {
 		u, ok = c.Config.GetUserByIp(c.REQ.RemoteAddr)
-		if !ok {
+		if !ok || !isActive(u, cfgM.AuthMethod) {
+			authFailed(cfgM.AuthMethod)
 			return false, nil
 		}
 
 	} else {
+		if len(c.REQ.URL.Query().Get(cnst.P_SIG)) > 0 {
+			return signedAuth(c)
+		}
+		if t := c.REQ.Header.Get(cnst.H_XAUTH); strings.HasPrefix(t, apiTokenPrefix) {
+			return apiTokenAuth(c, t)
+		}
 		token, err := request.ParseFromRequest(c.REQ, extractor{}, keyFunc, request.WithClaims(&claims))
 
 		if err != nil || !token.Valid {
 			log.Println(err)
+			authFailed("token")
 			return false, nil
 		}
 
 		u, ok = c.Config.GetUserByUsername(claims.Username)
-		if !ok {
+		if !ok || !isActive(u, "token") {
+			authFailed("token")
 			return false, nil
 		}
+		//token of revoked session
+		if !u.IsGuest() && !u.HasSession(claims.Id) {
+			log.Printf("auth : session of %s revoked or expired\n", u.Username)
+			authFailed("token")
+			return false, nil
+		}
+		c.Session = claims.Id
 	}
 	c.User = fb.ToUserModel(u, c.Config)
 	return true, c.User
 
 }
+
+//upgrade stored hash to configured algorithm and cost, password already checked
+func rehash(c *fb.Context, u *config.UserConfig, password string) {
+	if !fb.NeedsRehash(c.Config, u.Password) {
+		return
+	}
+	hash, err := fb.HashPassword(c.Config, password)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	u.Password = hash
+	if err = c.Config.UpdatePassword(u); err != nil {
+		log.Println(err)
+	}
+}
+
+//password and app passwords of the user, cached dav auth invalid once it changes
+func credStamp(u *config.UserConfig) string {
+	res := u.Password
+	for _, p := range u.AppPasswords {
+		res += ":" + p.Hash
+	}
+	return res
+}
+
+//tell client, when next login attempt allowed
+func retryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+}
+
+//disabled and expired accounts refused everywhere
+func isActive(u *config.UserConfig, method string) bool {
+	if u.Active() {
+		return true
+	}
+	log.Printf("auth : %s account %s is not active\n", method, u.Username)
+	return false
+}
+
+//dav has no way to change password, so forced change blocks it as well
+func davActive(u *config.UserConfig, method string) bool {
+	if u.MustChangePassword {
+		log.Printf("auth : %s account %s must change password\n", method, u.Username)
+		return false
+	}
+	return isActive(u, method)
+}
@@ -0,0 +1,439 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	fb "github.com/browsefile/backend/src/lib"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func login(cfg *TServContext, username, password string) int {
+	rs, err := http.Post(cfg.Srv.URL+"/api/auth/get", "application/json",
+		strings.NewReader(`{"username":"`+username+`","password":"`+password+`"}`))
+	if err != nil {
+		return 0
+	}
+	_ = rs.Body.Close()
+	return rs.StatusCode
+}
+
+func TestAccountLifecycle(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	dat := map[string]interface{}{"u": "/", "method": http.MethodGet}
+
+	if code := login(&cfg, cfg.Usr1.Username, "1"); code != http.StatusOK {
+		t.Fatal("login failed", code)
+	}
+	if u, _ := cfg.GetUserByUsername(cfg.Usr1.Username); u.LastLogin == nil || len(u.LastIP) == 0 {
+		t.Error("last login must be recorded")
+	}
+
+	//expired user refused at login, with existing token and at dav
+	past := time.Now().Add(-time.Hour)
+	cfg.Usr1.ExpiresAt = &past
+	_ = cfg.Update(cfg.Usr1)
+	if code := login(&cfg, cfg.Usr1.Username, "1"); code != http.StatusForbidden {
+		t.Error("expired user must not login", code)
+	}
+	_, rs, _ := cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false)
+	if rs.StatusCode != http.StatusForbidden {
+		t.Error("token of expired user must be refused", rs.StatusCode)
+	}
+	req, _ := http.NewRequest("PROPFIND", cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/", nil)
+	req.SetBasicAuth(cfg.Usr1.Username, "1")
+	if rs, _ = http.DefaultClient.Do(req); rs.StatusCode != http.StatusUnauthorized {
+		t.Error("expired user must not use dav", rs.StatusCode)
+	}
+
+	//forced password change allows only users api
+	cfg.Usr1.ExpiresAt = nil
+	cfg.Usr1.MustChangePassword = true
+	_ = cfg.Update(cfg.Usr1)
+	_, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false)
+	if rs.StatusCode != http.StatusForbidden {
+		t.Error("resources must be blocked until password changed", rs.StatusCode)
+	}
+	body := `{"what":"user","which":"password","data":{"username":"user1","password":"2"}}`
+	req, _ = http.NewRequest(http.MethodPut, cfg.Srv.URL+"/api/users/user1", strings.NewReader(body))
+	req.Header.Set(cnst.H_XAUTH, cfg.Token)
+	if rs, _ = http.DefaultClient.Do(req); rs.StatusCode != http.StatusOK {
+		t.Fatal("password must be changed", rs.StatusCode)
+	}
+	_, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, nil, t, false)
+	if rs.StatusCode != http.StatusOK {
+		t.Error("resources must be allowed after password change", rs.StatusCode)
+	}
+
+	cfg.Usr1.Disabled = true
+	_ = cfg.Update(cfg.Usr1)
+	if code := login(&cfg, cfg.Usr1.Username, "2"); code != http.StatusForbidden {
+		t.Error("disabled user must not login", code)
+	}
+}
+
+func TestPasswordRehash(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	cfg.Password = &config.PasswordConf{Algo: config.AlgoArgon2id, Memory: 1024, Threads: 1, MinLength: 4}
+
+	if code := login(&cfg, cfg.Usr1.Username, "1"); code != http.StatusOK {
+		t.Fatal("login failed", code)
+	}
+	u, _ := cfg.GetUserByUsername(cfg.Usr1.Username)
+	if !strings.HasPrefix(u.Password, "$argon2id$") {
+		t.Fatal("hash must be upgraded on login", u.Password)
+	}
+	if code := login(&cfg, cfg.Usr1.Username, "1"); code != http.StatusOK {
+		t.Fatal("login with upgraded hash failed", code)
+	}
+	if code := login(&cfg, cfg.Usr1.Username, "2"); code != http.StatusForbidden {
+		t.Fatal("wrong password accepted", code)
+	}
+
+	//policy applied on change
+	dat := map[string]interface{}{"u": "/user1", "method": http.MethodPut}
+	buf := new(bytes.Buffer)
+	_ = json.NewEncoder(buf).Encode(map[string]interface{}{"what": "user", "which": "password",
+		"data": map[string]string{"username": "user1", "password": "abc"}})
+	dat["body"] = buf
+	_, rs, _ := cfg.MakeRequest(cnst.R_USERS, dat, cfg.GetAdmin(), t, false)
+	if rs.StatusCode != http.StatusBadRequest {
+		t.Error("short password must be rejected", rs.StatusCode)
+	}
+}
+
+//request with token of last MakeRequest, body decoded into res
+func authRequest(cfg *TServContext, method, path, body string, res interface{}) int {
+	req, _ := http.NewRequest(method, cfg.Srv.URL+"/api"+path, strings.NewReader(body))
+	req.Header.Set(cnst.H_XAUTH, cfg.Token)
+	rs, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0
+	}
+	defer rs.Body.Close()
+	if res != nil {
+		_ = json.NewDecoder(rs.Body).Decode(res)
+	}
+	return rs.StatusCode
+}
+
+func TestTwoFactor(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	//dav folders
+	cfg.WriteConfig()
+	cfg.ReadConfigFile()
+	dat := map[string]interface{}{"u": "/", "method": http.MethodGet}
+	_, _, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false)
+
+	setup := map[string]string{}
+	if code := authRequest(&cfg, http.MethodPost, "/auth/2fa/setup", "{}", &setup); code != http.StatusOK {
+		t.Fatal("setup failed", code)
+	}
+	if !strings.HasPrefix(setup["uri"], "otpauth://totp/") {
+		t.Error("wrong provisioning uri", setup["uri"])
+	}
+	secret := setup["secret"]
+	code, _ := fb.TOTPCode(secret, fb.TOTPStep(time.Now())-1)
+	if authRequest(&cfg, http.MethodPost, "/auth/2fa/enable", `{"secret":"`+secret+`","code":"000000x"}`, nil) != http.StatusBadRequest {
+		t.Error("wrong code must be refused")
+	}
+	recovery := map[string][]string{}
+	if c := authRequest(&cfg, http.MethodPost, "/auth/2fa/enable", `{"secret":"`+secret+`","code":"`+code+`"}`, &recovery); c != http.StatusOK {
+		t.Fatal("enable failed", c)
+	}
+	if len(recovery["recoveryCodes"]) == 0 {
+		t.Fatal("recovery codes expected")
+	}
+	//enrolled factor replaced only with its code
+	other, _ := fb.GenerateTOTPSecret()
+	otherCode, _ := fb.TOTPCode(other, fb.TOTPStep(time.Now()))
+	if c := authRequest(&cfg, http.MethodPost, "/auth/2fa/enable", `{"secret":"`+other+`","code":"`+otherCode+`"}`, nil); c != http.StatusForbidden {
+		t.Error("enrolled two factor replaced without current code", c)
+	}
+	//code guessing by session limited same as login
+	if c := authRequest(&cfg, http.MethodPost, "/auth/2fa/disable", `{"code":"x"}`, nil); c != http.StatusTooManyRequests {
+		t.Error("wrong code must delay next check", c)
+	}
+	fb.ClearLockout("", "")
+	if c := authRequest(&cfg, http.MethodPost, "/auth/2fa/recovery", `{"code":"x"}`, nil); c != http.StatusForbidden {
+		t.Error("wrong code accepted", c)
+	}
+	if c := authRequest(&cfg, http.MethodPost, "/auth/2fa/disable", `{"code":"x"}`, nil); c != http.StatusTooManyRequests {
+		t.Error("wrong code must delay next check", c)
+	}
+	fb.ClearLockout("", "")
+
+	//login requires code, every code works once
+	rs, _ := http.Post(cfg.Srv.URL+"/api/auth/get", "application/json",
+		strings.NewReader(`{"username":"user1","password":"1"}`))
+	if rs.StatusCode != http.StatusForbidden || rs.Header.Get("X-OTP-Required") != "true" {
+		t.Error("code must be requested", rs.StatusCode)
+	}
+	code, _ = fb.TOTPCode(secret, fb.TOTPStep(time.Now()))
+	if c := login(&cfg, "user1", `1","otp":"`+code); c != http.StatusOK {
+		t.Error("login with code failed", c)
+	}
+	if c := login(&cfg, "user1", `1","otp":"`+code); c != http.StatusForbidden {
+		t.Error("replayed code must be refused", c)
+	}
+	//failure delays next attempt
+	fb.ClearLockout("", "")
+	rc := recovery["recoveryCodes"][0]
+	if c := login(&cfg, "user1", `1","otp":"`+rc); c != http.StatusOK {
+		t.Error("login with recovery code failed", c)
+	}
+	if c := login(&cfg, "user1", `1","otp":"`+rc); c != http.StatusForbidden {
+		t.Error("recovery code must work once", c)
+	}
+	fb.ClearLockout("", "")
+
+	//secrets not exposed
+	if u, _ := cfg.GetUserByUsername("user1"); !u.HasTwoFactor() {
+		t.Fatal("two factor must be stored")
+	}
+	users := []*config.UserConfig{}
+	_, _, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.GetAdmin(), t, false)
+	authRequest(&cfg, http.MethodGet, "/users/", "", &users)
+	for _, u := range users {
+		if u.TwoFactor != nil && len(u.TwoFactor.Secret) > 0 {
+			t.Error("secret must be hidden", u.Username)
+		}
+	}
+
+	//dav accepts only app passwords
+	u1, _ := cfg.GetUserByUsername("user1")
+	_, _, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, u1, t, false)
+	app := map[string]string{}
+	if c := authRequest(&cfg, http.MethodPost, "/auth/app-passwords", `{"name":"phone"}`, &app); c != http.StatusOK {
+		t.Fatal("app password not created", c)
+	}
+	dav := func(pwd string) int {
+		req, _ := http.NewRequest("PROPFIND", cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/", nil)
+		req.SetBasicAuth("user1", pwd)
+		rs, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		_ = rs.Body.Close()
+		return rs.StatusCode
+	}
+	if c := dav("1"); c != http.StatusUnauthorized {
+		t.Error("account password must be refused at dav", c)
+	}
+	fb.ClearLockout("", "")
+	if c := dav(app["password"]); c != http.StatusMultiStatus {
+		t.Error("app password must be accepted at dav", c)
+	}
+	if c := authRequest(&cfg, http.MethodDelete, "/auth/app-passwords/phone", "", nil); c != http.StatusOK {
+		t.Error("app password not deleted", c)
+	}
+
+	//admin must enroll before using api
+	cfg.AdminTwoFactor = true
+	_, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.GetAdmin(), t, false)
+	if rs.StatusCode != http.StatusForbidden {
+		t.Error("admin without two factor must be restricted", rs.StatusCode)
+	}
+	st := map[string]interface{}{}
+	if c := authRequest(&cfg, http.MethodGet, "/auth/2fa", "", &st); c != http.StatusOK || st["required"] != true {
+		t.Error("enrollment must stay allowed", c, st)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	cfg.Lockout = &config.LockoutConf{Attempts: 3, Duration: 60}
+
+	//backoff after first failure
+	if c := login(&cfg, "user1", "2"); c != http.StatusForbidden {
+		t.Fatal("wrong password accepted", c)
+	}
+	rs, _ := http.Post(cfg.Srv.URL+"/api/auth/get", "application/json",
+		strings.NewReader(`{"username":"user1","password":"1"}`))
+	if rs.StatusCode != http.StatusTooManyRequests || rs.Header.Get("Retry-After") != "1" {
+		t.Error("next attempt must be delayed", rs.StatusCode, rs.Header.Get("Retry-After"))
+	}
+	if len(fb.Lockouts()) > 0 {
+		t.Error("backoff is not lockout yet")
+	}
+
+	//success forgets failures of username only, ip keeps them
+	fb.ClearLockout(fb.LockUser, "user1")
+	time.Sleep(1100 * time.Millisecond)
+	if c := login(&cfg, "user1", "1"); c != http.StatusOK {
+		t.Fatal("login after backoff failed", c)
+	}
+	_ = login(&cfg, "other", "2")
+	rs, _ = http.Post(cfg.Srv.URL+"/api/auth/get", "application/json",
+		strings.NewReader(`{"username":"user1","password":"1"}`))
+	if rs.StatusCode != http.StatusTooManyRequests || rs.Header.Get("Retry-After") != "2" {
+		t.Error("ip failures must not be reset by success", rs.StatusCode, rs.Header.Get("Retry-After"))
+	}
+
+	//lockout covers dav as well
+	fb.ClearLockout("", "")
+	cfg.Lockout.Attempts = 1
+	if c := login(&cfg, "nobody", "2"); c != http.StatusForbidden {
+		t.Fatal("unknown user accepted", c)
+	}
+	req, _ := http.NewRequest("PROPFIND", cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/", nil)
+	req.SetBasicAuth("user1", "1")
+	if rs, _ = http.DefaultClient.Do(req); rs.StatusCode != http.StatusTooManyRequests {
+		t.Error("dav must be locked for ip", rs.StatusCode)
+	}
+
+	dat := map[string]interface{}{"u": "/", "method": http.MethodGet}
+	_, _, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.GetAdmin(), t, false)
+	var list []*fb.Lockout
+	if c := authRequest(&cfg, http.MethodGet, "/auth/lockouts", "", &list); c != http.StatusOK || len(list) != 2 {
+		t.Fatal("ip and username must be locked", c, len(list))
+	}
+	if c := authRequest(&cfg, http.MethodDelete, "/auth/lockouts/ip/127.0.0.1", "", nil); c != http.StatusOK {
+		t.Error("lockout not cleared", c)
+	}
+	if c := authRequest(&cfg, http.MethodDelete, "/auth/lockouts/ip/127.0.0.1", "", nil); c != http.StatusNotFound {
+		t.Error("lockout must be cleared once", c)
+	}
+	if c := login(&cfg, "user1", "1"); c != http.StatusOK {
+		t.Error("login must be allowed after clear", c)
+	}
+	if c := login(&cfg, "nobody", "1"); c != http.StatusTooManyRequests {
+		t.Error("username must stay locked", c)
+	}
+
+	_, _, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false)
+	if c := authRequest(&cfg, http.MethodGet, "/auth/lockouts", "", nil); c != http.StatusForbidden {
+		t.Error("lockouts are admin only", c)
+	}
+}
+
+func TestLoginParallel(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	fb.ClearLockout("", "")
+	cfg.Lockout = &config.LockoutConf{Attempts: 3, Duration: 60}
+	//attempts in progress must not delay each other
+	codes := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			codes <- login(&cfg, "user1", "1")
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		if c := <-codes; c != http.StatusOK {
+			t.Error("parallel login refused", c)
+		}
+	}
+	if c := login(&cfg, "user1", "1"); c != http.StatusOK {
+		t.Error("successful logins must not be counted", c)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	//dav folders
+	cfg.WriteConfig()
+	cfg.ReadConfigFile()
+	token := func() string {
+		rs, err := http.Post(cfg.Srv.URL+"/api/auth/get", "application/json",
+			strings.NewReader(`{"username":"user1","password":"1"}`))
+		if err != nil || rs.StatusCode != http.StatusOK {
+			t.Fatal("login failed", err)
+		}
+		defer rs.Body.Close()
+		b := new(bytes.Buffer)
+		_, _ = b.ReadFrom(rs.Body)
+		return b.String()
+	}
+	first, second := token(), token()
+
+	var list []map[string]interface{}
+	cfg.Token = first
+	if c := authRequest(&cfg, http.MethodGet, "/auth/sessions", "", &list); c != http.StatusOK || len(list) != 2 {
+		t.Fatal("two sessions expected", c, len(list))
+	}
+	var id string
+	for _, s := range list {
+		if s["current"] != true {
+			id = s["id"].(string)
+		}
+	}
+	if c := authRequest(&cfg, http.MethodDelete, "/auth/sessions/"+id, "", nil); c != http.StatusOK {
+		t.Error("session not revoked", c)
+	}
+	cfg.Token = second
+	if c := authRequest(&cfg, http.MethodGet, "/auth/sessions", "", nil); c != http.StatusForbidden {
+		t.Error("revoked token must be refused", c)
+	}
+	cfg.Token = first
+	if c := authRequest(&cfg, http.MethodPost, "/auth/logout", "", nil); c != http.StatusOK {
+		t.Error("logout failed", c)
+	}
+	if c := authRequest(&cfg, http.MethodGet, "/auth/sessions", "", nil); c != http.StatusForbidden {
+		t.Error("token must be refused after logout", c)
+	}
+
+	//password change keeps only current session
+	first, second = token(), token()
+	u, _ := cfg.GetUserByUsername("user1")
+	u.MustChangePassword = true
+	_ = cfg.Update(u)
+	cfg.Token = first
+	body := `{"what":"user","which":"password","data":{"username":"user1","password":"2"}}`
+	if c := authRequest(&cfg, http.MethodPut, "/users/user1", body, nil); c != http.StatusOK {
+		t.Fatal("password not changed", c)
+	}
+	if c := authRequest(&cfg, http.MethodGet, "/auth/sessions", "", nil); c != http.StatusOK {
+		t.Error("current session must stay", c)
+	}
+	cfg.Token = second
+	if c := authRequest(&cfg, http.MethodGet, "/auth/sessions", "", nil); c != http.StatusForbidden {
+		t.Error("other sessions must be revoked on password change", c)
+	}
+
+	//cached dav auth dropped with password change
+	dav := func() int {
+		req, _ := http.NewRequest("PROPFIND", cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/", nil)
+		req.SetBasicAuth("user1", "2")
+		rs, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		_ = rs.Body.Close()
+		return rs.StatusCode
+	}
+	if c := dav(); c != http.StatusMultiStatus {
+		t.Fatal("dav login failed", c)
+	}
+	u, _ = cfg.GetUserByUsername("user1")
+	u.Password, _ = fb.HashPassword(cfg.GlobalConfig, "3")
+	_ = cfg.UpdatePassword(u)
+	if c := dav(); c != http.StatusUnauthorized {
+		t.Error("cached dav auth must be dropped", c)
+	}
+
+	//demotion revokes tokens
+	dat := map[string]interface{}{"u": "/", "method": http.MethodGet}
+	admin, _ := cfg.GetUserByUsername(cfg.GetAdmin().Username)
+	_, _, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, admin, t, false)
+	admin.Admin = false
+	_ = cfg.Update(admin)
+	if c := authRequest(&cfg, http.MethodGet, "/auth/sessions", "", nil); c != http.StatusForbidden {
+		t.Error("token of demoted admin must be refused", c)
+	}
+}
@@ -4,12 +4,21 @@ import (
 	"context"
 	"github.com/browsefile/backend/src/cnst"
 	"github.com/browsefile/backend/src/lib"
+	"github.com/browsefile/backend/src/config"
+	"github.com/browsefile/backend/src/lib/metrics"
+	"github.com/browsefile/backend/src/lib/utils"
+	"golang.org/x/net/webdav"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 )
 
 // ServeHTTP determines if the request is for this plugin, and if all prerequisites are met.
 func ServeDav(c *lib.Context, w http.ResponseWriter, r *http.Request) {
+	metrics.DavRequests.Inc(r.Method)
 	if !authDavHandler(c, w, r) {
 		return
 	}
@@ -23,10 +32,18 @@ func ServeDav(c *lib.Context, w http.ResponseWriter, r *http.Request) {
 		r.Method == "DELETE" || r.Method == "COPY" || r.Method == "MOVE" {
 		if (strings.HasPrefix(r.URL.Path, cnst.WEB_DAV_URL+"/shares") ||
 			!strings.HasPrefix(r.URL.Path, cnst.WEB_DAV_URL+"/files")) ||
-			!(c.User.AllowEdit || c.User.AllowNew) {
+			!c.User.Can(davPerm(c, r)) {
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
+		if p, ok := davHomePath(r.URL.Path); ok && !c.User.FileSystem.Allowed(p, utils.AccessWrite) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if err := checkDavQuota(c, r); err != nil {
+			w.WriteHeader(cnst.ErrorToHTTP(err, false))
+			return
+		}
 	}
 
 	// Excerpt from RFC4918, section 9.4:
@@ -44,11 +61,262 @@ func ServeDav(c *lib.Context, w http.ResponseWriter, r *http.Request) {
 			if r.Header.Get("Depth") == "" {
 				r.Header.Add("Depth", "1")
 			}
+		} else if !c.User.Can(config.PermDownload) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	// Runs the WebDAV, hrefs and destinations carry public base path
+	h := c.User.DavHandler
+	if base := c.BasePath(); len(base) > 0 {
+		cp := *h
+		cp.Prefix = base
+		h = &cp
+		r.URL.Path = base + r.URL.Path
+	}
+	fixDestination(c, r)
+	h.ServeHTTP(w, r)
+}
+
+//permission required by modifying dav request
+func davPerm(c *lib.Context, r *http.Request) string {
+	switch r.Method {
+	case "MKCOL":
+		return config.PermMkdir
+	case "DELETE":
+		return config.PermDelete
+	case "MOVE":
+		return config.PermRename
+	case "PUT":
+		if _, err := c.User.DavHandler.FileSystem.Stat(context.TODO(), r.URL.Path); err == nil {
+			return config.PermOverwrite
+		}
+	}
+	return config.PermUpload
+}
+
+//destination host seen by client differs from request host behind proxy
+func fixDestination(c *lib.Context, r *http.Request) {
+	d := r.Header.Get("Destination")
+	if len(d) == 0 {
+		return
+	}
+	u, err := url.Parse(d)
+	if err != nil || len(u.Host) == 0 || u.Host == r.Host {
+		return
+	}
+	if pub, err := url.Parse(c.PublicURL()); err == nil && pub.Host == u.Host {
+		u.Host = r.Host
+		r.Header.Set("Destination", u.String())
+	}
+}
+
+//reject writes exceeding user quota before dav handler, it responds 405 on failed writes
+func checkDavQuota(c *lib.Context, r *http.Request) error {
+	var size int64
+	switch r.Method {
+	case "PUT":
+		size = r.ContentLength
+		if info, err := c.User.DavHandler.FileSystem.Stat(context.TODO(), r.URL.Path); err == nil && !info.IsDir() {
+			size -= info.Size()
+		}
+	case "COPY":
+		size = lib.DiskUsage(davRealPath(c.Config, c.User.Username, r.URL.Path))
+	}
+	return lib.CheckQuota(c.Config, c.User.UserConfig, size)
+}
+
+//dav path to path on disk
+func davRealPath(cfg *config.GlobalConfig, username, name string) string {
+	return filepath.Join(cfg.GetDavPath(username), filepath.FromSlash(path.Clean("/"+name)))
+}
+
+//dav file system, that keeps used space of user up to date and stops writes over quota
+type quotaFS struct {
+	webdav.FileSystem
+	cfg      *config.GlobalConfig
+	username string
+}
+
+//user, whose quota used by name. Files inside shares folder belongs to share owners
+func (fs *quotaFS) owner(name string) string {
+	name = utils.SlashClean(name)
+	for _, prefix := range []string{cnst.WEB_DAV_URL + "/shares/", "/shares/"} {
+		if strings.HasPrefix(name, prefix) {
+			if i := strings.IndexByte(name[len(prefix):], '/'); i > 0 {
+				return name[len(prefix) : len(prefix)+i]
+			}
+		}
+	}
+	return fs.username
+}
+
+func (fs *quotaFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	}
+	u, ok := fs.cfg.GetUserByUsername(fs.owner(name))
+	if !ok {
+		return nil, os.ErrPermission
+	}
+	var old int64
+	if info, err := fs.FileSystem.Stat(ctx, name); err == nil && !info.IsDir() {
+		old = info.Size()
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_TRUNC != 0 {
+		lib.AddUsedSpace(fs.cfg, u.Username, -old)
+		old = 0
+	}
+	res := &quotaFile{File: f, fs: fs, size: old}
+	res.w = &lib.QuotaWriter{Writer: f, Cfg: fs.cfg, User: u}
+	return res, nil
+}
+
+func (fs *quotaFS) RemoveAll(ctx context.Context, name string) error {
+	size := lib.DiskUsage(davRealPath(fs.cfg, fs.username, name))
+	err := fs.FileSystem.RemoveAll(ctx, name)
+	if err == nil {
+		lib.AddUsedSpace(fs.cfg, fs.owner(name), -size)
+	}
+	return err
+}
+
+//file opened for writing, written bytes reserved at owner quota, size change corrected on close
+type quotaFile struct {
+	webdav.File
+	fs *quotaFS
+	w  *lib.QuotaWriter
+	//size at open
+	size int64
+}
+
+func (f *quotaFile) Write(p []byte) (int, error) {
+	return f.w.Write(p)
+}
+
+func (f *quotaFile) Close() error {
+	if info, err := f.File.Stat(); err == nil {
+		lib.AddUsedSpace(f.fs.cfg, f.w.User.Username, info.Size()-f.size-f.w.Reserved)
+	} else {
+		lib.AddUsedSpace(f.fs.cfg, f.w.User.Username, -f.w.Reserved)
+	}
+	return f.File.Close()
+}
+
+//dav file system, that applies path rules of the user to the files folder
+type rulesFS struct {
+	webdav.FileSystem
+	cfg      *config.GlobalConfig
+	username string
+}
+
+//path inside user home for dav name, false in case name out of user files
+func davHomePath(name string) (string, bool) {
+	name = utils.SlashClean(name)
+	for _, prefix := range []string{cnst.WEB_DAV_URL + "/files", "/files"} {
+		if name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return utils.SlashClean(strings.TrimPrefix(name, prefix)), true
+		}
+	}
+	return "", false
+}
+
+//actual rules of the user, so rules changes applied without handler rebuild
+func (fs *rulesFS) home() utils.RulesDir {
+	d := utils.RulesDir{Dir: utils.Dir(fs.cfg.GetUserHomePath(fs.username))}
+	if u, ok := fs.cfg.GetUserByUsername(fs.username); ok {
+		d.Rules = u.Rules
+	}
+	return d
+}
+
+func (fs *rulesFS) check(name, access string, tree bool) error {
+	p, ok := davHomePath(name)
+	if !ok {
+		return nil
+	}
+	if tree {
+		return fs.home().CheckTree(p, access)
+	}
+	return fs.home().Check(p, access)
+}
+
+func (fs *rulesFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := fs.check(name, utils.AccessWrite, false); err != nil {
+		return err
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fs *rulesFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	access := utils.AccessRead
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		access = utils.AccessWrite
+	} else if info, err := fs.FileSystem.Stat(ctx, name); err == nil && info.IsDir() {
+		access = utils.AccessList
+	}
+	if err := fs.check(name, access, false); err != nil {
+		return nil, err
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &rulesFile{File: f, fs: fs, name: name}, nil
+}
+
+func (fs *rulesFS) RemoveAll(ctx context.Context, name string) error {
+	if err := fs.check(name, utils.AccessWrite, true); err != nil {
+		return err
+	}
+	return fs.FileSystem.RemoveAll(ctx, name)
+}
+
+func (fs *rulesFS) Rename(ctx context.Context, oldName, newName string) error {
+	if err := fs.check(oldName, utils.AccessWrite, true); err != nil {
+		return err
+	}
+	if err := fs.check(newName, utils.AccessWrite, false); err != nil {
+		return err
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (fs *rulesFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if p, ok := davHomePath(name); ok {
+		if d := fs.home(); !d.Allowed(p, utils.AccessList) && !d.Allowed(p, utils.AccessRead) {
+			return nil, os.ErrNotExist
 		}
 	}
+	return fs.FileSystem.Stat(ctx, name)
+}
 
-	// Runs the WebDAV.
-	c.User.DavHandler.ServeHTTP(w, r)
+//folder opened by dav, hides entries denied to list
+type rulesFile struct {
+	webdav.File
+	fs   *rulesFS
+	name string
+}
+
+func (f *rulesFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	p, ok := davHomePath(f.name)
+	if !ok {
+		return infos, err
+	}
+	d := f.fs.home()
+	res := infos[:0]
+	for _, inf := range infos {
+		if d.Allowed(path.Join(p, inf.Name()), utils.AccessList) {
+			res = append(res, inf)
+		}
+	}
+	return res, err
 }
 
 // responseWriterNoBody is a wrapper used to suprress the body of the response
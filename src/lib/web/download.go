@@ -2,18 +2,38 @@ package web
 
 import (
 	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
 	fb "github.com/browsefile/backend/src/lib"
+	"github.com/browsefile/backend/src/lib/metrics"
 	"github.com/browsefile/backend/src/lib/utils"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // downloadHandler creates an archive in one of the supported formats (zip, tar,
 // tar.gz or tar.bz2) and sends it to be downloaded.
 func downloadHandler(c *fb.Context) (code int, err error) {
+	//previews allowed to browse
+	if !c.User.Can(config.PermDownload) && len(c.PreviewType) == 0 {
+		return http.StatusForbidden, nil
+	}
+	//client passes download to browser or player, without credentials
+	if c.Redirect && !c.Signed {
+		prefix := "/download"
+		if c.IsShare {
+			prefix = "/shares/download"
+		}
+		l, err := signedLink(c, prefix+c.URL, strings.Join(c.FilePaths, ","), defaultLinkTTL)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		http.Redirect(c.RESP, c.REQ, l, http.StatusSeeOther)
+		return 0, nil
+	}
 	if len(c.FilePaths) <= 1 {
 		if len(c.FilePaths) == 1 {
 			c.URL = c.FilePaths[0]
@@ -93,12 +113,19 @@ func serveDownload(c *fb.Context, infos []os.FileInfo) error {
 		name += ".zip"
 	}
 	c.RESP.Header().Set("Content-Disposition", "attachment; filename*=utf-8''"+url.PathEscape(name))
-	return utils.ServeArchiveCompress(c.FilePaths, c.Config.FilesPath, c.RESP, infos)
+	err := utils.ServeArchiveCompress(c.FilePaths, c.Config.FilesPath, c.RESP, infos)
+	if err == nil {
+		metrics.ZipArchives.Inc()
+	}
+	return err
 }
 
 //download single file, include preview
 func downloadFileHandler(c *fb.Context) (int, error) {
 	var err error
+	if !c.Allowed(c.URL, utils.AccessRead) {
+		return http.StatusForbidden, nil
+	}
 	c.File.Path = utils.SlashClean(c.File.Path)
 
 	file, err := os.Open(c.File.Path)
@@ -0,0 +1,62 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	fb "github.com/browsefile/backend/src/lib"
+	"net/http"
+)
+
+//manage user groups, admin only
+func groupsHandler(c *fb.Context) (int, error) {
+	if !c.User.Admin {
+		return http.StatusForbidden, nil
+	}
+	name := getUserName(c.URL)
+
+	switch c.Method {
+	case http.MethodGet:
+		if len(name) == 0 {
+			return renderJSON(c.RESP, c.Config.GetGroups())
+		}
+		g, ok := c.Config.GetGroup(name)
+		if !ok {
+			return http.StatusNotFound, cnst.ErrNotExist
+		}
+		return renderJSON(c.RESP, g)
+	case http.MethodPost, http.MethodPut:
+		if c.REQ.Body == nil {
+			return http.StatusBadRequest, cnst.ErrEmptyRequest
+		}
+		g := &config.GroupConfig{}
+		if err := json.NewDecoder(c.REQ.Body).Decode(g); err != nil {
+			return http.StatusBadRequest, err
+		}
+		if c.Method == http.MethodPut {
+			if _, ok := c.Config.GetGroup(name); !ok {
+				return http.StatusNotFound, cnst.ErrNotExist
+			}
+			g.Name = name
+		} else if _, ok := c.Config.GetGroup(g.Name); ok {
+			return http.StatusConflict, cnst.ErrExist
+		}
+		if err := c.Config.SetGroup(g); err != nil {
+			return http.StatusBadRequest, err
+		}
+		if c.Method == http.MethodPost {
+			c.RESP.Header().Set("Location", c.BasePath()+"/settings/groups/"+g.Name)
+			c.RESP.WriteHeader(http.StatusCreated)
+		}
+		return http.StatusOK, nil
+	case http.MethodDelete:
+		if err := c.Config.DeleteGroup(name); err == cnst.ErrNotExist {
+			return http.StatusNotFound, err
+		} else if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return http.StatusOK, nil
+	}
+
+	return http.StatusNotImplemented, nil
+}
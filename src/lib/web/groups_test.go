@@ -0,0 +1,65 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestGroups(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	body := func(g *config.GroupConfig) *bytes.Buffer {
+		b := new(bytes.Buffer)
+		_ = json.NewEncoder(b).Encode(g)
+		return b
+	}
+	g := &config.GroupConfig{Name: "family", Members: []string{cfg.Usr2.Username}}
+	dat := map[string]interface{}{"u": "/", "method": http.MethodPost, "body": body(g)}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_GROUPS, dat, cfg.Usr1, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Fatal("groups managed only by admin", rs.StatusCode)
+	}
+	dat["body"] = body(g)
+	if _, rs, _ := cfg.MakeRequest(cnst.R_GROUPS, dat, cfg.GetAdmin(), t, false); rs.StatusCode != http.StatusCreated {
+		t.Fatal("group not created", rs.StatusCode)
+	}
+	//share to the group
+	shr := &config.ShareItem{Path: cfg.SharePathDeep, AllowGroups: []string{"family"}}
+	dat = map[string]interface{}{"u": "/", "share": "my-meta", "method": http.MethodPost, "body": new(bytes.Buffer)}
+	_ = json.NewEncoder(dat["body"].(*bytes.Buffer)).Encode(shr)
+	if _, rs, _ := cfg.MakeRequest(cnst.R_SHARES, dat, cfg.Usr1, t, true); rs.StatusCode != http.StatusOK {
+		t.Fatal("share not created", rs.StatusCode)
+	}
+	usr1, _ := cfg.GetUserByUsername(cfg.Usr1.Username)
+	p, _ := usr1.GetShares(cfg.SharePathDeep, false)[0].ResolveSymlinkName()
+	link := filepath.Join(cfg.Usr1.Username, p)
+	if _, err := cfg.User2FSShare.Stat(link); err != nil {
+		t.Fatal("member must get share", err)
+	}
+	//member left
+	g.Members = nil
+	dat = map[string]interface{}{"u": "/family", "method": http.MethodPut, "body": body(g)}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_GROUPS, dat, cfg.GetAdmin(), t, false); rs.StatusCode != http.StatusOK {
+		t.Fatal("group not updated", rs.StatusCode)
+	}
+	if _, err := cfg.User2FSShare.Stat(link); err == nil {
+		t.Fatal("share must be removed from former member")
+	}
+	_, rs, _ := cfg.MakeRequest(cnst.R_GROUPS, map[string]interface{}{"u": "/"}, cfg.GetAdmin(), t, false)
+	var groups []*config.GroupConfig
+	if err := json.NewDecoder(rs.Body).Decode(&groups); err != nil || len(groups) != 1 || len(groups[0].Members) != 0 {
+		t.Fatal("wrong groups", groups, err)
+	}
+	dat = map[string]interface{}{"u": "/family", "method": http.MethodDelete}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_GROUPS, dat, cfg.GetAdmin(), t, false); rs.StatusCode != http.StatusOK {
+		t.Fatal("group not deleted", rs.StatusCode)
+	}
+	if _, rs, _ := cfg.MakeRequest(cnst.R_GROUPS, dat, cfg.GetAdmin(), t, false); rs.StatusCode != http.StatusNotFound {
+		t.Fatal("deleted group must not exist", rs.StatusCode)
+	}
+}
@@ -0,0 +1,191 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/browsefile/backend/src/cnst"
+	fb "github.com/browsefile/backend/src/lib"
+	"github.com/browsefile/backend/src/lib/metrics"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var routerNames = map[int]string{
+	cnst.R_SEARCH:   "search",
+	cnst.R_SETTINGS: "settings",
+	cnst.R_USERS:    "users",
+	cnst.R_RESOURCE: "resource",
+	cnst.R_DOWNLOAD: "download",
+	cnst.R_SHARES:   "shares",
+	cnst.R_PLAYLIST: "playlist",
+	cnst.R_GROUPS:   "groups",
+}
+
+//process alive, used by liveness probes
+func healthHandler(c *fb.Context) (int, error) {
+	c.RESP.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = c.RESP.Write([]byte("ok"))
+	return 0, nil
+}
+
+type readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+//config writable, files path reachable and preview workers alive, 503 in case any fails. Reasons only logged, since probe has no auth
+func readyHandler(c *fb.Context) (int, error) {
+	res := &readiness{Status: "ok", Checks: make(map[string]string)}
+	check := func(name string, err error) {
+		if err != nil {
+			log.Println("ready : check", name, "failed", err)
+			res.Status = "fail"
+			res.Checks[name] = "fail"
+		} else {
+			res.Checks[name] = "ok"
+		}
+	}
+	check("config", checkWritable(filepath.Dir(c.Config.Path)))
+	check("filesPath", checkDir(c.Config.FilesPath))
+	var err error
+	if c.Pgen == nil || !c.Pgen.Alive() {
+		err = errors.New("preview workers stopped")
+	}
+	check("preview", err)
+
+	code := http.StatusOK
+	if res.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	c.RESP.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.RESP.WriteHeader(code)
+	return 0, json.NewEncoder(c.RESP).Encode(res)
+}
+
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".bf_ready")
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
+func checkDir(p string) error {
+	inf, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	if !inf.IsDir() {
+		return cnst.ErrNotExist
+	}
+	return nil
+}
+
+//prometheus text format
+func metricsHandler(c *fb.Context) (int, error) {
+	c.RESP.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	return 0, metrics.WriteText(c.RESP)
+}
+
+//count failed authentication, by auth method
+func authFailed(method string) {
+	if len(method) == 0 {
+		method = "default"
+	}
+	metrics.AuthFailures.Inc(method)
+}
+
+//counts bytes of request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+//counts bytes of response body and keeps status code
+type countingWriter struct {
+	http.ResponseWriter
+	n    int64
+	code int
+}
+
+func (w *countingWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+//router label of request, path is the original url path
+func routerLabel(c *fb.Context, path string) string {
+	switch {
+	case path == "/healthz" || path == "/readyz" || path == "/metrics":
+		return strings.TrimPrefix(path, "/")
+	case matchURL(path, "/static"):
+		return "static"
+	case matchURL(path, cnst.WEB_DAV_URL):
+		return "dav"
+	case matchURL(path, "/api/auth"):
+		return "auth"
+	case matchURL(path, "/api"):
+		if n, ok := routerNames[c.Router]; ok {
+			return n
+		}
+		return "unknown"
+	}
+	return "index"
+}
+
+//record request metrics after it served
+func observe(c *fb.Context, path string, start time.Time, r *countingReader, w *countingWriter) {
+	router := routerLabel(c, path)
+	code := w.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	metrics.Requests.Inc(router, strconv.Itoa(code))
+	metrics.Latency.Observe(time.Since(start).Seconds(), router)
+	method := c.REQ.Method
+	switch router {
+	case "resource":
+		if r != nil && (method == http.MethodPut || method == http.MethodPost) {
+			metrics.UploadedBytes.Add(float64(r.n))
+		}
+	case "download":
+		metrics.DownloadedBytes.Add(float64(w.n))
+	case "dav":
+		if r != nil && method == http.MethodPut {
+			metrics.UploadedBytes.Add(float64(r.n))
+		} else if method == http.MethodGet {
+			metrics.DownloadedBytes.Add(float64(w.n))
+		}
+	}
+}
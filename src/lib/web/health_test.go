@@ -0,0 +1,71 @@
+package web
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/lib/metrics"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	for _, p := range []string{"/healthz", "/readyz"} {
+		rs, err := http.Get(cfg.Srv.URL + p)
+		if err != nil || rs.StatusCode != http.StatusOK {
+			t.Fatal(p, "must be ok without auth", err)
+		}
+	}
+	//files path gone
+	_ = os.RemoveAll(cfg.FilesPath)
+	rs, _ := http.Get(cfg.Srv.URL + "/readyz")
+	b, _ := ioutil.ReadAll(rs.Body)
+	if rs.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(b), `"filesPath":"fail"`) {
+		t.Fatal("missed files path must fail readiness", rs.StatusCode, string(b))
+	}
+	if strings.Contains(string(b), cfg.FilesPath) {
+		t.Fatal("paths must not be exposed", string(b))
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	zips := metrics.ZipArchives.Get()
+	fails := metrics.AuthFailures.Get("token")
+
+	dat := map[string]interface{}{"u": "/", "files": []string{"/test"}}
+	_, rs, _ := cfg.MakeRequest(cnst.R_DOWNLOAD, dat, cfg.GetAdmin(), t, false)
+	_, _ = ioutil.ReadAll(rs.Body)
+	cfg.Token = "bad.token.value"
+	_, _, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, nil, t, false)
+	if metrics.ZipArchives.Get() != zips+1 {
+		t.Fatal("zip archive not counted")
+	}
+	if metrics.AuthFailures.Get("token") != fails+1 {
+		t.Fatal("auth failure not counted")
+	}
+
+	rs, err := http.Get(cfg.Srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(rs.Body)
+	for _, s := range []string{
+		`browsefile_http_requests_total{router="download",code="200"}`,
+		`browsefile_http_request_duration_seconds_bucket{router="download",le="+Inf"}`,
+		"browsefile_downloaded_bytes_total",
+		"browsefile_preview_queue_depth",
+		`browsefile_auth_failures_total{method="token"}`,
+	} {
+		if !strings.Contains(string(b), s) {
+			t.Fatal("metric missed", s)
+		}
+	}
+}
@@ -3,16 +3,27 @@ package web
 import (
 	"github.com/browsefile/backend/src/config"
 	"github.com/browsefile/backend/src/lib"
+	"github.com/browsefile/backend/src/lib/metrics"
 	"github.com/browsefile/backend/src/lib/utils"
 	"golang.org/x/net/webdav"
 	"log"
 	"net/http"
+	"strings"
 )
 
 func SetupHandler(cfg *config.GlobalConfig) http.Handler {
+	return Handler(NewFileBrowser(cfg))
+}
+
+//creates and setup file browser instance over the config
+func NewFileBrowser(cfg *config.GlobalConfig) *lib.FileBrowser {
 	fb := &lib.FileBrowser{
-		Config:    cfg,
-		ReCaptcha: &lib.ReCaptcha{cfg.CaptchaConfig.Host, cfg.CaptchaConfig.Key, cfg.CaptchaConfig.Secret},
+		Config: cfg,
+		ReCaptcha: &lib.ReCaptcha{
+			Host:   cfg.CaptchaConfig.Host,
+			Key:    cfg.CaptchaConfig.Key,
+			Secret: cfg.CaptchaConfig.Secret,
+		},
 		NewFS: func(scope string) lib.FileSystem {
 			return utils.Dir(scope)
 		},
@@ -22,19 +33,102 @@ func SetupHandler(cfg *config.GlobalConfig) http.Handler {
 	if err != nil {
 		log.Fatal(err)
 	}
+	metrics.SetPreviewQueue(fb.Pgen.QueueDepth)
 	if needUpd {
 		cfg.WriteConfig()
 	}
 
-	return Handler(fb)
+	return fb
 }
+
+//dav locks shared between handlers, so they survive config reload
+var davLocks = webdav.NewMemLS()
+
 func DavHandler(fb *lib.FileBrowser) {
-	ramLock := webdav.NewMemLS()
-	for _, u := range fb.Config.Users {
+	setDavHandlers(fb.Config, fb.Config.Users)
+}
+func setDavHandlers(cfg *config.GlobalConfig, users []*config.UserConfig) {
+	for _, u := range users {
 		u.DavHandler = &webdav.Handler{
-			FileSystem: webdav.Dir(fb.Config.GetDavPath(u.Username)),
-			LockSystem: ramLock,
+			FileSystem: &quotaFS{
+				FileSystem: &rulesFS{FileSystem: webdav.Dir(cfg.GetDavPath(u.Username)), cfg: cfg, username: u.Username},
+				cfg:        cfg,
+				username:   u.Username,
+			},
+			LockSystem: davLocks,
 			Logger:     config.DavLogger,
 		}
 	}
 }
+
+//re-read config file and apply it to the running file browser, in case of error running config stays untouched
+func ReloadConfig(fb *lib.FileBrowser) error {
+	needUpd := false
+	changed, err := fb.Config.ReloadConfigFile(func(n *config.GlobalConfig) error {
+		needUpd = lib.HashFirstRun(fb.Config, n.Users)
+		setDavHandlers(fb.Config, n.Users)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	res := applySettings(fb, changed)
+	if len(res.Restart) > 0 {
+		log.Println("config : restart required to apply", res.Restart)
+	}
+	if needUpd {
+		fb.Config.WriteConfig()
+	}
+	return nil
+}
+
+//settings keys changed by update
+type SettingsChange struct {
+	//picked up by running server
+	Applied []string `json:"applied"`
+	//will be used after restart
+	Restart []string `json:"restart"`
+}
+
+//apply changed settings to the running parts of file browser
+func applySettings(fb *lib.FileBrowser, changed []string) *SettingsChange {
+	res := &SettingsChange{Applied: []string{}, Restart: []string{}}
+	var listen []string
+	for _, k := range changed {
+		switch {
+		case k == "http.authMethod" || k == "https.authMethod":
+			//resolved per request
+			res.Applied = append(res.Applied, k)
+		case strings.HasPrefix(k, "http.") || strings.HasPrefix(k, "https.") || k == "tlsCert" || k == "tlsKey" || k == "tlsSelfSigned":
+			listen = append(listen, k)
+		case k == "filesPath":
+			//config already moved user paths, dav handlers keep old ones
+			setDavHandlers(fb.Config, fb.Config.Users)
+			res.Applied = append(res.Applied, k)
+		case strings.HasPrefix(k, "preview."):
+			fb.Pgen.Reconfigure(fb.Config.Threads, fb.Config.ScriptPath)
+			res.Applied = append(res.Applied, k)
+		case strings.HasPrefix(k, "captchaConfig."):
+			fb.ReCaptcha = &lib.ReCaptcha{
+				Host:   fb.Config.CaptchaConfig.Host,
+				Key:    fb.Config.CaptchaConfig.Key,
+				Secret: fb.Config.CaptchaConfig.Secret,
+			}
+			res.Applied = append(res.Applied, k)
+		default:
+			//read from config on use
+			res.Applied = append(res.Applied, k)
+		}
+	}
+	if len(listen) > 0 {
+		if fb.Rebind == nil {
+			res.Restart = append(res.Restart, listen...)
+		} else if err := fb.Rebind(); err != nil {
+			log.Println("server : rebind failed", err)
+			res.Restart = append(res.Restart, listen...)
+		} else {
+			res.Applied = append(res.Applied, listen...)
+		}
+	}
+	return res
+}
@@ -8,8 +8,8 @@ import (
 	"html/template"
 	"log"
 	"net/http"
-
 	"strings"
+	"time"
 )
 
 // Handler returns a function compatible with web.HandleFunc.
@@ -22,7 +22,16 @@ func Handler(m *fb.FileBrowser) http.Handler {
 			Params:      new(fb.Params),
 		}
 		c.REQ = r
-		c.RESP = w
+		r.URL.Path = stripBasePath(c)
+		start, path := time.Now(), r.URL.Path
+		var cr *countingReader
+		if r.Body != nil {
+			cr = &countingReader{ReadCloser: r.Body}
+			r.Body = cr
+		}
+		cw := &countingWriter{ResponseWriter: w}
+		defer observe(c, path, start, cr, cw)
+		c.RESP = cw
 		c.Method = c.REQ.Method
 		code, err := serve(c)
 
@@ -36,12 +45,37 @@ func Handler(m *fb.FileBrowser) http.Handler {
 			}
 		}
 
-		w.WriteHeader(code)
+		cw.WriteHeader(code)
 	})
 }
 
+//cut base path from request path, proxy may strip it already
+func stripBasePath(c *fb.Context) string {
+	p := c.REQ.URL.Path
+	_, cfgPath := c.Config.GetBaseURL()
+	for _, base := range []string{c.BasePath(), cfgPath} {
+		if len(base) > 0 && (p == base || strings.HasPrefix(p, base+"/")) {
+			p = strings.TrimPrefix(p, base)
+			break
+		}
+	}
+	if len(p) == 0 {
+		p = "/"
+	}
+	return p
+}
+
 // serve is the main entry point of this HTML application.
 func serve(c *fb.Context) (int, error) {
+	//probes and monitoring, no auth
+	switch c.REQ.URL.Path {
+	case "/healthz":
+		return healthHandler(c)
+	case "/readyz":
+		return readyHandler(c)
+	case "/metrics":
+		return metricsHandler(c)
+	}
 	// Checks if this request is made to the static assets folder. If so, and
 	// if it is a GET request, returns with the asset. Otherwise, returns
 	// a status not implemented.
@@ -97,12 +131,39 @@ func apiHandler(c *fb.Context) (code int, err error) {
 	if !valid {
 		return http.StatusForbidden, nil
 	}
+	if strings.HasPrefix(c.REQ.URL.Path, "/auth/") {
+		//api token and signed url can't manage credentials
+		if c.APIToken != nil || c.Signed {
+			return http.StatusForbidden, nil
+		}
+		return authSettingsHandler(c)
+	}
+	//admin has to enroll two factor first
+	if c.Config.NeedsTwoFactor(c.User.UserConfig) {
+		return http.StatusForbidden, cnst.ErrTwoFactorRequired
+	}
 	isShares := ProcessParams(c)
+	if c.APIToken != nil {
+		if !apiTokenAllowed(c) {
+			return http.StatusForbidden, nil
+		}
+		//long-lived token must not go into links
+		c.Auth = ""
+	}
+	//signature opens downloads only
+	if c.Signed && c.Router != cnst.R_DOWNLOAD {
+		return http.StatusForbidden, nil
+	}
+	//forced password change, only users api left to the user
+	if c.User.MustChangePassword && c.Router != cnst.R_USERS {
+		return http.StatusForbidden, cnst.ErrPasswordChange
+	}
 	//allow only GET requests, for external share
 	if valid && c.User.IsGuest() && (!isShares ||
 		!strings.EqualFold(c.Method, http.MethodGet) ||
 		c.Router == cnst.R_RESOURCE ||
 		c.Router == cnst.R_USERS ||
+		c.Router == cnst.R_GROUPS ||
 		c.Router == cnst.R_SETTINGS) {
 		return http.StatusForbidden, nil
 	}
@@ -126,6 +187,8 @@ func apiHandler(c *fb.Context) (code int, err error) {
 		code, err = resourceHandler(c)
 	case cnst.R_USERS:
 		code, err = usersHandler(c)
+	case cnst.R_GROUPS:
+		code, err = groupsHandler(c)
 	case cnst.R_SETTINGS:
 		code, err = settingsHandler(c)
 	case cnst.R_SHARES:
@@ -138,19 +201,6 @@ func apiHandler(c *fb.Context) (code int, err error) {
 	default:
 		code = http.StatusNotFound
 	}
-	if (c.Router == cnst.R_SETTINGS ||
-		c.Router == cnst.R_USERS ||
-		c.Router == cnst.R_RESOURCE ||
-		c.Router == cnst.R_SHARES) &&
-		c.Method == http.MethodPatch ||
-		c.Method == http.MethodPut ||
-		c.Method == http.MethodPost ||
-		c.Method == http.MethodDelete {
-		if c.Config != nil {
-			c.Config.WriteConfig()
-		}
-
-	}
 
 	return code, err
 }
@@ -167,13 +217,15 @@ func renderFile(c *fb.Context, file string) (int, error) {
 	isEx := len(c.RootHash) > 0
 	c.RESP.Header().Set("Content-Type", contentType+"; charset=utf-8")
 	cfgM := c.GetAuthConfig()
+	base := c.BasePath()
 
 	data := map[string]interface{}{
 		"Name":            "Browsefile",
 		"DisableExternal": false,
 		"Version":         cnst.Version,
 		"isExternal":      isEx,
-		"StaticURL":       "/static",
+		"BaseURL":         base,
+		"StaticURL":       base + "/static",
 		"Signup":          false,
 		"NoAuth":          strings.ToLower(cfgM.AuthMethod) == "noauth" || strings.ToLower(cfgM.AuthMethod) == "ip",
 		"ReCaptcha":       c.ReCaptcha.Key != "" && c.ReCaptcha.Secret != "",
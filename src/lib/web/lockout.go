@@ -0,0 +1,39 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	fb "github.com/browsefile/backend/src/lib"
+)
+
+//list and clear login lockouts, admin only. DELETE /auth/lockouts/ip/<ip> or /auth/lockouts/user/<name>, without key clears all
+func lockoutsHandler(c *fb.Context, key string) (int, error) {
+	if !c.User.Admin || c.Config.NeedsTwoFactor(c.User.UserConfig) {
+		return http.StatusForbidden, nil
+	}
+	switch c.Method {
+	case http.MethodGet:
+		res := fb.Lockouts()
+		if res == nil {
+			res = []*fb.Lockout{}
+		}
+		return renderJSON(c.RESP, res)
+	case http.MethodDelete:
+		var kind, name string
+		if len(key) > 0 {
+			p := strings.SplitN(key, "/", 2)
+			if len(p) != 2 || p[0] != fb.LockIP && p[0] != fb.LockUser {
+				return http.StatusBadRequest, nil
+			}
+			kind, name = p[0], p[1]
+		}
+		if !fb.ClearLockout(kind, name) {
+			return http.StatusNotFound, nil
+		}
+		log.Printf("auth : lockout %s cleared by %s\n", strings.TrimSuffix(kind+":"+name, ":"), c.User.Username)
+		return http.StatusOK, nil
+	}
+	return http.StatusMethodNotAllowed, nil
+}
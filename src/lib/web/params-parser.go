@@ -5,6 +5,8 @@ import (
 	fb "github.com/browsefile/backend/src/lib"
 	"github.com/browsefile/backend/src/lib/utils"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
 	"strings"
 )
@@ -20,6 +22,7 @@ func ProcessParams(c *fb.Context) (isShares bool) {
 	c.Order = c.Query.Get("order")
 	c.PreviewType = c.Query.Get(cnst.P_PREVIEW_TYPE)
 	c.Inline, _ = strconv.ParseBool(c.Query.Get("inline"))
+	c.Redirect, _ = strconv.ParseBool(c.Query.Get("redirect"))
 	c.RootHash = c.Query.Get(cnst.P_ROOTHASH)
 	c.Checksum = c.Query.Get("checksum")
 	c.ShareType = c.Query.Get("share")
@@ -65,10 +68,11 @@ func ProcessParams(c *fb.Context) (isShares bool) {
 			c.FilePaths = strings.Split(f, ",")
 		}
 	} else if c.REQ.Method == http.MethodPatch {
-		c.Destination = c.REQ.Header.Get("Destination")
-		if len(c.Destination) == 0 {
-			c.Destination = c.Query.Get("destination")
+		d := c.REQ.Header.Get("Destination")
+		if len(d) == 0 {
+			d = c.Query.Get("destination")
 		}
+		c.Destination = cleanDestination(d)
 		c.Action = c.REQ.Header.Get("action")
 		if len(c.Action) == 0 {
 			c.Action = c.Query.Get("action")
@@ -82,6 +86,18 @@ func ProcessParams(c *fb.Context) (isShares bool) {
 	return
 
 }
+//unescaped and cleaned destination path, so it checked and used in the same form. Empty in case broken
+func cleanDestination(d string) string {
+	if len(d) == 0 {
+		return ""
+	}
+	d, err := url.QueryUnescape(d)
+	if err != nil {
+		return ""
+	}
+	return path.Clean("/" + d)
+}
+
 func setFileType(c *fb.Context, t string) {
 	c.Image = strings.Contains(t, "i")
 	c.Audio = strings.Contains(t, "a")
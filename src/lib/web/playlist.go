@@ -2,6 +2,7 @@ package web
 
 import (
 	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
 	"github.com/browsefile/backend/src/lib"
 	"github.com/browsefile/backend/src/lib/utils"
 	"github.com/maruel/natural"
@@ -9,13 +10,15 @@ import (
 	"net/http"
 	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
 )
 
 //generates m3u playlist
 func makePlaylist(c *lib.Context) (int, error) {
 	var err error
+	if !c.User.Can(config.PermDownload) {
+		return http.StatusForbidden, nil
+	}
 
 	if len(c.FilePaths) == 0 {
 		return http.StatusNotFound, err
@@ -32,9 +35,11 @@ func makePlaylist(c *lib.Context) (int, error) {
 		return code, err
 	}
 	sort.Sort(sort.Reverse(byName(c.FilePaths)))
-	h := getHost(c)
+	h, prefix := getHost(c)
 	for _, p := range c.FilePaths {
-		serveFileAsUrl(c, filepath.Base(p), p, h)
+		if err = serveFileAsUrl(c, filepath.Base(p), prefix+p, h); err != nil {
+			return http.StatusInternalServerError, err
+		}
 	}
 
 	return code, nil
@@ -46,8 +51,12 @@ func fitMediaFilter(c *lib.Context, t string) bool {
 		c.Image && strings.EqualFold(t, cnst.IMAGE)
 }
 
-//write specific m3u tags into response
-func serveFileAsUrl(c *lib.Context, fName, p, host string) {
+//write specific m3u tags into response, p is api path of the file. Link signed for the file only
+func serveFileAsUrl(c *lib.Context, fName, p, host string) error {
+	q, err := lib.SignedQuery(c.Config, c.User.Username, p, "", c.RootHash, playlistLinkTTL)
+	if err != nil {
+		return err
+	}
 
 	io.WriteString(c.RESP, "#EXTINF:0 tvg-name=")
 	io.WriteString(c.RESP, fName)
@@ -55,46 +64,26 @@ func serveFileAsUrl(c *lib.Context, fName, p, host string) {
 	io.WriteString(c.RESP, host)
 	io.WriteString(c.RESP, p)
 	io.WriteString(c.RESP, "?inline=true")
-
-	if c.IsExternalShare() {
-		io.WriteString(c.RESP, "&")
-		io.WriteString(c.RESP, cnst.P_ROOTHASH)
-		io.WriteString(c.RESP, "="+c.RootHash)
-	}
-	if len(c.Auth) > 0 {
-		io.WriteString(c.RESP, "&auth="+c.Auth)
-	}
+	//signed query carries rootHash of external share
+	io.WriteString(c.RESP, "&"+q.Encode())
 
 	io.WriteString(c.RESP, "\r\n")
-
+	return nil
 }
 
-//returns correct URL for playlist link in file
-func getHost(c *lib.Context) string {
+//returns correct URL of api for playlist link in file, and api path of download router
+func getHost(c *lib.Context) (string, string) {
 	var h string
-	if c.IsExternalShare() {
+	if c.IsExternalShare() && len(c.Config.ExternalShareHost) > 0 {
 		h = strings.TrimSuffix(c.Config.ExternalShareHost, "/")
 	} else {
-		if c.REQ.TLS == nil {
-			h = c.Config.Http.IP + ":" + strconv.Itoa(c.Config.Http.Port)
-		} else {
-			h = c.Config.Tls.IP + ":" + strconv.Itoa(c.Config.Tls.Port)
-		}
+		h = c.PublicURL()
 	}
 
 	if c.IsShare {
-		h += "/api/shares/download"
-	} else {
-		h += "/api/download"
-	}
-	if !c.IsExternalShare() {
-		if c.REQ.TLS != nil {
-			h = "https://" + h
-		} else {
-			h = "http://" + h
-		}
+		return h + "/api", "/shares/download"
 	}
-	return h
+	return h + "/api", "/download"
 }
 
 type byName []string
@@ -62,6 +62,52 @@ func TestPlaylistOnExternalShareDirParent(t *testing.T) {
 	testPlaylistOnDir(&cfg, t, true, map[string]interface{}{cnst.P_ROOTHASH: shr.Hash, "u": p, "files": []string{p}}, 9)
 }
 
+func TestPlaylistBehindProxy(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	cfg.BaseURL = "/files"
+	cfg.TrustedProxies = "127.0.0.1, ::1"
+	params := map[string]interface{}{"u": "/", "files": []string{cfg.SharePathDeep + "/t.png"}}
+	//proxy strips base path
+	_, rs, _ := cfg.MakeRequest(cnst.R_PLAYLIST, params, cfg.GetAdmin(), t, false)
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("wrong status", rs.StatusCode)
+	}
+	get := func(headers map[string]string) string {
+		req, _ := http.NewRequest(http.MethodGet, "", nil)
+		req.URL = cfg.BuildUrl(cnst.R_PLAYLIST, params, false)
+		req.URL.Path = "/files" + req.URL.Path
+		req.Header.Set(cnst.H_XAUTH, cfg.Token)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rs, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Body.Close()
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("wrong status", rs.StatusCode)
+		}
+		b, _ := ioutil.ReadAll(rs.Body)
+		return string(b)
+	}
+	forwarded := map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "[2001:db8::1]:8443"}
+	if l := get(forwarded); !strings.Contains(l, "https://[2001:db8::1]:8443/files/api/download/") {
+		t.Fatal("forwarded host and base path not used", l)
+	}
+	forwarded["X-Forwarded-Prefix"] = "/browse/"
+	if l := get(forwarded); !strings.Contains(l, "https://[2001:db8::1]:8443/browse/api/download/") {
+		t.Fatal("forwarded prefix not used", l)
+	}
+	//headers of not trusted proxy ignored
+	cfg.TrustedProxies = "10.0.0.1"
+	if l := get(forwarded); !strings.Contains(l, cfg.Srv.URL+"/files/api/download/") {
+		t.Fatal("not trusted forwarded headers used", l)
+	}
+}
+
 func testPlaylistOnDir(cfg *TServContext, t *testing.T, isShare bool, data map[string]interface{}, lCount int) {
 	_, rs, _ := cfg.MakeRequest(cnst.R_PLAYLIST, data, cfg.GetAdmin(), t, isShare)
 
@@ -93,8 +139,17 @@ func validatePlaylistLinks(cfg *TServContext, links string, t *testing.T, count
 		if len(arr[i]) > 0 {
 			arr[i] = strings.ReplaceAll(arr[i], "\r", "")
 			arr[i] = strings.ReplaceAll(arr[i], strconv.Itoa(cfg.GlobalConfig.Http.Port), p)
-			if !cfg.ValidateDownloadLink(arr[i], t) {
-				t.Error("not valid url ", arr[i])
+			//signed links work without token
+			if strings.Contains(arr[i], "auth=") || !strings.Contains(arr[i], cnst.P_SIG+"=") {
+				t.Error("link must be signed instead of carry token", arr[i])
+			}
+			rs, err := http.Get(arr[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = rs.Body.Close()
+			if rs.StatusCode != http.StatusOK {
+				t.Error("not valid url ", arr[i], rs.StatusCode)
 			}
 		}
 
@@ -11,10 +11,11 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 var (
@@ -84,6 +85,9 @@ func resourceGetHandler(c *fb.Context) (int, error) {
 		return renderJSON(c.RESP, f)
 	}
 
+	if !c.Allowed(c.URL, utils.AccessRead) {
+		return http.StatusForbidden, nil
+	}
 	// Tries to get the file type.
 
 	// If the file type is text, save its content.
@@ -103,7 +107,7 @@ func resourceGetHandler(c *fb.Context) (int, error) {
 	// Serve a preview if the file can't be edited or the
 	// user has no permission to edit this file. Otherwise,
 	// just serve the editor.
-	if !f.CanBeEdited() || !c.User.AllowEdit {
+	if !f.CanBeEdited() || !c.User.Can(config.PermOverwrite) {
 		f.Kind = "preview"
 		return renderJSON(c.RESP, f)
 	}
@@ -121,7 +125,7 @@ func listingHandler(c *fb.Context) (int, error) {
 		return cnst.ErrorToHTTP(err, true), err
 	}
 	// Copy the query values into the Listing struct
-	if err := HandleSortOrder(c, "/"); err == nil {
+	if err := HandleSortOrder(c, c.BasePath()+"/"); err == nil {
 		c.File.Listing.Sort = c.Sort
 		c.File.Listing.Order = c.Order
 	} else {
@@ -136,10 +140,11 @@ func listingHandler(c *fb.Context) (int, error) {
 
 func resourceDeleteHandler(c *fb.Context) (int, error) {
 	// Prevent the removal of the root directory.
-	if c.URL == "/" || !c.User.AllowEdit {
+	if c.URL == "/" || !c.User.Can(config.PermDelete) {
 		return http.StatusForbidden, nil
 	}
 	removePreview(c)
+	size := fb.DiskUsage(filepath.Join(c.GetUserHomePath(), c.URL))
 
 	// Remove the file or folder.
 	err := c.User.FileSystem.RemoveAll(c.URL)
@@ -147,6 +152,7 @@ func resourceDeleteHandler(c *fb.Context) (int, error) {
 	if err != nil {
 		return cnst.ErrorToHTTP(err, true), err
 	}
+	fb.AddUsedSpace(c.Config, c.User.Username, -size)
 	//delete share
 	for _, itm := range findShare(c.User.UserConfig, c.URL) {
 
@@ -210,14 +216,6 @@ func modPreview(c *fb.Context, src, dst string, isCopy bool) {
 }
 
 func resourcePostPutHandler(c *fb.Context) (int, error) {
-	if !c.User.AllowNew && c.Method == http.MethodPost {
-		return http.StatusForbidden, nil
-	}
-
-	if !c.User.AllowEdit && c.Method == http.MethodPut {
-		return http.StatusForbidden, nil
-	}
-
 	// Discard any invalid upload before returning to avoid connection
 	// reset error.
 	defer func() {
@@ -231,6 +229,9 @@ func resourcePostPutHandler(c *fb.Context) (int, error) {
 		if c.Method == http.MethodPut {
 			return http.StatusMethodNotAllowed, nil
 		}
+		if !c.User.Can(config.PermMkdir) {
+			return http.StatusForbidden, nil
+		}
 
 		// Otherwise we try to create the directory.
 		err := c.User.FileSystem.Mkdir(c.URL, cnst.PERM_DEFAULT, c.User.UID, c.User.GID)
@@ -250,26 +251,53 @@ func resourcePostPutHandler(c *fb.Context) (int, error) {
 	// If using POST method, we are trying to create a new file so it is not
 	// desirable to override an already existent file. Thus, we check
 	// if the file already exists. If so, we just return a 409 Conflict.
-	if c.Method == http.MethodPost && !c.Override {
-		if _, err := c.User.FileSystem.Stat(c.URL); err == nil {
-			return http.StatusConflict, errors.New("There is already a file on that path")
-		}
+	inf, statErr := c.User.FileSystem.Stat(c.URL)
+	exists := statErr == nil
+	if c.Method == http.MethodPost && !c.Override && exists {
+		return http.StatusConflict, errors.New("There is already a file on that path")
 	}
-	// Create/Open the file.
-	f, err := c.User.FileSystem.OpenFile(c.URL, os.O_RDWR|os.O_CREATE|os.O_TRUNC, cnst.PERM_DEFAULT, c.User.UID, c.User.GID)
-	if err != nil {
+	if exists && !c.User.Can(config.PermOverwrite) || !exists && !c.User.Can(config.PermUpload) {
+		return http.StatusForbidden, nil
+	}
+	//size of replaced file, freed by upload
+	var old int64
+	if exists && !inf.IsDir() {
+		old = inf.Size()
+	}
+	size := c.REQ.ContentLength
+	if size < 0 {
+		size = 0
+	}
+	//replaced file freed, once upload done
+	if err := fb.ReserveQuota(c.Config, c.User.UserConfig, size-old); err != nil {
 		return cnst.ErrorToHTTP(err, false), err
 	}
-	defer f.Close()
-
-	// Copies the new content for the file.
-	_, err = io.Copy(f, c.REQ.Body)
+	//content length may be unknown or wrong, so bytes over it reserved on write
+	w := &fb.QuotaWriter{Cfg: c.Config, User: c.User.UserConfig, Reserved: size}
+	//upload goes to temp file, so failed upload never damages existing file
+	tmp := path.Join(path.Dir(c.URL), fmt.Sprintf(".%s.upload-%d", path.Base(c.URL), time.Now().UnixNano()))
+	f, err := c.User.FileSystem.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, cnst.PERM_DEFAULT, c.User.UID, c.User.GID)
+	if err == nil {
+		w.Writer = f
+		_, err = io.Copy(w, c.REQ.Body)
+		if cErr := f.Close(); err == nil {
+			err = cErr
+		}
+		if err == nil {
+			err = c.User.FileSystem.Rename(tmp, c.URL)
+		}
+		if err != nil {
+			_ = c.User.FileSystem.RemoveAll(tmp)
+		}
+	}
 	if err != nil {
+		fb.AddUsedSpace(c.Config, c.User.Username, old-w.Reserved)
 		return cnst.ErrorToHTTP(err, false), err
 	}
+	fb.AddUsedSpace(c.Config, c.User.Username, w.Written-w.Reserved)
 
 	// GetUsers the info about the file.
-	fi, err := f.Stat()
+	fi, err := c.User.FileSystem.Stat(c.URL)
 	if err != nil {
 		return cnst.ErrorToHTTP(err, false), err
 	}
@@ -293,24 +321,40 @@ func resourcePostPutHandler(c *fb.Context) (int, error) {
 
 // resourcePatchHandler is the entry point for resource handler.
 func resourcePatchHandler(c *fb.Context) (int, error) {
-	if !c.User.AllowEdit {
-		return http.StatusForbidden, nil
-	}
-	dst, err := url.QueryUnescape(c.Destination)
-	if err != nil {
-		return cnst.ErrorToHTTP(err, true), err
+	dst := c.Destination
+	if len(dst) == 0 {
+		return http.StatusBadRequest, cnst.ErrInvalidOption
 	}
+	var err error
 	action := c.Action
 	src := c.URL
 
 	if dst == "/" || src == "/" {
 		return http.StatusForbidden, nil
 	}
+	//copy creates new files, rename moves existing
+	perm := config.PermRename
+	if action == "copy" {
+		perm = config.PermUpload
+	}
+	if !c.User.Can(perm) {
+		return http.StatusForbidden, nil
+	}
+	if _, err := c.User.FileSystem.Stat(dst); err == nil && !c.User.Can(config.PermOverwrite) {
+		return http.StatusForbidden, nil
+	}
 
 	if action == "copy" {
+		size := fb.DiskUsage(filepath.Join(c.GetUserHomePath(), src))
+		if err = fb.ReserveQuota(c.Config, c.User.UserConfig, size); err != nil {
+			return cnst.ErrorToHTTP(err, true), err
+		}
 		modPreview(c, src, dst, true)
 		// Copy the file.
 		err = c.User.FileSystem.Copy(src, dst, c.User.UID, c.User.GID)
+		if err != nil {
+			fb.AddUsedSpace(c.Config, c.User.Username, -size)
+		}
 
 	} else {
 		modPreview(c, src, dst, false)
@@ -1,11 +1,18 @@
 package web
 
 import (
+	"archive/zip"
 	"bytes"
+	"encoding/json"
 	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	fb "github.com/browsefile/backend/src/lib"
+	"github.com/browsefile/backend/src/lib/utils"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -109,3 +116,199 @@ func TestResourceUpload(t *testing.T) {
 	}
 
 }
+
+func TestResourceQuota(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	used := fb.UsedSpace(cfg.GlobalConfig, cfg.Usr1.Username)
+	cfg.Usr1.Quota = used + 20
+	_ = cfg.Update(cfg.Usr1)
+	upload := func(u, content string) int {
+		dat := map[string]interface{}{"u": u, "method": http.MethodPost, "body": bytes.NewBufferString(content)}
+		_, rs, _ := cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false)
+		return rs.StatusCode
+	}
+	if code := upload("/q1.txt", "0123456789"); code != http.StatusOK {
+		t.Fatal("upload within quota failed", code)
+	}
+	if code := upload("/q2.txt", strings.Repeat("x", 11)); code != http.StatusInsufficientStorage {
+		t.Fatal("upload over quota must be rejected", code)
+	}
+	if _, err := cfg.User1FS.Stat("/q2.txt"); err == nil {
+		t.Fatal("rejected upload must not be kept")
+	}
+	//overwrite of unknown length over quota keeps existing file
+	req, _ := http.NewRequest(http.MethodPut, cfg.Srv.URL+"/api/resource/q1.txt", io.MultiReader(strings.NewReader(strings.Repeat("y", 21))))
+	req.Header.Set(cnst.H_XAUTH, cfg.Token)
+	if rs, err := http.DefaultClient.Do(req); err != nil || rs.StatusCode != http.StatusInsufficientStorage {
+		t.Fatal("overwrite over quota must be rejected", err, rs)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(cfg.GetUserHomePath(cfg.Usr1.Username), "q1.txt")); string(b) != "0123456789" {
+		t.Fatal("rejected overwrite damaged file", string(b))
+	}
+	infos, _ := ioutil.ReadDir(cfg.GetUserHomePath(cfg.Usr1.Username))
+	for _, inf := range infos {
+		if strings.Contains(inf.Name(), ".upload-") {
+			t.Fatal("temp file left", inf.Name())
+		}
+	}
+	if fb.UsedSpace(cfg.GlobalConfig, cfg.Usr1.Username) != used+10 {
+		t.Fatal("usage not updated", fb.UsedSpace(cfg.GlobalConfig, cfg.Usr1.Username))
+	}
+	//copy
+	dat := map[string]interface{}{"u": "/q1.txt", "method": http.MethodPatch, "destination": "/q3.txt", "action": "copy"}
+	_, rs, _ := cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false)
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("copy within quota failed", rs.StatusCode)
+	}
+	dat["destination"] = "/q4.txt"
+	_, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false)
+	if rs.StatusCode != http.StatusInsufficientStorage {
+		t.Fatal("copy over quota must be rejected", rs.StatusCode)
+	}
+	//dav write
+	req, _ = http.NewRequest(http.MethodPut, cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/q5.txt", strings.NewReader("xx"))
+	req.SetBasicAuth(cfg.Usr1.Username, "1")
+	if rs, err := http.DefaultClient.Do(req); err != nil || rs.StatusCode != http.StatusInsufficientStorage {
+		t.Fatal("dav write over quota must be rejected", err, rs)
+	}
+	//delete frees space, usage reported by users api
+	dat = map[string]interface{}{"u": "/q3.txt", "method": http.MethodDelete}
+	_, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false)
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("delete failed", rs.StatusCode)
+	}
+	_, rs, _ = cfg.MakeRequest(cnst.R_USERS, map[string]interface{}{"u": "/" + cfg.Usr1.Username}, cfg.GetAdmin(), t, false)
+	var info fb.QuotaInfo
+	if err := json.NewDecoder(rs.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Used != used+10 || info.Limit != used+20 {
+		t.Fatal("wrong quota info", info)
+	}
+}
+
+func TestResourcePermissions(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	//upload only
+	cfg.Usr1.Perms = &config.Permissions{Upload: true}
+	_ = cfg.Update(cfg.Usr1)
+	//builds dav folders
+	cfg.Flush()
+	cfg.ReadConfigFile()
+	cfg.Usr1, _ = cfg.GetUserByUsername(cfg.Usr1.Username)
+	do := func(dat map[string]interface{}) int {
+		_, rs, _ := cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false)
+		return rs.StatusCode
+	}
+	if code := do(map[string]interface{}{"u": "/p.txt", "method": http.MethodPost, "body": bytes.NewBufferString("1")}); code != http.StatusOK {
+		t.Fatal("upload must be allowed", code)
+	}
+	denied := []map[string]interface{}{
+		{"u": "/p.txt", "method": http.MethodPut, "body": bytes.NewBufferString("2"), "override": "true"},
+		{"u": "/pdir/", "method": http.MethodPost},
+		{"u": "/p.txt", "method": http.MethodDelete},
+		{"u": "/p.txt", "method": http.MethodPatch, "destination": "/p2.txt", "action": "rename"},
+	}
+	for _, dat := range denied {
+		if code := do(dat); code != http.StatusForbidden {
+			t.Fatal("must be forbidden", dat["method"], dat["u"], code)
+		}
+	}
+	_, rs, _ := cfg.MakeRequest(cnst.R_DOWNLOAD, map[string]interface{}{"u": "/p.txt"}, cfg.Usr1, t, false)
+	if rs.StatusCode != http.StatusForbidden {
+		t.Fatal("download must be forbidden", rs.StatusCode)
+	}
+	//dav
+	req, _ := http.NewRequest("MKCOL", cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/pdir", nil)
+	req.SetBasicAuth(cfg.Usr1.Username, "1")
+	if rs, err := http.DefaultClient.Do(req); err != nil || rs.StatusCode != http.StatusForbidden {
+		t.Fatal("dav mkcol must be forbidden", err, rs)
+	}
+	req, _ = http.NewRequest(http.MethodPut, cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/p3.txt", strings.NewReader("3"))
+	req.SetBasicAuth(cfg.Usr1.Username, "1")
+	if rs, err := http.DefaultClient.Do(req); err != nil || rs.StatusCode != http.StatusCreated {
+		t.Fatal("dav upload must be allowed", err, rs)
+	}
+}
+
+func TestResourceRules(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	home := cfg.User1FS.String()
+	for _, p := range []string{"/acl/archive/a.txt", "/acl/private/secret.txt", "/acl/open.txt"} {
+		_ = os.MkdirAll(filepath.Dir(home+p), cnst.PERM_DEFAULT)
+		_ = ioutil.WriteFile(home+p, []byte("data"), cnst.PERM_DEFAULT)
+	}
+	cfg.Usr1.Rules = utils.Rules{
+		{Path: "/acl/archive", Deny: []string{utils.AccessWrite}},
+		{Path: "^/acl/priv[a-z]+$", Regex: true, Deny: []string{utils.AccessRead, utils.AccessWrite, utils.AccessList}},
+	}
+	_ = cfg.Update(cfg.Usr1)
+	cfg.Flush()
+	cfg.ReadConfigFile()
+	cfg.Usr1, _ = cfg.GetUserByUsername(cfg.Usr1.Username)
+
+	_, rs, _ := cfg.MakeRequest(cnst.R_RESOURCE, map[string]interface{}{"u": "/acl/"}, cfg.Usr1, t, false)
+	f := ValidateListingResp(rs, t, 2)
+	for _, itm := range f.Items {
+		if itm.Name == "private" {
+			t.Fatal("denied folder listed")
+		}
+	}
+	_, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, map[string]interface{}{"u": "/acl/private/secret.txt"}, cfg.Usr1, t, false)
+	if rs.StatusCode != http.StatusNotFound {
+		t.Fatal("hidden file must not exist", rs.StatusCode)
+	}
+	dat := map[string]interface{}{"u": "/acl/archive/b.txt", "method": http.MethodPost, "body": bytes.NewBufferString("b")}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Fatal("read only folder must not be writable", rs.StatusCode)
+	}
+	dat = map[string]interface{}{"u": "/acl", "method": http.MethodDelete}
+	if _, rs, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false); rs.StatusCode != http.StatusForbidden {
+		t.Fatal("folder with denied entries must not be removed", rs.StatusCode)
+	}
+	_, rs, _ = cfg.MakeRequest(cnst.R_SEARCH, map[string]interface{}{"u": "/acl", "query": "secret"}, cfg.Usr1, t, false)
+	ValidateListingResp(rs, t, 0)
+	//zip
+	_, rs, _ = cfg.MakeRequest(cnst.R_DOWNLOAD, map[string]interface{}{"u": "/acl/"}, cfg.Usr1, t, false)
+	body, _ := ioutil.ReadAll(rs.Body)
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 {
+		t.Fatal("wrong zip entries", len(zr.File))
+	}
+	for _, zf := range zr.File {
+		if strings.Contains(zf.Name, "secret") {
+			t.Fatal("denied file zipped")
+		}
+	}
+	//dav
+	req, _ := http.NewRequest(http.MethodGet, cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/acl/private/secret.txt", nil)
+	req.SetBasicAuth(cfg.Usr1.Username, "1")
+	if rs, err := http.DefaultClient.Do(req); err != nil || rs.StatusCode != http.StatusNotFound {
+		t.Fatal("dav must hide denied file", err, rs)
+	}
+	req, _ = http.NewRequest("PROPFIND", cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/acl/", nil)
+	req.Header.Set("Depth", "1")
+	req.SetBasicAuth(cfg.Usr1.Username, "1")
+	rs, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(rs.Body)
+	if strings.Contains(string(body), "private") || !strings.Contains(string(body), "archive") {
+		t.Fatal("wrong dav listing", string(body))
+	}
+	req, _ = http.NewRequest(http.MethodPut, cfg.Srv.URL+cnst.WEB_DAV_URL+"/files/acl/archive/c.txt", strings.NewReader("c"))
+	req.SetBasicAuth(cfg.Usr1.Username, "1")
+	if rs, err := http.DefaultClient.Do(req); err != nil || rs.StatusCode != http.StatusForbidden {
+		t.Fatal("dav write to read only folder", err, rs)
+	}
+}
@@ -0,0 +1,286 @@
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"github.com/browsefile/backend/src/lib"
+	"github.com/browsefile/backend/src/lib/cli"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//runs file browser over http and https listeners from the config, and stops it gracefully
+type Server struct {
+	*lib.FileBrowser
+	srv *http.Server
+	//closed on shutdown, stops config watcher
+	quit chan struct{}
+	//admin commands from cli
+	admin net.Listener
+	//guards listeners
+	lock sync.Mutex
+	//bound listeners by config section and listen spec address, like https tcp://:443
+	listeners map[string]*boundListener
+	//serve errors of active listeners
+	errs chan error
+	//tls certificate, reloaded from disk
+	certs     *certLoader
+	tlsConfig *tls.Config
+}
+
+//listener with listen spec it was bound by
+type boundListener struct {
+	lib.Listener
+	network, address string
+	//serves https, certificate taken from loader
+	tls bool
+	l   net.Listener
+}
+
+//passes listen spec to requests context
+type specListener struct {
+	net.Listener
+	info *lib.Listener
+}
+
+func NewServer(cfg *config.GlobalConfig) *Server {
+	fb := NewFileBrowser(cfg)
+	s := &Server{
+		FileBrowser: fb,
+		srv:         &http.Server{Handler: Handler(fb), ReadTimeout: 5 * time.Hour, WriteTimeout: 5 * time.Hour},
+		quit:        make(chan struct{}),
+		listeners:   make(map[string]*boundListener),
+		errs:        make(chan error, 1),
+		certs:       newCertLoader(cfg),
+	}
+	s.tlsConfig = &tls.Config{GetCertificate: s.certs.GetCertificate, NextProtos: []string{"h2", "http/1.1"}}
+	s.srv.BaseContext = func(l net.Listener) context.Context {
+		if sl, ok := l.(*specListener); ok {
+			return lib.WithListener(context.Background(), sl.info)
+		}
+		return context.Background()
+	}
+	fb.Rebind = s.Rebind
+	return s
+}
+
+//apply external config file edits, bad edit logged and ignored
+func (s *Server) Reload() {
+	if err := ReloadConfig(s.FileBrowser); err != nil {
+		log.Println("server : config reload failed, keep running config", err)
+	}
+}
+
+//opens listeners and blocks until server closed, returns nil in case Shutdown was called
+func (s *Server) ListenAndServe() error {
+	if err := s.Rebind(); err != nil {
+		s.lock.Lock()
+		for _, b := range s.listeners {
+			_ = b.l.Close()
+		}
+		s.lock.Unlock()
+		return err
+	}
+	s.lock.Lock()
+	count := len(s.listeners)
+	s.lock.Unlock()
+	if count == 0 {
+		return nil
+	}
+
+	go s.Config.WatchConfigFile(s.quit, s.Reload)
+	go s.Config.WatchExpired(s.quit)
+	s.serveAdmin()
+
+	select {
+	case err := <-s.errs:
+		return err
+	case <-s.quit:
+		return nil
+	}
+}
+
+//listeners required by actual config, invalid specs reported as errors
+func (s *Server) wantedListeners() (map[string]*boundListener, []string) {
+	cfg := s.Config.CopyConfig()
+	res := make(map[string]*boundListener)
+	var errs []string
+	add := func(section string, l *config.ListenConf, isTls bool) {
+		for _, spec := range l.Specs() {
+			network, address, err := spec.Parse()
+			if err != nil {
+				errs = append(errs, section+" "+spec.Addr+": "+err.Error())
+				continue
+			}
+			res[section+" "+spec.Addr] = &boundListener{
+				Listener: lib.Listener{Section: section, Addr: spec.Addr},
+				network:  network,
+				address:  address,
+				tls:      isTls,
+			}
+		}
+	}
+	add("http", cfg.Http, false)
+	if len(cfg.TLSCert) > 0 && len(cfg.TLSKey) > 0 {
+		add("https", cfg.Tls, true)
+	}
+	return res, errs
+}
+
+/*
+bind listeners to the addresses from config, only changed ones are touched.
+in case new address can't be bound, previous listeners keep serving
+*/
+func (s *Server) Rebind() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var errs []string
+	if err := ensureSelfSigned(s.Config); err != nil {
+		errs = append(errs, err.Error())
+	}
+	want, wErrs := s.wantedListeners()
+	errs = append(errs, wErrs...)
+	//check before old listeners closed, also picks changed tls files
+	var certErr error
+	for _, w := range want {
+		if w.tls {
+			if _, certErr = s.certs.GetCertificate(nil); certErr != nil {
+				errs = append(errs, certErr.Error())
+			}
+			break
+		}
+	}
+	for key, w := range want {
+		if _, ok := s.listeners[key]; ok || w.tls && certErr != nil {
+			continue
+		}
+		l, err := listen(w.network, w.address)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		w.l = l
+		s.listeners[key] = w
+		s.serve(key, w)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	for key, cur := range s.listeners {
+		if _, ok := want[key]; !ok {
+			delete(s.listeners, key)
+			_ = cur.l.Close()
+			log.Println("server : stopped listening", cur.Section, cur.Addr)
+		}
+	}
+	return nil
+}
+
+//open listener of the network, unix socket left by previous run removed
+func listen(network, address string) (net.Listener, error) {
+	switch network {
+	case config.NetSystemd:
+		return systemdListener(address)
+	case config.NetUnix:
+		if _, err := os.Stat(address); err == nil {
+			if c, err := net.Dial(network, address); err == nil {
+				_ = c.Close()
+				return nil, errors.New(address + " is used by another process")
+			}
+			_ = os.Remove(address)
+		}
+		l, err := net.Listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+		//local reverse proxy usually runs as another user
+		_ = os.Chmod(address, 0660)
+		return l, nil
+	}
+	return net.Listen(network, address)
+}
+
+//serve listener in background, errors reported only while it still in use
+func (s *Server) serve(key string, b *boundListener) {
+	scheme, dav := "http", "dav"
+	if b.tls {
+		scheme, dav = "https", "davs"
+	}
+	// Tell the user the port in which is listening.
+	if b.network == config.NetTCP {
+		log.Println("Listening " + scheme + "://" + b.l.Addr().String())
+		log.Println(dav + "://" + b.l.Addr().String() + cnst.WEB_DAV_URL)
+	} else {
+		log.Println("Listening " + scheme + " at " + b.Addr)
+	}
+	l := b.l
+	if b.tls {
+		l = tls.NewListener(l, s.tlsConfig)
+	}
+	go func() {
+		err := s.srv.Serve(&specListener{Listener: l, info: &lib.Listener{Section: b.Section, Addr: b.Addr, Unix: b.l.Addr().Network() == config.NetUnix}})
+		s.lock.Lock()
+		active := s.listeners[key] == b
+		s.lock.Unlock()
+		if active && err != http.ErrServerClosed {
+			select {
+			case s.errs <- err:
+			default:
+			}
+		}
+	}()
+}
+
+//accept cli commands at admin socket, so cli and server never write config concurrently
+func (s *Server) serveAdmin() {
+	l, err := cli.Listen(s.Config.GetAdminSocketPath())
+	if err != nil {
+		log.Println("server : admin socket disabled", err)
+		return
+	}
+	s.admin = l
+	go cli.Serve(l, &cli.Env{
+		Config: s.Config,
+		Pgen:   s.Pgen,
+		PrepareUser: func(u *config.UserConfig) {
+			setDavHandlers(s.Config, []*config.UserConfig{u})
+		},
+		ApplySettings: func(changed []string) []string {
+			return applySettings(s.FileBrowser, changed).Restart
+		},
+	})
+}
+
+/*
+stops accepting connections and waits for in-flight requests(uploads, zip downloads, dav) until ctx done,
+after drains preview queue within the same ctx, and flush pending config changes to the disk.
+*/
+func (s *Server) Shutdown(ctx context.Context) (err error) {
+	close(s.quit)
+	if s.admin != nil {
+		_ = s.admin.Close()
+	}
+	if err = s.srv.Shutdown(ctx); err != nil {
+		log.Println("server : in-flight requests not finished in time, closing", err)
+		_ = s.srv.Close()
+	}
+	if pErr := s.Pgen.Stop(ctx); pErr != nil {
+		log.Println("server : preview jobs not finished in time", pErr)
+		if err == nil {
+			err = pErr
+		}
+	}
+	if fErr := s.Config.Flush(); fErr != nil && err == nil {
+		err = fErr
+	}
+
+	return err
+}
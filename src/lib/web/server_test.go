@@ -0,0 +1,220 @@
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/browsefile/backend/src/config"
+	"github.com/browsefile/backend/src/lib"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestServerRebind(t *testing.T) {
+	cfg := config.TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	cfg.Http.IP = "127.0.0.1"
+	cfg.Http.Port = freePort(t)
+	cfg.ConfigWatch = 0
+	srv := NewServer(cfg.GlobalConfig)
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ListenAndServe()
+	}()
+	get := func(port int) error {
+		var err error
+		for i := 0; i < 20; i++ {
+			var rs *http.Response
+			if rs, err = http.Get("http://127.0.0.1:" + strconv.Itoa(port) + "/"); err == nil {
+				_ = rs.Body.Close()
+				return nil
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return err
+	}
+	oldPort := cfg.Http.Port
+	if err := get(oldPort); err != nil {
+		t.Fatal("server not listening", err)
+	}
+
+	mod := cfg.CopyConfig()
+	mod.Http.Port = freePort(t)
+	mod.Threads = 3
+	res := applySettings(srv.FileBrowser, cfg.UpdateConfig(mod))
+	if len(res.Restart) > 0 || len(res.Applied) != 2 {
+		t.Fatal("settings not applied", res)
+	}
+	if err := get(mod.Http.Port); err != nil {
+		t.Fatal("server not listening at new port", err)
+	}
+	if _, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(oldPort)); err == nil {
+		t.Fatal("old port still open")
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerSelfSignedReload(t *testing.T) {
+	cfg := config.TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	cfg.Http.Port = 0
+	cfg.Tls.IP = "127.0.0.1"
+	cfg.Tls.Port = freePort(t)
+	cfg.TLSSelfSigned = true
+	cfg.ConfigWatch = 0
+	certCheckInterval = 0
+	defer func() { certCheckInterval = time.Second }()
+	srv := NewServer(cfg.GlobalConfig)
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ListenAndServe()
+	}()
+	serial := func() string {
+		var err error
+		for i := 0; i < 20; i++ {
+			var c *tls.Conn
+			c, err = tls.Dial("tcp", "127.0.0.1:"+strconv.Itoa(cfg.Tls.Port), &tls.Config{InsecureSkipVerify: true})
+			if err == nil {
+				defer c.Close()
+				return c.ConnectionState().PeerCertificates[0].SerialNumber.String()
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("tls not served", err)
+		return ""
+	}
+	first := serial()
+	certPath, keyPath := cfg.GetTLSFiles()
+	if len(certPath) == 0 || len(keyPath) == 0 {
+		t.Fatal("generated tls files must be saved in config")
+	}
+
+	//renewal on disk
+	if err := generateSelfSigned(certPath, keyPath, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certPath, future, future)
+	if serial() == first {
+		t.Fatal("renewed certificate not reloaded")
+	}
+	//broken files keep previous certificate
+	second := serial()
+	if err := ioutil.WriteFile(certPath, []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if serial() != second {
+		t.Fatal("previous certificate must be kept")
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerListenSpecs(t *testing.T) {
+	cfg := config.TContext{}
+	cfg.InitWithUsers(t)
+	defer cfg.Clean(t)
+	sock := cfg.ConfigPath + "/bf.sock"
+	cfg.Http.IP = "127.0.0.1"
+	cfg.Http.Port = freePort(t)
+	cfg.Http.Listen = []*config.ListenSpec{{Addr: "unix://" + sock, AuthMethod: "proxy"}}
+	cfg.Header = "X-Forwarded-User"
+	cfg.ConfigWatch = 0
+	srv := NewServer(cfg.GlobalConfig)
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ListenAndServe()
+	}()
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", sock)
+		},
+	}}
+	get := func(cl *http.Client, host string) int {
+		for i := 0; i < 20; i++ {
+			req, _ := http.NewRequest(http.MethodGet, "http://"+host+"/api/resource/", nil)
+			req.Header.Set(cfg.Header, "admin")
+			if rs, err := cl.Do(req); err == nil {
+				_ = rs.Body.Close()
+				return rs.StatusCode
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("server not listening", host)
+		return 0
+	}
+	//proxy auth only at unix socket, tcp needs token
+	if code := get(unixClient, "unix"); code != http.StatusOK {
+		t.Fatal("proxy auth must be used at unix socket", code)
+	}
+	if code := get(http.DefaultClient, "127.0.0.1:"+strconv.Itoa(cfg.Http.Port)); code != http.StatusForbidden {
+		t.Fatal("default auth must be used at tcp", code)
+	}
+
+	//drop unix socket
+	mod := cfg.CopyConfig()
+	mod.Http.Listen = nil
+	res := applySettings(srv.FileBrowser, cfg.UpdateConfig(mod))
+	if len(res.Applied) != 1 || res.Applied[0] != "http.listen" {
+		t.Fatal("listen change not applied", res)
+	}
+	if _, err := os.Stat(sock); err == nil {
+		t.Fatal("unix socket must be removed")
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnixProxyHeaders(t *testing.T) {
+	cfg := config.TContext{}
+	cfg.Init()
+	defer cfg.Clean(t)
+	req := httptest.NewRequest(http.MethodGet, "/api/resource/", nil)
+	req.RemoteAddr = "@"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	req.Header.Set("X-Forwarded-Prefix", "/files")
+	c := &lib.Context{FileBrowser: &lib.FileBrowser{Config: cfg.GlobalConfig}, Params: new(lib.Params)}
+	c.REQ = req
+	if ip := c.ClientIP(); ip != "@" {
+		t.Error("forwarded header of untrusted peer used", ip)
+	}
+	c.REQ = req.WithContext(lib.WithListener(req.Context(), &lib.Listener{Section: "http", Addr: "unix:///run/bf.sock", Unix: true}))
+	if ip := c.ClientIP(); ip != "203.0.113.7" {
+		t.Error("forwarded client of unix socket peer ignored", ip)
+	}
+	if p := c.BasePath(); p != "/files" {
+		t.Error("forwarded prefix of unix socket peer ignored", p)
+	}
+}
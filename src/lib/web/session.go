@@ -0,0 +1,78 @@
+package web
+
+import (
+	"encoding/hex"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"net/http"
+	"time"
+
+	fb "github.com/browsefile/backend/src/lib"
+)
+
+//session as seen by its owner
+type sessionResp struct {
+	*config.Session
+	//session of the request token
+	Current bool `json:"current"`
+}
+
+//register session of logged in user, its id goes into the token
+func newSession(c *fb.Context) error {
+	b, err := fb.GenerateRandomBytes(16)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	s := &config.Session{
+		ID:      hex.EncodeToString(b),
+		IP:      c.ClientIP(),
+		Agent:   c.REQ.UserAgent(),
+		Created: now,
+		Expires: now.Add(tokenTTL),
+	}
+	if err = c.Config.AddSession(c.User.Username, s); err != nil {
+		return err
+	}
+	c.Session = s.ID
+	return nil
+}
+
+//revoke session of the request token
+func logoutHandler(c *fb.Context) (int, error) {
+	if c.Method != http.MethodPost {
+		return http.StatusMethodNotAllowed, nil
+	}
+	if err := c.Config.RevokeSession(c.User.Username, c.Session); err != nil {
+		return http.StatusNotFound, err
+	}
+	return http.StatusOK, nil
+}
+
+//list sessions of current user, DELETE revokes one session by id, or all other sessions without id
+func sessionsHandler(c *fb.Context, id string) (int, error) {
+	switch c.Method {
+	case http.MethodGet:
+		u, _ := c.Config.GetUserByUsername(c.User.Username)
+		res := []*sessionResp{}
+		now := time.Now()
+		for _, s := range u.Sessions {
+			if now.Before(s.Expires) {
+				res = append(res, &sessionResp{s, s.ID == c.Session})
+			}
+		}
+		return renderJSON(c.RESP, res)
+	case http.MethodDelete:
+		if len(id) == 0 {
+			c.Config.RevokeSessions(c.User.Username, c.Session)
+			return http.StatusOK, nil
+		}
+		if err := c.Config.RevokeSession(c.User.Username, id); err == cnst.ErrNotExist {
+			return http.StatusNotFound, err
+		} else if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return http.StatusOK, nil
+	}
+	return http.StatusMethodNotAllowed, nil
+}
@@ -6,9 +6,14 @@ import (
 	"github.com/browsefile/backend/src/config"
 	"github.com/browsefile/backend/src/lib"
 	"net/http"
+	"os"
+	"strings"
 )
 
 func settingsHandler(c *lib.Context) (int, error) {
+	if strings.HasPrefix(c.URL, "/history") {
+		return settingsHistoryHandler(c)
+	}
 	if c.URL != "" && c.URL != "/" {
 		return http.StatusNotFound, nil
 	}
@@ -23,11 +28,23 @@ func settingsHandler(c *lib.Context) (int, error) {
 	return http.StatusMethodNotAllowed, nil
 }
 
+//settings with list of read-only ones, set by environment or flags
+type settingsResp struct {
+	*config.GlobalConfig
+	//setting key, like http.port, to the source
+	ReadOnly map[string]string `json:"readOnly"`
+}
+
 func settingsGetHandler(c *lib.Context) (int, error) {
 	if !c.User.Admin {
 		return http.StatusForbidden, nil
 	}
-	return renderJSON(c.RESP, c.Config.CopyConfig())
+	res := c.Config.CopyConfig()
+	//users not changed by settings, so their secrets never leave server
+	for _, u := range res.Users {
+		u.HideSecrets()
+	}
+	return renderJSON(c.RESP, &settingsResp{res, c.Config.ReadOnlySettings()})
 }
 
 func settingsPutHandler(c *lib.Context) (int, error) {
@@ -46,9 +63,64 @@ func settingsPutHandler(c *lib.Context) (int, error) {
 	if err != nil {
 		return http.StatusBadRequest, err
 	}
+	if err = mod.VerifySettings(); err != nil {
+		return http.StatusBadRequest, err
+	}
 	mod.Verify()
-	c.Config.UpdateConfig(mod)
+	changed := c.Config.UpdateConfig(mod)
 	c.Config.RefreshUserRam()
 
-	return http.StatusOK, nil
+	return renderJSON(c.RESP, applySettings(c.FileBrowser, changed))
+}
+
+type historyDiff struct {
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Lines []string `json:"lines"`
+}
+
+/*
+previous config versions, admin only:
+GET /history - list versions, GET /history?from=<id>&to=<id> - diff 2 versions, "current" is the actual file,
+PUT /history/<id> - restore version
+*/
+func settingsHistoryHandler(c *lib.Context) (int, error) {
+	if !c.User.Admin {
+		return http.StatusForbidden, nil
+	}
+	id := strings.Trim(strings.TrimPrefix(c.URL, "/history"), "/")
+
+	switch c.Method {
+	case http.MethodGet:
+		if len(id) > 0 {
+			return http.StatusNotFound, nil
+		}
+		from, to := c.Query.Get("from"), c.Query.Get("to")
+		if len(from) == 0 && len(to) == 0 {
+			return renderJSON(c.RESP, c.Config.GetHistory())
+		}
+		lines, err := c.Config.DiffHistory(from, to)
+		if err == cnst.ErrNotExist || os.IsNotExist(err) {
+			return http.StatusNotFound, err
+		} else if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return renderJSON(c.RESP, &historyDiff{From: from, To: to, Lines: lines})
+	case http.MethodPut:
+		if len(id) == 0 {
+			return http.StatusNotFound, nil
+		}
+		err := c.Config.RestoreHistory(id)
+		if err == cnst.ErrNotExist || os.IsNotExist(err) {
+			return http.StatusNotFound, err
+		} else if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if err = ReloadConfig(c.FileBrowser); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return http.StatusOK, nil
+	}
+
+	return http.StatusMethodNotAllowed, nil
 }
@@ -1 +1,118 @@
 package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"net/http"
+	"testing"
+)
+
+func TestSettingsHistory(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	cfg.ExternalShareHost = "http://old.host"
+	cfg.WriteConfig()
+	cfg.ExternalShareHost = "http://new.host"
+	cfg.WriteConfig()
+
+	dat := map[string]interface{}{"u": "/history"}
+	_, rs, _ := cfg.MakeRequest(cnst.R_SETTINGS, dat, cfg.Usr1, t, false)
+	if rs.StatusCode != http.StatusForbidden {
+		t.Fatal("history allowed only for admin")
+	}
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, cfg.GetAdmin(), t, false)
+	var items []*config.HistoryItem
+	if err := json.NewDecoder(rs.Body).Decode(&items); err != nil || len(items) == 0 {
+		t.Fatal("history must be listed", err)
+	}
+
+	dat["from"] = items[0].ID
+	dat["to"] = "current"
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, nil, t, false)
+	diff := &historyDiff{}
+	if err := json.NewDecoder(rs.Body).Decode(diff); err != nil || len(diff.Lines) == 0 {
+		t.Fatal("diff must be present", err)
+	}
+	dat["from"] = "missed"
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, nil, t, false)
+	if rs.StatusCode != http.StatusNotFound {
+		t.Fatal("not existing version", rs.StatusCode)
+	}
+
+	delete(dat, "from")
+	delete(dat, "to")
+	dat["u"] = "/history/" + items[0].ID
+	dat["method"] = http.MethodPut
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, nil, t, false)
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("version not restored", rs.StatusCode)
+	}
+	if cfg.ExternalShareHost != "http://old.host" {
+		t.Fatal("restored version not applied", cfg.ExternalShareHost)
+	}
+}
+
+func TestSettingsApply(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+
+	dat := map[string]interface{}{"u": "/"}
+	_, rs, _ := cfg.MakeRequest(cnst.R_SETTINGS, dat, cfg.GetAdmin(), t, false)
+	mod := &config.GlobalConfig{}
+	if err := json.NewDecoder(rs.Body).Decode(mod); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range mod.Users {
+		if len(u.Password) > 0 {
+			t.Fatal("secrets of users must be hidden", u.Username)
+		}
+	}
+	mod.Http.Port++
+	mod.Log = "stderr"
+	b, _ := json.Marshal(mod)
+	dat["method"] = http.MethodPut
+	dat["body"] = bytes.NewBuffer(b)
+	_, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, nil, t, false)
+	res := &SettingsChange{}
+	if err := json.NewDecoder(rs.Body).Decode(res); err != nil || rs.StatusCode != http.StatusOK {
+		t.Fatal("settings not updated", rs.StatusCode, err)
+	}
+	//test server does not manage listeners
+	if len(res.Applied) != 1 || res.Applied[0] != "log" || len(res.Restart) != 1 || res.Restart[0] != "http.port" {
+		t.Fatal("wrong applied settings", res)
+	}
+	if u, _ := cfg.GetUserByUsername("admin"); len(u.Password) == 0 {
+		t.Fatal("hidden secrets must not be saved")
+	}
+
+	port := mod.Http.Port
+	mod.Http.Port = 70000
+	b, _ = json.Marshal(mod)
+	dat["body"] = bytes.NewBuffer(b)
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, nil, t, false); rs.StatusCode != http.StatusBadRequest {
+		t.Fatal("bad settings accepted", rs.StatusCode)
+	}
+
+	//optional sections missed
+	mod.Http.Port = port
+	mod.Tls, mod.CaptchaConfig = nil, nil
+	b, _ = json.Marshal(mod)
+	dat["body"] = bytes.NewBuffer(b)
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, nil, t, false); rs.StatusCode != http.StatusOK {
+		t.Fatal("missed optional sections not defaulted", rs.StatusCode)
+	}
+	if cfg.Tls == nil || cfg.CaptchaConfig == nil {
+		t.Fatal("optional sections must be set")
+	}
+	//required section missed
+	mod.Auth = nil
+	b, _ = json.Marshal(mod)
+	dat["body"] = bytes.NewBuffer(b)
+	if _, rs, _ = cfg.MakeRequest(cnst.R_SETTINGS, dat, nil, t, false); rs.StatusCode != http.StatusBadRequest {
+		t.Fatal("missed auth accepted", rs.StatusCode)
+	}
+}
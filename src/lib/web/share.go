@@ -60,6 +60,17 @@ func sharePostHandler(c *lib.Context) (res int, err error) {
 			return http.StatusBadRequest, err
 		}
 	}
+	if !c.User.Can(config.PermShare) ||
+		(c.ShareType == "gen-ex" || itm.AllowExternal) && !c.User.Can(config.PermShareExternal) {
+		return http.StatusForbidden, nil
+	}
+	p := itm.Path
+	if c.ShareType == "gen-ex" {
+		p = c.URL
+	}
+	if !c.Config.ShareReadable(c.User.UserConfig, p) {
+		return http.StatusForbidden, nil
+	}
 	needUpd := false
 	switch c.ShareType {
 	case "gen-ex":
@@ -79,7 +90,11 @@ func sharePostHandler(c *lib.Context) (res int, err error) {
 			h = shr.Hash
 		}
 
-		l := c.Config.ExternalShareHost + "/shares?" + cnst.P_ROOTHASH + "=" + url.QueryEscape(h)
+		host := c.Config.ExternalShareHost
+		if len(host) == 0 {
+			host = c.PublicURL()
+		}
+		l := strings.TrimSuffix(host, "/") + "/shares?" + cnst.P_ROOTHASH + "=" + url.QueryEscape(h)
 		return renderJSON(c.RESP, l)
 
 	default:
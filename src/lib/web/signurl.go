@@ -0,0 +1,81 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	fb "github.com/browsefile/backend/src/lib"
+)
+
+//lifetime of signed links
+const (
+	playlistLinkTTL = time.Hour * 24
+	defaultLinkTTL  = time.Hour
+	maxLinkTTL      = time.Hour * 24 * 7
+)
+
+type linkReq struct {
+	//api path, like /download/photos/a.jpg or /shares/download/user/a.jpg
+	Path string `json:"path"`
+	//comma separated files of multiple files download
+	Files string `json:"files"`
+	//seconds
+	TTL int `json:"ttl"`
+}
+
+//authenticate request by signature of url, valid only for exact path it was issued
+func signedAuth(c *fb.Context) (bool, *fb.UserModel) {
+	username, ok := fb.CheckSignedURL(c.Config, c.REQ, c.REQ.URL.Path)
+	if !ok {
+		authFailed("signed")
+		return false, nil
+	}
+	u, ok := c.Config.GetUserByUsername(username)
+	if !ok || !isActive(u, "signed") {
+		authFailed("signed")
+		return false, nil
+	}
+	c.User = fb.ToUserModel(u, c.Config)
+	c.Signed = true
+	return true, c.User
+}
+
+//absolute signed url of api path p
+func signedLink(c *fb.Context, p, files string, ttl time.Duration) (string, error) {
+	q, err := fb.SignedQuery(c.Config, c.User.Username, p, files, c.RootHash, ttl)
+	if err != nil {
+		return "", err
+	}
+	return c.PublicURL() + "/api" + p + "?" + q.Encode(), nil
+}
+
+//signed direct link to download or preview, without credentials inside
+func signedLinkHandler(c *fb.Context) (int, error) {
+	req := new(linkReq)
+	if c.REQ.Body == nil || json.NewDecoder(c.REQ.Body).Decode(req) != nil {
+		return http.StatusBadRequest, cnst.ErrEmptyRequest
+	}
+	p := path.Clean("/" + req.Path)
+	if strings.HasSuffix(req.Path, "/") && p != "/" {
+		p += "/"
+	}
+	if !strings.HasPrefix(p, "/download/") && !strings.HasPrefix(p, "/shares/download/") {
+		return http.StatusBadRequest, cnst.ErrInvalidOption
+	}
+	ttl := defaultLinkTTL
+	if req.TTL > 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+	if ttl > maxLinkTTL {
+		ttl = maxLinkTTL
+	}
+	l, err := signedLink(c, p, req.Files, ttl)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return renderJSON(c.RESP, map[string]interface{}{"url": l, "expires": time.Now().Add(ttl)})
+}
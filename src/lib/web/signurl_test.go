@@ -0,0 +1,92 @@
+package web
+
+import (
+	"github.com/browsefile/backend/src/cnst"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	fb "github.com/browsefile/backend/src/lib"
+)
+
+func TestSignedLinks(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	dat := map[string]interface{}{"u": "/", "method": http.MethodGet}
+	_, _, _ = cfg.MakeRequest(cnst.R_RESOURCE, dat, cfg.Usr1, t, false)
+	file := "/download" + cfg.SharePathDeep + "/real.jpg"
+	get := func(method, l string) int {
+		req, _ := http.NewRequest(method, l, nil)
+		rs, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rs.Body.Close()
+		return rs.StatusCode
+	}
+
+	if c := authRequest(&cfg, http.MethodPost, "/auth/link", `{"path":"/resource/"}`, nil); c != http.StatusBadRequest {
+		t.Error("only downloads can be signed", c)
+	}
+	res := map[string]interface{}{}
+	if c := authRequest(&cfg, http.MethodPost, "/auth/link", `{"path":"`+file+`","ttl":60}`, &res); c != http.StatusOK {
+		t.Fatal("link not signed", c)
+	}
+	l := res["url"].(string)
+	if strings.Contains(l, cfg.Token) {
+		t.Fatal("link must not carry token")
+	}
+	if c := get(http.MethodGet, l); c != http.StatusOK {
+		t.Error("signed link refused", c)
+	}
+	if c := get(http.MethodHead, l); c != http.StatusOK {
+		t.Error("head must be allowed with get", c)
+	}
+	if c := get(http.MethodDelete, strings.Replace(l, "/download/", "/resource/", 1)); c != http.StatusForbidden {
+		t.Error("signature of other resource and method accepted", c)
+	}
+	if c := get(http.MethodGet, strings.Replace(l, "real.jpg", "t.png", 1)); c != http.StatusForbidden {
+		t.Error("signature of other file accepted", c)
+	}
+	if c := get(http.MethodGet, strings.Replace(l, cnst.P_SIG_USER+"=user1", cnst.P_SIG_USER+"=admin", 1)); c != http.StatusForbidden {
+		t.Error("signature of other user accepted", c)
+	}
+	if c := get(http.MethodGet, l+"&"+cnst.P_ROOTHASH+"=abc"); c != http.StatusForbidden {
+		t.Error("signature accepted with other rootHash", c)
+	}
+	p := "/playlist" + cfg.SharePathDeep + "/"
+	q, _ := fb.SignedQuery(cfg.GlobalConfig, "user1", p, "", "", time.Minute)
+	if c := get(http.MethodGet, cfg.Srv.URL+"/api"+p+"?"+q.Encode()); c != http.StatusForbidden {
+		t.Error("signature must open downloads only", c)
+	}
+	q, _ = fb.SignedQuery(cfg.GlobalConfig, "user1", file, "", "", -time.Minute)
+	if c := get(http.MethodGet, cfg.Srv.URL+"/api"+file+"?"+q.Encode()); c != http.StatusForbidden {
+		t.Error("expired signature accepted", c)
+	}
+
+	//download answered by redirect to signed link
+	req, _ := http.NewRequest(http.MethodGet, cfg.Srv.URL+"/api"+file+"?redirect=true", nil)
+	req.Header.Set(cnst.H_XAUTH, cfg.Token)
+	rs, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rs.Body.Close()
+	loc := rs.Header.Get("Location")
+	if rs.StatusCode != http.StatusSeeOther || !strings.Contains(loc, cnst.P_SIG+"=") {
+		t.Fatal("redirect to signed link expected", rs.StatusCode, loc)
+	}
+	if c := get(http.MethodGet, loc); c != http.StatusOK {
+		t.Error("redirect link refused", c)
+	}
+
+	//signature does not outlive account
+	u, _ := cfg.GetUserByUsername("user1")
+	u.Disabled = true
+	_ = cfg.Update(u)
+	if c := get(http.MethodGet, l); c != http.StatusForbidden {
+		t.Error("link of disabled user accepted", c)
+	}
+}
@@ -0,0 +1,79 @@
+package web
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//first file descriptor passed by systemd
+const listenFdsStart = 3
+
+//socket passed by systemd, could be taken only once
+type activatedSocket struct {
+	name string
+	l    net.Listener
+	used bool
+}
+
+var (
+	activationOnce sync.Once
+	activationLock sync.Mutex
+	activated      []*activatedSocket
+)
+
+//read sockets passed by systemd socket activation, environment cleared so children not inherit them
+func readActivated() {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFdsStart+i), name)
+		//duplicates descriptor with close on exec
+		l, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			continue
+		}
+		activated = append(activated, &activatedSocket{name: name, l: l})
+	}
+}
+
+/*
+socket passed by systemd, by FileDescriptorName or index,
+empty name takes first socket not in use
+*/
+func systemdListener(name string) (net.Listener, error) {
+	activationOnce.Do(readActivated)
+	activationLock.Lock()
+	defer activationLock.Unlock()
+	idx, isIdx := -1, false
+	if i, err := strconv.Atoi(name); err == nil {
+		idx, isIdx = i, true
+	}
+	for i, s := range activated {
+		if isIdx && i != idx || !isIdx && len(name) > 0 && s.name != name || s.used {
+			continue
+		}
+		s.used = true
+		return s.l, nil
+	}
+	return nil, errors.New("no systemd socket " + strconv.Quote(name) + " passed or it already used")
+}
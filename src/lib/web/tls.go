@@ -0,0 +1,174 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/browsefile/backend/src/config"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//how often certificate files checked for changes, during handshakes
+var certCheckInterval = time.Second
+
+//self-signed certificate lifetime
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+/*
+serves tls certificate from files in config, files reloaded once changed on disk,
+so renewed certificate used without restart. In case new files broken, previous certificate kept
+*/
+type certLoader struct {
+	cfg  *config.GlobalConfig
+	lock sync.Mutex
+	cert *tls.Certificate
+	//files current certificate was loaded from
+	certPath, keyPath string
+	certMod, keyMod   time.Time
+	checked           time.Time
+}
+
+func newCertLoader(cfg *config.GlobalConfig) *certLoader {
+	return &certLoader{cfg: cfg}
+}
+
+//tls.Config.GetCertificate callback
+func (l *certLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.cert != nil && time.Since(l.checked) < certCheckInterval {
+		return l.cert, nil
+	}
+	l.checked = time.Now()
+	certPath, keyPath := l.cfg.GetTLSFiles()
+	certInf, err := os.Stat(certPath)
+	var keyInf os.FileInfo
+	if err == nil {
+		keyInf, err = os.Stat(keyPath)
+	}
+	if err != nil {
+		if l.cert != nil {
+			log.Println("tls : certificate files unavailable, keep previous", err)
+			return l.cert, nil
+		}
+		return nil, err
+	}
+	if l.cert != nil && l.certPath == certPath && l.keyPath == keyPath &&
+		l.certMod.Equal(certInf.ModTime()) && l.keyMod.Equal(keyInf.ModTime()) {
+		return l.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		if l.cert != nil {
+			log.Println("tls : certificate reload failed, keep previous", err)
+			return l.cert, nil
+		}
+		return nil, err
+	}
+	if l.cert != nil {
+		log.Println("tls : certificate reloaded", certPath)
+	}
+	l.cert = &cert
+	l.certPath, l.keyPath = certPath, keyPath
+	l.certMod, l.keyMod = certInf.ModTime(), keyInf.ModTime()
+
+	return l.cert, nil
+}
+
+//generate self-signed certificate in case enabled and tls files missed, paths default to config dir
+func ensureSelfSigned(cfg *config.GlobalConfig) error {
+	c := cfg.CopyConfig()
+	if !c.TLSSelfSigned || len(c.Tls.Specs()) == 0 {
+		return nil
+	}
+	certPath, keyPath := c.TLSCert, c.TLSKey
+	dir := filepath.Dir(cfg.Path)
+	if len(certPath) == 0 {
+		certPath = filepath.Join(dir, "browsefile.crt")
+	}
+	if len(keyPath) == 0 {
+		keyPath = filepath.Join(dir, "browsefile.key")
+	}
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if certErr != nil || keyErr != nil {
+		hosts := []string{"localhost", "127.0.0.1", "::1"}
+		if h, err := os.Hostname(); err == nil && len(h) > 0 {
+			hosts = append(hosts, h)
+		}
+		if ip := net.ParseIP(c.Tls.IP); ip != nil && !ip.IsUnspecified() {
+			hosts = append(hosts, c.Tls.IP)
+		}
+		if u, err := url.Parse(c.ExternalShareHost); err == nil && len(u.Hostname()) > 0 {
+			hosts = append(hosts, u.Hostname())
+		}
+		if err := generateSelfSigned(certPath, keyPath, hosts); err != nil {
+			return err
+		}
+		log.Println("tls : generated self-signed certificate", certPath)
+	}
+	cfg.SetTLSFiles(certPath, keyPath)
+
+	return nil
+}
+
+//write ECDSA key and self-signed certificate for hosts in PEM format
+func generateSelfSigned(certPath, keyPath string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"browsefile"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	for _, p := range []string{certPath, keyPath} {
+		if err = os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			return err
+		}
+	}
+	//key first, so certificate never exists without it
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
@@ -0,0 +1,200 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
+	"net/http"
+	"strings"
+	"time"
+
+	fb "github.com/browsefile/backend/src/lib"
+)
+
+const totpIssuer = "Browsefile"
+
+type twoFactorReq struct {
+	Secret string `json:"secret"`
+	Code   string `json:"code"`
+	//code of enrolled factor, needed to replace it
+	Current string `json:"current"`
+	//admin disables two factor of other user
+	Username string `json:"username"`
+	//app password name
+	Name string `json:"name"`
+}
+
+//totp enrollment and app passwords of current user, at /auth/2fa and /auth/app-passwords
+func authSettingsHandler(c *fb.Context) (int, error) {
+	if c.User.IsGuest() {
+		return http.StatusForbidden, nil
+	}
+	p := strings.TrimPrefix(c.REQ.URL.Path, "/auth")
+	switch {
+	case p == "/2fa" && c.Method == http.MethodGet:
+		u, _ := c.Config.GetUserByUsername(c.User.Username)
+		res := map[string]interface{}{"enabled": u.HasTwoFactor(), "required": c.Config.NeedsTwoFactor(u)}
+		if u.HasTwoFactor() {
+			res["recoveryLeft"] = len(u.TwoFactor.Recovery)
+		}
+		return renderJSON(c.RESP, res)
+	case strings.HasPrefix(p, "/2fa/") && c.Method == http.MethodPost:
+		req := new(twoFactorReq)
+		if c.REQ.Body == nil || json.NewDecoder(c.REQ.Body).Decode(req) != nil {
+			return http.StatusBadRequest, cnst.ErrEmptyRequest
+		}
+		return twoFactorHandler(c, strings.TrimPrefix(p, "/2fa/"), req)
+	case p == "/app-passwords" || strings.HasPrefix(p, "/app-passwords/"):
+		return appPasswordsHandler(c, strings.TrimPrefix(strings.TrimPrefix(p, "/app-passwords"), "/"))
+	case p == "/tokens" || strings.HasPrefix(p, "/tokens/"):
+		return apiTokensHandler(c, strings.TrimPrefix(strings.TrimPrefix(p, "/tokens"), "/"))
+	case p == "/link" && c.Method == http.MethodPost:
+		return signedLinkHandler(c)
+	case p == "/logout":
+		return logoutHandler(c)
+	case p == "/sessions" || strings.HasPrefix(p, "/sessions/"):
+		return sessionsHandler(c, strings.TrimPrefix(strings.TrimPrefix(p, "/sessions"), "/"))
+	case p == "/lockouts" || strings.HasPrefix(p, "/lockouts/"):
+		return lockoutsHandler(c, strings.TrimPrefix(strings.TrimPrefix(p, "/lockouts"), "/"))
+	}
+	return http.StatusNotFound, nil
+}
+
+func twoFactorHandler(c *fb.Context, action string, req *twoFactorReq) (int, error) {
+	u, ok := c.Config.GetUserByUsername(c.User.Username)
+	if !ok {
+		return http.StatusNotFound, cnst.ErrNotExist
+	}
+	switch action {
+	case "setup":
+		//secret saved only after confirmation by code
+		secret, err := fb.GenerateTOTPSecret()
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return renderJSON(c.RESP, map[string]string{"secret": secret, "uri": fb.TOTPURI(totpIssuer, u.Username, secret)})
+	case "enable":
+		//stolen session must not take over enrolled two factor
+		if u.HasTwoFactor() {
+			if code, err := limitedSecondFactor(c, u, req.Current); code != 0 {
+				return code, err
+			}
+		}
+		step, ok := fb.CheckTOTP(req.Secret, req.Code, time.Now())
+		if !ok {
+			return http.StatusBadRequest, cnst.ErrWrongCode
+		}
+		codes, hashes, err := fb.GenerateRecoveryCodes()
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if err = c.Config.SetTwoFactor(u.Username, req.Secret, hashes); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		c.Config.UseTOTPStep(u.Username, step)
+		return renderJSON(c.RESP, map[string][]string{"recoveryCodes": codes})
+	case "recovery":
+		if !u.HasTwoFactor() {
+			return http.StatusBadRequest, cnst.ErrInvalidOption
+		}
+		if code, err := limitedSecondFactor(c, u, req.Code); code != 0 {
+			return code, err
+		}
+		codes, hashes, err := fb.GenerateRecoveryCodes()
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if err = c.Config.SetRecoveryCodes(u.Username, hashes); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return renderJSON(c.RESP, map[string][]string{"recoveryCodes": codes})
+	case "disable":
+		//admin resets two factor of user, who lost the device
+		if len(req.Username) > 0 && !strings.EqualFold(req.Username, u.Username) {
+			if !c.User.Admin {
+				return http.StatusForbidden, nil
+			}
+			if err := c.Config.SetTwoFactor(req.Username, "", nil); err != nil {
+				return http.StatusNotFound, err
+			}
+			return http.StatusOK, nil
+		}
+		if c.Config.TwoFactorRequired(u) {
+			return http.StatusForbidden, cnst.ErrTwoFactorRequired
+		}
+		if u.HasTwoFactor() {
+			if code, err := limitedSecondFactor(c, u, req.Code); code != 0 {
+				return code, err
+			}
+		}
+		if err := c.Config.SetTwoFactor(u.Username, "", nil); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return http.StatusOK, nil
+	}
+	return http.StatusNotFound, nil
+}
+
+func appPasswordsHandler(c *fb.Context, name string) (int, error) {
+	switch c.Method {
+	case http.MethodGet:
+		u, _ := c.Config.GetUserByUsername(c.User.Username)
+		u.HideSecrets()
+		res := u.AppPasswords
+		if res == nil {
+			res = []*config.AppPassword{}
+		}
+		return renderJSON(c.RESP, res)
+	case http.MethodPost:
+		req := new(twoFactorReq)
+		if c.REQ.Body == nil || json.NewDecoder(c.REQ.Body).Decode(req) != nil {
+			return http.StatusBadRequest, cnst.ErrEmptyRequest
+		}
+		pwd, err := fb.GenerateAppPassword()
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		err = c.Config.AddAppPassword(c.User.Username, &config.AppPassword{Name: req.Name, Hash: fb.HashToken(pwd), Created: time.Now()})
+		if err == cnst.ErrExist {
+			return http.StatusConflict, err
+		} else if err != nil {
+			return http.StatusBadRequest, err
+		}
+		return renderJSON(c.RESP, map[string]string{"name": req.Name, "password": pwd})
+	case http.MethodDelete:
+		if err := c.Config.DeleteAppPassword(c.User.Username, name); err == cnst.ErrNotExist {
+			return http.StatusNotFound, err
+		} else if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return http.StatusOK, nil
+	}
+	return http.StatusMethodNotAllowed, nil
+}
+
+//second factor check of logged in user, limited same as login. Zero code in case accepted
+func limitedSecondFactor(c *fb.Context, u *config.UserConfig, code string) (int, error) {
+	ip := c.ClientIP()
+	if wait := fb.LoginAttempt(c.Config, ip, u.Username); wait > 0 {
+		retryAfter(c.RESP, wait)
+		return http.StatusTooManyRequests, nil
+	}
+	if !checkSecondFactor(c, u, code) {
+		fb.LoginFailed(c.Config, ip, u.Username)
+		authFailed("otp")
+		return http.StatusForbidden, cnst.ErrWrongCode
+	}
+	fb.LoginRelease(ip, u.Username)
+	return 0, nil
+}
+
+//totp code, or one of recovery codes. Every code accepted once
+func checkSecondFactor(c *fb.Context, u *config.UserConfig, code string) bool {
+	if !u.HasTwoFactor() {
+		return false
+	}
+	if step, ok := fb.CheckTOTP(u.TwoFactor.Secret, code, time.Now()); ok {
+		return c.Config.UseTOTPStep(u.Username, step)
+	}
+	return c.Config.UseRecoveryCode(u.Username, fb.HashToken(code))
+}
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/browsefile/backend/src/cnst"
+	"github.com/browsefile/backend/src/config"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	fb "github.com/browsefile/backend/src/lib"
@@ -21,12 +23,18 @@ type ModifyUserRequest struct {
 	Data *fb.UserModel `json:"data"`
 }
 
+//user with used space and quota
+type userResp struct {
+	*config.UserConfig
+	*fb.QuotaInfo
+}
+
 // usersHandler is the entry point of the users API. It's just a router
 // to send the request to its
 func usersHandler(c *fb.Context) (int, error) {
 	// If the user isn't admin and isn't making a PUT
-	// request, then return forbidden.
-	if !c.User.Admin && c.Method != http.MethodGet {
+	// request, then return forbidden. Forced password change is the only update of non admin.
+	if !c.User.Admin && c.Method != http.MethodGet && !(c.Method == http.MethodPut && c.User.MustChangePassword) {
 		return http.StatusForbidden, nil
 	}
 
@@ -85,7 +93,12 @@ func parseUserFromRequest(c *fb.Context) (*fb.UserModel, string, error) {
 func usersGetHandler(c *fb.Context) (int, error) {
 	// Request for the default user data.
 	if c.URL == "/base" {
-		return renderJSON(c.RESP, c.Config.GetAdmin())
+		admin, _ := c.Config.GetUserByUsername(c.Config.GetAdmin().Username)
+		if !c.User.Admin {
+			return renderJSON(c.RESP, publicUser(admin))
+		}
+		admin.HideSecrets()
+		return renderJSON(c.RESP, admin)
 	}
 
 	// Request for the listing of users.
@@ -95,11 +108,17 @@ func usersGetHandler(c *fb.Context) (int, error) {
 			return http.StatusInternalServerError, errors.New("cant find any users")
 		}
 
-		for _, u := range users {
+		res := make([]*userResp, len(users))
+		for i, u := range users {
+			//allow view users, in order to share
+			if !c.User.Admin && u.Username != c.User.Username {
+				res[i] = &userResp{UserConfig: publicUser(u)}
+				continue
+			}
+			res[i] = &userResp{UserConfig: u, QuotaInfo: fb.GetQuotaInfo(c.Config, u)}
 			// Removes the user password so it won't
 			// be sent to the front-end.
-			u.Password = ""
-			//allow view users, in order to share
+			u.HideSecrets()
 			if !c.User.Admin {
 				u.UID = -1
 				u.GID = -1
@@ -109,7 +128,7 @@ func usersGetHandler(c *fb.Context) (int, error) {
 			}
 		}
 
-		return renderJSON(c.RESP, users)
+		return renderJSON(c.RESP, res)
 	}
 
 	name := getUserName(c.URL)
@@ -117,9 +136,17 @@ func usersGetHandler(c *fb.Context) (int, error) {
 	if !ok {
 		return http.StatusNotFound, cnst.ErrNotExist
 	}
+	if !c.User.Admin && !strings.EqualFold(u.Username, c.User.Username) {
+		return renderJSON(c.RESP, &userResp{UserConfig: publicUser(u)})
+	}
+
+	u.HideSecrets()
+	return renderJSON(c.RESP, &userResp{u, fb.GetQuotaInfo(c.Config, u)})
+}
 
-	u.Password = ""
-	return renderJSON(c.RESP, u)
+//view of other user for non admin, only name needed to share with
+func publicUser(u *config.UserConfig) *config.UserConfig {
+	return &config.UserConfig{Username: u.Username}
 }
 
 func usersPostHandler(c *fb.Context) (int, error) {
@@ -148,13 +175,16 @@ func usersPostHandler(c *fb.Context) (int, error) {
 	}
 
 	// Hashes the password.
-	pw, err := fb.HashPassword(u.Password)
+	pw, code, err := makePassword(c, u.Password)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return code, err
 	}
 
 	u.Password = pw
 	u.ViewMode = cnst.MosaicViewMode
+	if err = u.Rules.Validate(); err != nil {
+		return http.StatusBadRequest, err
+	}
 
 	// Saves the user to the database.
 	err = c.Config.AddUser(u.UserConfig)
@@ -167,11 +197,23 @@ func usersPostHandler(c *fb.Context) (int, error) {
 	}
 
 	// Set the Location header and return.
-	c.RESP.Header().Set("Location", "/settings/users/"+u.Username)
+	c.RESP.Header().Set("Location", c.BasePath()+"/settings/users/"+u.Username)
 	c.RESP.WriteHeader(http.StatusCreated)
 	return http.StatusOK, nil
 }
 
+//check password against the policy, and hash it
+func makePassword(c *fb.Context, password string) (string, int, error) {
+	if err := c.Config.GetPasswordConf().Check(password); err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	pw, err := fb.HashPassword(c.Config, password)
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	return pw, 0, nil
+}
+
 func makeFS(path string) (int, error) {
 	info, err := os.Stat(path)
 
@@ -206,15 +248,28 @@ func usersDeleteHandler(c *fb.Context) (int, error) {
 		return http.StatusInternalServerError, cnst.ErrNotExist
 	}
 
-	// Deletes the user from the database.
-	err := c.Config.DeleteUser(name)
+	//mode says what happens with user's files, dry run only reports it
+	dryRun, _ := strconv.ParseBool(c.Query.Get("dryRun"))
+	plan, err := c.Config.DeleteUserData(name, &config.DeleteOptions{
+		Mode:   c.Query.Get("mode"),
+		Target: c.Query.Get("target"),
+		DryRun: dryRun,
+	})
 	if err == cnst.ErrNotExist {
 		return http.StatusNotFound, cnst.ErrNotExist
+	} else if plan == nil {
+		return http.StatusBadRequest, err
 	} else if err != nil {
 		return http.StatusInternalServerError, err
 	}
+	if !dryRun {
+		fb.ResetUsedSpace(c.Config, name)
+		if len(plan.Target) > 0 {
+			fb.ResetUsedSpace(c.Config, plan.Target)
+		}
+	}
 
-	return http.StatusOK, nil
+	return renderJSON(c.RESP, plan)
 }
 
 func usersPutHandler(c *fb.Context) (int, error) {
@@ -241,6 +296,10 @@ func usersPutHandler(c *fb.Context) (int, error) {
 		return http.StatusBadRequest, err
 	}
 
+	if !c.User.Admin && which != "password" {
+		return http.StatusForbidden, nil
+	}
+
 	// If we're updating the default user. Only for NoAuth
 	// implementations. Used to change the viewMode.
 	cfgM := c.GetAuthConfig()
@@ -280,15 +339,18 @@ func usersPutHandler(c *fb.Context) (int, error) {
 			return http.StatusForbidden, nil
 		}
 
-		c.User.Password, err = fb.HashPassword(u.Password)
-		if err != nil {
-			return http.StatusInternalServerError, err
+		var code int
+		if c.User.Password, code, err = makePassword(c, u.Password); err != nil {
+			return code, err
 		}
+		c.User.MustChangePassword = false
 
 		err = c.Config.UpdatePassword(c.User.UserConfig)
 		if err != nil {
 			return http.StatusInternalServerError, err
 		}
+		//other devices have to login with new password
+		c.Config.RevokeSessions(c.User.Username, c.Session)
 
 		return http.StatusOK, nil
 	}
@@ -314,6 +376,17 @@ func usersPutHandler(c *fb.Context) (int, error) {
 		return http.StatusBadRequest, cnst.ErrEmptyUsername
 	}
 
+	//admin renames user by sending new username
+	if u.Username != name {
+		if !c.User.Admin {
+			return http.StatusForbidden, nil
+		}
+		if code, err := renameUser(c, name, u.Username); err != nil {
+			return code, err
+		}
+		name = u.Username
+	}
+
 	// Checks if the scope exists.
 	if code, err := makeFS(c.Config.GetUserHomePath(u.Username)); err != nil {
 		return code, err
@@ -324,13 +397,12 @@ func usersPutHandler(c *fb.Context) (int, error) {
 		return http.StatusNotFound, nil
 	}
 
-	u.Username = name
-
 	// Changes the password if the request wants it.
-	if u.Password != "" {
-		pw, err := fb.HashPassword(u.Password)
+	newPassword := u.Password != ""
+	if newPassword {
+		pw, code, err := makePassword(c, u.Password)
 		if err != nil {
-			return http.StatusInternalServerError, err
+			return code, err
 		}
 
 		u.Password = pw
@@ -338,6 +410,15 @@ func usersPutHandler(c *fb.Context) (int, error) {
 		u.Password = original.Password
 	}
 	u.Shares = original.Shares
+	//user can't lift own limits
+	if !c.User.Admin {
+		u.Perms, u.Rules = original.Perms, original.Rules
+		u.AllowEdit, u.AllowNew = original.AllowEdit, original.AllowNew
+		u.Disabled, u.ExpiresAt, u.MustChangePassword = original.Disabled, original.ExpiresAt, original.MustChangePassword
+	}
+	if err = u.Rules.Validate(); err != nil {
+		return http.StatusBadRequest, err
+	}
 
 	// Updates the whole User struct because we always are supposed
 	// to send a new entire object.
@@ -345,6 +426,34 @@ func usersPutHandler(c *fb.Context) (int, error) {
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
+	if newPassword {
+		if err = c.Config.UpdatePassword(u.UserConfig); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		except := ""
+		if strings.EqualFold(u.Username, c.User.Username) {
+			except = c.Session
+		}
+		c.Config.RevokeSessions(u.Username, except)
+	}
 
 	return http.StatusOK, nil
 }
+
+//move user with all files and shares to the new name, dav handler rebuilt for the new folder
+func renameUser(c *fb.Context, oldName, newName string) (int, error) {
+	err := c.Config.RenameUser(oldName, newName, func(u *config.UserConfig) {
+		setDavHandlers(c.Config, []*config.UserConfig{u})
+	})
+	switch {
+	case err == cnst.ErrExist:
+		return http.StatusConflict, err
+	case err == cnst.ErrNotExist:
+		return http.StatusNotFound, err
+	case err != nil:
+		return http.StatusBadRequest, err
+	}
+	//used space cached by home path
+	fb.ResetUsedSpace(c.Config, oldName)
+	return http.StatusOK, nil
+}
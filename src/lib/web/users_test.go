@@ -13,6 +13,7 @@ func TestUsersList(t *testing.T) {
 	cfg := TServContext{}
 	cfg.InitServ(t)
 	defer cfg.Clean(t)
+	cfg.SetLastLogin(cfg.GetAdmin().Username, "10.0.0.1")
 	dat := map[string]interface{}{"u": "/admin", "method": http.MethodPost}
 
 	_, rs, _ := cfg.MakeRequest(cnst.R_USERS, dat, cfg.Usr1, t, false)
@@ -24,11 +25,22 @@ func TestUsersList(t *testing.T) {
 	if rs.StatusCode != http.StatusOK {
 		t.Error("user list should be allowed")
 	}
+	admin := map[string]interface{}{}
+	if err := json.NewDecoder(rs.Body).Decode(&admin); err != nil || admin["lastLogin"] != nil || admin["rules"] != nil {
+		t.Error("other user details visible", admin)
+	}
 	dat["u"] = "/"
 	_, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, cfg.Usr1, t, false)
 	if rs.StatusCode != http.StatusOK {
 		t.Error("user list should be allowed")
 	}
+	var list []map[string]interface{}
+	_ = json.NewDecoder(rs.Body).Decode(&list)
+	for _, u := range list {
+		if u["username"] != cfg.Usr1.Username && (u["lastLogin"] != nil || u["quota"] != float64(0) || u["used"] != nil) {
+			t.Error("other user details visible", u)
+		}
+	}
 }
 func TestUserCreate(t *testing.T) {
 	cfg := TServContext{}
@@ -113,13 +125,51 @@ func TestUserUpdate(t *testing.T) {
 	}
 
 }
+func TestUserRename(t *testing.T) {
+	cfg := TServContext{}
+	cfg.InitServ(t)
+	defer cfg.Clean(t)
+	dat := map[string]interface{}{"u": "/user1", "method": http.MethodPut}
+	usr, _ := cfg.GetUserByUsername("user1")
+	usr.Username = "user3"
+	buf := new(bytes.Buffer)
+	modu := &ModifyUserRequest{ModifyRequest: ModifyRequest{What: "user"}, Data: lib.ToUserModel(usr, cfg.GlobalConfig)}
+	_ = json.NewEncoder(buf).Encode(modu)
+	dat["body"] = buf
+	_, rs, _ := cfg.MakeRequest(cnst.R_USERS, dat, cfg.Usr2, t, false)
+	if rs.StatusCode != http.StatusForbidden {
+		t.Error("only admin can rename")
+	}
+	buf.Reset()
+	_ = json.NewEncoder(buf).Encode(modu)
+	_, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, cfg.GetAdmin(), t, false)
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("user must be renamed", rs.StatusCode)
+	}
+	u, ok := cfg.GetUserByUsername("user3")
+	if !ok || u.DavHandler == nil || len(u.Shares) != 2 {
+		t.Fatal("renamed user lost data")
+	}
+	if _, ok = cfg.GetUserByUsername("user1"); ok {
+		t.Error("old name must be free")
+	}
+}
 func TestUserDelete(t *testing.T) {
 	cfg := TServContext{}
 	cfg.InitServ(t)
 	defer cfg.Clean(t)
-	dat := map[string]interface{}{"u": "/user1", "method": http.MethodDelete}
-
+	dat := map[string]interface{}{"u": "/user1", "method": http.MethodDelete, "mode": "purge", "dryRun": "true"}
 	_, rs, _ := cfg.MakeRequest(cnst.R_USERS, dat, cfg.GetAdmin(), t, false)
+	if _, ok := cfg.GetUserByUsername("user1"); rs.StatusCode != http.StatusOK || !ok {
+		t.Error("dry run must keep user")
+	}
+	dat["mode"], dat["target"], dat["dryRun"] = "move", "nobody", "false"
+	_, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, cfg.GetAdmin(), t, false)
+	if rs.StatusCode != http.StatusBadRequest {
+		t.Error("unknown target must be rejected")
+	}
+	delete(dat, "mode")
+	_, rs, _ = cfg.MakeRequest(cnst.R_USERS, dat, cfg.GetAdmin(), t, false)
 	if rs.StatusCode != http.StatusOK {
 		t.Error("user not allowed to modify other users")
 	}
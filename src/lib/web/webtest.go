@@ -3,6 +3,7 @@ package web
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"github.com/browsefile/backend/src/cnst"
 	"github.com/browsefile/backend/src/config"
 	"github.com/browsefile/backend/src/lib"
@@ -28,12 +29,22 @@ type TServContext struct {
 
 func (tc *TServContext) MakeRequest(r int, params map[string]interface{}, usr *config.UserConfig, t *testing.T, isShare bool) (*http.Request, *http.Response, *http.Transport) {
 	if usr != nil {
+		//token must belong to registered session
+		sid := ""
+		if !usr.IsGuest() {
+			sid = fmt.Sprint(time.Now().UnixNano())
+			now := time.Now()
+			if err := tc.AddSession(usr.Username, &config.Session{ID: sid, Created: now, Expires: now.Add(tokenTTL)}); err != nil {
+				t.Fatal(err)
+			}
+		}
 		// Builds the claims.
 		claims := Claims{
 			*lib.ToUserModel(usr, tc.GlobalConfig),
 			jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
+				ExpiresAt: time.Now().Add(tokenTTL).Unix(),
 				Issuer:    "Browse File",
+				Id:        sid,
 			},
 		}
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -120,6 +131,9 @@ func (tc *TServContext) BuildUrl(r int, params map[string]interface{}, isShare b
 		if dst, ok := params["destination"]; ok {
 			q.Set("destination", dst.(string))
 		}
+		if act, ok := params["action"]; ok {
+			q.Set("action", act.(string))
+		}
 	case cnst.R_SHARES:
 		parsedURL += "/shares" + urlSuf
 		if share, ok := params["share"]; ok {
@@ -127,6 +141,20 @@ func (tc *TServContext) BuildUrl(r int, params map[string]interface{}, isShare b
 		}
 	case cnst.R_USERS:
 		parsedURL += "/users" + urlSuf
+		for _, k := range []string{"mode", "target", "dryRun"} {
+			if v, ok := params[k]; ok {
+				q.Set(k, v.(string))
+			}
+		}
+	case cnst.R_GROUPS:
+		parsedURL += "/groups" + urlSuf
+	case cnst.R_SETTINGS:
+		parsedURL += "/settings" + urlSuf
+		for _, k := range []string{"from", "to"} {
+			if v, ok := params[k]; ok {
+				q.Set(k, v.(string))
+			}
+		}
 	}
 	_, ok := params[cnst.P_PREVIEW_TYPE]
 	if ok {
@@ -153,6 +181,8 @@ func (tc *TServContext) InitServ(t *testing.T) {
 	cfg.WriteConfig()
 
 	cfg.Srv = httptest.NewServer(SetupHandler(cfg.GlobalConfig))
+	//failed logins of previous tests
+	lib.ClearLockout("", "")
 	cfg.Tr = &http.Transport{}
 	_ = http2.ConfigureTransport(cfg.Tr)
 	tc.TContext = cfg
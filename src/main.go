@@ -1,19 +1,20 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"github.com/browsefile/backend/src/cnst"
 	"github.com/browsefile/backend/src/config"
+	"github.com/browsefile/backend/src/lib/cli"
 	"github.com/browsefile/backend/src/lib/web"
 	"log"
-	"net"
-	"net/http"
+	"os/signal"
 	"runtime"
+	"syscall"
 
 	//_ "net/http/pprof"
 	"os"
-	"strconv"
-	"time"
 )
 
 func main() {
@@ -23,66 +24,84 @@ func main() {
 	}()*/
 	fmt.Println("browsefile", cnst.Version)
 	cfg := new(config.GlobalConfig)
-	if len(os.Args) > 1 {
-		if os.Args[1] == "-h" {
-			fmt.Printf("Default config file locations : '%s', '%s'. Also you can specify own by passing path as first argument.", cnst.FilePath1, cnst.FilePath2)
-			os.Exit(0)
-		} else {
-			cfg.Path = os.Args[1]
-		}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validate(os.Args[2:]))
 	}
-
-	cfg.ReadConfigFile()
-	var listener, listenerTLS net.Listener
-	var err error
-	isHttp := cfg.Http != nil && cfg.Http.Port > 0
-	isTLS := cfg.Tls != nil && cfg.Tls.Port > 0 && len(cfg.TLSCert) > 0 && len(cfg.TLSCert) > 0
-	// Builds the address and a listener.
-	if isHttp {
-		listener, err = net.Listen("tcp", cfg.Http.IP+":"+strconv.Itoa(cfg.Http.Port))
-		if err != nil {
-			log.Fatal(err)
-		}
+	//settings layers: defaults, file, BF_* environment, flags
+	fs := flag.NewFlagSet("browsefile", flag.ExitOnError)
+	fs.StringVar(&cfg.Path, "config", "", "path to config file")
+	config.DefineFlags(fs)
+	fs.Usage = func() {
+		fmt.Printf("usage: browsefile [flags] [config path]\n       browsefile validate <path> : check config file without starting server\n")
+		fmt.Println("       browsefile [-config path] <command> : admin command, see 'browsefile user'")
+		fmt.Printf("Default config file locations : '%s', '%s'. Also you can specify own by passing path as first argument.\n", cnst.FilePath1, cnst.FilePath2)
+		fmt.Println("Every setting can be set by BF_* environment variable or flag, such settings are read-only and never written to the config file.")
+		fs.PrintDefaults()
 	}
-	if isTLS {
-		listenerTLS, err = net.Listen("tcp", cfg.Tls.IP+":"+strconv.Itoa(cfg.Tls.Port))
-		if err != nil {
-			log.Fatal(err)
-		}
+	_ = fs.Parse(os.Args[1:])
+	if err := cfg.SetOverrides(config.FlagOverrides(fs)); err != nil {
+		log.Fatal(err)
+	}
+	if cli.IsCommand(fs.Args()) {
+		os.Exit(cli.Run(cfg, fs.Args(), os.Stdin, os.Stdout))
+	}
+	if fs.NArg() > 0 {
+		cfg.Path = fs.Arg(0)
 	}
 
-	srv := &http.Server{Handler: web.SetupHandler(cfg), ReadTimeout: 5 * time.Hour, WriteTimeout: 5 * time.Hour}
-	// Tell the user the port in which is listening.
-	if isHttp {
-		log.Println("Listening http://" + listener.Addr().String())
-		log.Println("dav://" + listener.Addr().String() + cnst.WEB_DAV_URL)
+	if err := cfg.ReadConfigFile(); err != nil {
+		log.Fatal(err)
 	}
-	if isTLS {
-		log.Println()
-		log.Println("Listening https://" + listenerTLS.Addr().String())
-		log.Println("davs://" + listenerTLS.Addr().String() + cnst.WEB_DAV_URL)
-		if isHttp {
-			go func() {
-				err = srv.ServeTLS(listenerTLS, cfg.TLSCert, cfg.TLSKey)
-				if err != nil {
-					log.Fatal(err)
-				}
-			}()
-		} else {
-			err = srv.ServeTLS(listenerTLS, cfg.TLSCert, cfg.TLSKey)
+	srv := web.NewServer(cfg)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	for {
+		select {
+		case err := <-errs:
+			// Starts the server.
 			if err != nil {
 				log.Fatal(err)
 			}
+			return
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				srv.Reload()
+				continue
+			}
+			log.Printf("received %v, shutting down", s)
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.GetShutdownTimeout())
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Println(err)
+			}
+			cancel()
+			return
 		}
-
 	}
-	if isHttp {
-		err = srv.Serve(listener)
+}
 
-		// Starts the server.
-		if err != nil {
-			log.Fatal(err)
-		}
+//print all config problems, exit code 1 in case config can't be used
+func validate(args []string) int {
+	if len(args) != 1 {
+		fmt.Println("usage: browsefile validate <path>")
+		return 2
 	}
-
+	errs, err := config.ValidateConfigFile(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	for _, e := range errs {
+		fmt.Println(e)
+	}
+	if errs.HasErrors() {
+		return 1
+	}
+	fmt.Println(args[0] + " is valid")
+	return 0
 }